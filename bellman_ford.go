@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"time"
 )
 
 type NextStep struct {
@@ -11,84 +13,330 @@ type NextStep struct {
 
 type BellmanFordProcess struct {
 	Process
+	Options BellmanFordOptions
 	shortestNextSteps map[ProcessID]NextStep
+	// Step at which each route was last confirmed by its stepping stone.
+	refreshedAt map[ProcessID]int
 	hasStarted bool
 	neighbors map[ProcessID]struct{}
+	// Cost of the link to each neighbor; 1 if not listed.
+	weights map[ProcessID]int
 	deliveryQueue chan RoutedMessage
+	steps int
+	lastBroadcast int
+	// Whether routes changed since they were last broadcast.
+	routesChanged bool
+	stats RoutingStats
+}
+
+// Implemented by routing processes, so they can be swapped and compared.
+type Router interface {
+	Process
+	// Total cost of the best known path to each reachable destination.
+	Distances() map[ProcessID]int
+	RoutingStats() RoutingStats
+}
+
+type RoutingStats struct {
+	// Routing protocol messages sent, not counting forwarded inner messages.
+	ControlMessages int
+	// The step at which the routing table last changed,
+	// which is when it converged if nothing changes after.
+	LastRouteChange int
+}
+
+// Knobs for how BellmanFordProcess copes with links going down.
+// Times are counted in calls to Step.
+type BellmanFordOptions struct {
+	// Distance at which a destination counts as unreachable.
+	// Counting to infinity stops here.
+	Infinity int
+	// Don't advertise a route to the neighbor it goes through.
+	SplitHorizon bool
+	// Advertise a route to the neighbor it goes through as unreachable.
+	// Takes precedence over SplitHorizon.
+	PoisonReverse bool
+	// Broadcast as soon as routes change, rather than at the next refresh.
+	TriggeredUpdates bool
+	// Steps between broadcasts of the full table; 0 disables them.
+	RefreshInterval int
+	// Steps a route may go unconfirmed by its stepping stone before it
+	// expires; 0 disables expiry. Needs a RefreshInterval well below it.
+	RouteTimeout int
+	// Changes to routes to these destinations are logged.
+	LoggedDestinations map[ProcessID]struct{}
 }
 
 const (
 	innerDeliveryQueueSize = 1000
+	DefaultInfinity = 16
 )
 
+func DefaultBellmanFordOptions() BellmanFordOptions {
+	return BellmanFordOptions{
+		Infinity: DefaultInfinity,
+		TriggeredUpdates: true,
+	}
+}
+
+// The subprocess talks to its peers through a MultiTCPProcess layered
+// above routing, so its messages are reliable end to end, whatever
+// the routers along the way lose.
 func NewBellmanFordTopologyNode(
 	subprocess Process,
 	neighbors map[ProcessID]struct{},
 ) TopologyNode {
 	return TopologyNode{
-		Subprocess: &BellmanFordProcess{
-			Process: &MultiTCPProcess{
-				Process: subprocess,
-			},
-			shortestNextSteps: map[ProcessID]NextStep{
-				subprocess.Id(): NextStep{steppingStone: subprocess.Id()},
-			},
-			neighbors: neighbors,
-			deliveryQueue: make(chan RoutedMessage, innerDeliveryQueueSize),
-		},
+		Subprocess: newBellmanFordProcess(
+			&MultiTCPProcess{Process: subprocess},
+			neighbors, nil, DefaultBellmanFordOptions(),
+		),
+		Neighbors: neighbors,
+	}
+}
+
+// Like NewBellmanFordTopologyNode, but routes over least-cost paths
+// using each link's Weight, and delays messages by each link's Latency.
+func NewWeightedBellmanFordTopologyNode(
+	subprocess Process,
+	links map[ProcessID]Link,
+) TopologyNode {
+	return NewBellmanFordTopologyNodeWithOptions(
+		subprocess, links, DefaultBellmanFordOptions(),
+	)
+}
+
+func NewBellmanFordTopologyNodeWithOptions(
+	subprocess Process,
+	links map[ProcessID]Link,
+	options BellmanFordOptions,
+) TopologyNode {
+	neighbors := make(map[ProcessID]struct{}, len(links))
+	weights := make(map[ProcessID]int, len(links))
+	for pid, link := range links {
+		neighbors[pid] = struct{}{}
+		if link.Weight > 0 {
+			weights[pid] = link.Weight
+		}
+	}
+	return TopologyNode{
+		Subprocess: newBellmanFordProcess(
+			&MultiTCPProcess{Process: subprocess},
+			neighbors, weights, options,
+		),
 		Neighbors: neighbors,
+		Links: links,
+	}
+}
+
+// Routes for inner, which is usually the transport layer.
+func newBellmanFordProcess(
+	inner Process,
+	neighbors map[ProcessID]struct{},
+	weights map[ProcessID]int,
+	options BellmanFordOptions,
+) *BellmanFordProcess {
+	// Links come and go, so keep our own copy of the neighbors.
+	ownNeighbors := make(map[ProcessID]struct{}, len(neighbors))
+	for neighbor := range neighbors {
+		ownNeighbors[neighbor] = struct{}{}
+	}
+	return &BellmanFordProcess{
+		Process: inner,
+		Options: options,
+		shortestNextSteps: map[ProcessID]NextStep{
+			inner.Id(): NextStep{steppingStone: inner.Id()},
+		},
+		refreshedAt: make(map[ProcessID]int),
+		neighbors: ownNeighbors,
+		weights: weights,
+		deliveryQueue: make(chan RoutedMessage, innerDeliveryQueueSize),
 	}
 }
 
+func (p *BellmanFordProcess) cost(neighbor ProcessID) int {
+	if weight, ok := p.weights[neighbor]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (p *BellmanFordProcess) infinity() int {
+	if p.Options.Infinity > 0 {
+		return p.Options.Infinity
+	}
+	return DefaultInfinity
+}
+
+// Total cost of the best known path to each reachable destination.
+func (p *BellmanFordProcess) Distances() map[ProcessID]int {
+	distances := make(map[ProcessID]int, len(p.shortestNextSteps))
+	for dest, nextStep := range p.shortestNextSteps {
+		if nextStep.totalDistance < p.infinity() {
+			distances[dest] = nextStep.totalDistance
+		}
+	}
+	return distances
+}
+
+func (p *BellmanFordProcess) RoutingStats() RoutingStats {
+	return p.stats
+}
+
+func (p *BellmanFordProcess) nextHop(dest ProcessID) (ProcessID, bool) {
+	nextStep, ok := p.shortestNextSteps[dest]
+	if !ok || nextStep.totalDistance >= p.infinity() {
+		return 0, false
+	}
+	return nextStep.steppingStone, true
+}
+
+func (p *BellmanFordProcess) setRoute(dest ProcessID, steppingStone ProcessID, distance int) {
+	if distance > p.infinity() {
+		distance = p.infinity()
+	}
+	p.shortestNextSteps[dest] = NextStep{
+		steppingStone: steppingStone,
+		totalDistance: distance,
+	}
+	p.refreshedAt[dest] = p.steps
+	p.routesChanged = true
+	p.stats.LastRouteChange = p.steps
+	if _, ok := p.Options.LoggedDestinations[dest]; ok {
+		if distance >= p.infinity() {
+			Log(p, fmt.Sprintf("%s is unreachable", dest))
+		} else {
+			Log(p, fmt.Sprintf("route to %s costs %d via %s", dest, distance, steppingStone))
+		}
+	}
+}
+
+// The routes to advertise to one neighbor.
+// Each neighbor gets its own copy, since they read it concurrently.
+func (p *BellmanFordProcess) advertisement(neighbor ProcessID) map[ProcessID]NextStep {
+	shortestNextSteps := make(map[ProcessID]NextStep, len(p.shortestNextSteps))
+	for dest, nextStep := range p.shortestNextSteps {
+		if nextStep.steppingStone == neighbor {
+			if p.Options.PoisonReverse {
+				nextStep.totalDistance = p.infinity()
+			} else if p.Options.SplitHorizon {
+				continue
+			}
+		}
+		shortestNextSteps[dest] = nextStep
+	}
+	return shortestNextSteps
+}
 
 func (p *BellmanFordProcess) broadcast(send func(RoutedMessage)) {
-	for neighbor := range p.neighbors {
+	for _, neighbor := range sortedSet(p.neighbors) {
 		send(RoutedMessage{
 			Message: BellmanFordUpdateMessage{
-				shortestNextSteps: p.shortestNextSteps,
+				shortestNextSteps: p.advertisement(neighbor),
 			},
 			From: p.Id(),
 			To: neighbor,
 		})
+		p.stats.ControlMessages += 1
 	}
+	p.lastBroadcast = p.steps
+	p.routesChanged = false
 }
 
 func (p *BellmanFordProcess) update(
 	m BellmanFordUpdateMessage, 
 	from ProcessID,
 ) bool {
+	if _, ok := p.neighbors[from]; !ok {
+		// Sent before the link went down, or over a one-way link
+		// that we cannot forward back over.
+		return false
+	}
 	hadUpdate := false
+	cost := p.cost(from)
 	for dest, nextStep := range m.shortestNextSteps {
+		if dest == p.Id() {
+			continue
+		}
+		distance := cost + nextStep.totalDistance
+		if distance > p.infinity() {
+			distance = p.infinity()
+		}
 		if knownNextStep, ok := p.shortestNextSteps[dest]; ok {
-			if knownNextStep.totalDistance > 1 + nextStep.totalDistance {
-				p.shortestNextSteps[dest] = NextStep{
-					steppingStone: from,
-					totalDistance: 1 + nextStep.totalDistance,
+			if knownNextStep.steppingStone == from {
+				// Our route goes through the sender,
+				// so believe its news, good or bad.
+				p.refreshedAt[dest] = p.steps
+				if knownNextStep.totalDistance != distance {
+					p.setRoute(dest, from, distance)
+					hadUpdate = true
 				}
+			} else if knownNextStep.totalDistance > distance {
+				p.setRoute(dest, from, distance)
 				hadUpdate = true
 			}	
-		} else {
-			p.shortestNextSteps[dest] = NextStep{
-				steppingStone: from,
-				totalDistance: 1 + nextStep.totalDistance,
-			}
+		} else if distance < p.infinity() {
+			p.setRoute(dest, from, distance)
 			hadUpdate = true
 		}
 	}
 	return hadUpdate
 }
 
+// Withdraw routes whose stepping stone has gone quiet.
+func (p *BellmanFordProcess) expireRoutes() {
+	if p.Options.RouteTimeout <= 0 {
+		return
+	}
+	for dest, nextStep := range p.shortestNextSteps {
+		if dest == p.Id() || nextStep.totalDistance >= p.infinity() {
+			continue
+		}
+		if p.steps-p.refreshedAt[dest] > p.Options.RouteTimeout {
+			p.setRoute(dest, nextStep.steppingStone, p.infinity())
+		}
+	}
+}
+
+// Called by the link layer when a link is added while the cluster runs.
+func (p *BellmanFordProcess) LinkAdded(neighbor ProcessID, link Link) {
+	if link.Weight <= 0 {
+		delete(p.weights, neighbor)
+		return
+	}
+	if p.weights == nil {
+		p.weights = make(map[ProcessID]int)
+	}
+	p.weights[neighbor] = link.Weight
+}
+
+// Called by the link layer when the link to a neighbor fails or recovers.
+func (p *BellmanFordProcess) LinkChanged(neighbor ProcessID, up bool) {
+	if up {
+		p.neighbors[neighbor] = struct{}{}
+		// The neighbor needs to hear our routes.
+		p.routesChanged = true
+		return
+	}
+	delete(p.neighbors, neighbor)
+	for dest, nextStep := range p.shortestNextSteps {
+		if nextStep.steppingStone == neighbor && nextStep.totalDistance < p.infinity() {
+			p.setRoute(dest, neighbor, p.infinity())
+		}
+	}
+}
+
 func (p *BellmanFordProcess) innerStep(send func(RoutedMessage)) {
 	if p.Process == nil {
 		return
 	}
 	p.Process.Step(
 		func(m RoutedMessage) {
-			if nextStep, ok := p.shortestNextSteps[m.To]; ok {
+			if steppingStone, ok := p.nextHop(m.To); ok {
 				send(RoutedMessage{
 					Message: m,
 					From: p.Id(),
-					To: nextStep.steppingStone,
+					To: steppingStone,
 				})
 			} else {
 				Log(p, fmt.Sprintf("unable to deliver message %v", m))
@@ -111,10 +359,17 @@ func (p *BellmanFordProcess) Step(
 	receive func() *RoutedMessage,
 ) {
 	defer p.innerStep(send)
+	p.steps += 1
 	if !p.hasStarted {
 		p.broadcast(send)
 		p.hasStarted = true
 	}
+	p.expireRoutes()
+	if p.Options.RefreshInterval > 0 && p.steps-p.lastBroadcast >= p.Options.RefreshInterval {
+		p.broadcast(send)
+	} else if p.routesChanged && p.Options.TriggeredUpdates {
+		p.broadcast(send)
+	}
 	// We need to look for messages even if our 
 	// inner process doesn't call 'receive',
 	// to receive Update messages as they arrive. 
@@ -126,7 +381,7 @@ func (p *BellmanFordProcess) Step(
 	}
 	messageReceived := received.Message
 	if updateMessage, ok := messageReceived.(BellmanFordUpdateMessage); ok {
-		if p.update(updateMessage, received.From) {
+		if p.update(updateMessage, received.From) && p.Options.TriggeredUpdates {
 			p.broadcast(send)
 		}
 	} else if innerMessage, ok := messageReceived.(RoutedMessage); ok {
@@ -139,11 +394,11 @@ func (p *BellmanFordProcess) Step(
 			}
 		} else {
 			// pass it on, if we know the route
-			if nextStep, ok := p.shortestNextSteps[innerMessage.To]; ok {
+			if steppingStone, ok := p.nextHop(innerMessage.To); ok {
 				send(RoutedMessage{
 					Message: innerMessage,
 					From: p.Id(),
-					To: nextStep.steppingStone,
+					To: steppingStone,
 				})
 			} else {
 				// Otherwise, drop it.
@@ -166,9 +421,59 @@ func (m BellmanFordUpdateMessage) String() string {
 	return fmt.Sprintf("next steps: %v", m.shortestNextSteps)
 }
 
+func (m BellmanFordUpdateMessage) MessageTag() string {
+	return "bellman-ford"
+}
+
+// Checksummed, as RIP is by UDP, so corrupted updates are discarded.
+func (m BellmanFordUpdateMessage) Encode(e *Encoder) {
+	e.Checksummed(func() {
+		dests := make([]int, 0, len(m.shortestNextSteps))
+		for dest := range m.shortestNextSteps {
+			dests = append(dests, int(dest))
+		}
+		sort.Ints(dests)
+		e.WriteInt(len(dests))
+		for _, dest := range dests {
+			nextStep := m.shortestNextSteps[ProcessID(dest)]
+			e.WriteInt(dest)
+			e.WriteInt(int(nextStep.steppingStone))
+			e.WriteInt(nextStep.totalDistance)
+		}
+	})
+}
+
+func init() {
+	RegisterMessageType("bellman-ford", func(d *Decoder) Message {
+		n := d.ReadInt()
+		if n < 0 || n > len(d.buf) {
+			d.fail(fmt.Errorf("route count %d out of range", n))
+			return nil
+		}
+		m := BellmanFordUpdateMessage{
+			shortestNextSteps: make(map[ProcessID]NextStep, n),
+		}
+		for i := 0; i < n; i++ {
+			dest := ProcessID(d.ReadInt())
+			m.shortestNextSteps[dest] = NextStep{
+				steppingStone: ProcessID(d.ReadInt()),
+				totalDistance: d.ReadInt(),
+			}
+		}
+		return m
+	})
+}
+
 type BellmanFordScenario struct{}
 
 func (s BellmanFordScenario) Network() Topology {
+	return bellmanFordScenarioNetwork(NewBellmanFordTopologyNode)
+}
+
+// The graph of BellmanFordScenario, with each node routed by newNode.
+func bellmanFordScenarioNetwork(
+	newNode func(subprocess Process, neighbors map[ProcessID]struct{}) TopologyNode,
+) Topology {
 	//     2  -  3
 	//   /         \
 	// 1 - 4 - 5 - 6 - 8
@@ -176,7 +481,7 @@ func (s BellmanFordScenario) Network() Topology {
 	//     -   7   - 
 	// node 1 tries to communicate with 8, who sends an ACK
 	return map[ProcessID]TopologyNode {
-		1: NewBellmanFordTopologyNode(
+		1: newNode(
 			&ConversationProcess{
 				ID: 1,
 				FriendID: 8,
@@ -185,25 +490,25 @@ func (s BellmanFordScenario) Network() Topology {
 			},
 			map[ProcessID]struct{}{2: {}, 4: {}, 7: {}},
 		),
-		2: NewBellmanFordTopologyNode(SimpleProcess{ID: 2},
+		2: newNode(SimpleProcess{ID: 2},
 			map[ProcessID]struct{}{1: {}, 3: {}},
 		),
-		3: NewBellmanFordTopologyNode(SimpleProcess{ID: 3},
+		3: newNode(SimpleProcess{ID: 3},
 			map[ProcessID]struct{}{2: {}, 6: {}},
 		),
-		4: NewBellmanFordTopologyNode(SimpleProcess{ID: 4},
+		4: newNode(SimpleProcess{ID: 4},
 			map[ProcessID]struct{}{1: {}, 5: {}},
 		),
-		5: NewBellmanFordTopologyNode(SimpleProcess{ID: 5},
+		5: newNode(SimpleProcess{ID: 5},
 			map[ProcessID]struct{}{4: {}, 6: {}, 7: {}},
 		),
-		6: NewBellmanFordTopologyNode(SimpleProcess{ID: 6},
+		6: newNode(SimpleProcess{ID: 6},
 			map[ProcessID]struct{}{3: {}, 5: {}, 8: {}},
 		),
-		7: NewBellmanFordTopologyNode(SimpleProcess{ID: 7},
+		7: newNode(SimpleProcess{ID: 7},
 			map[ProcessID]struct{}{1: {}, 5: {}, 8: {}},
 		),
-		8: NewBellmanFordTopologyNode(
+		8: newNode(
 			&ConversationProcess{
 				ID: 8,
 				FriendID: 1,
@@ -213,3 +518,222 @@ func (s BellmanFordScenario) Network() Topology {
 		),
 	}
 }
+
+// BellmanFordScenario with unreliable routers: every node between 1 and 8
+// loses and reorders some of what it sends, including routing updates.
+// TCP runs between 1 and 8 themselves, so their conversation still arrives
+// exactly once and in order, and periodic refreshes repair lost updates.
+// Routers may also flip bits in what they send: the checksums on TCP
+// segments, routing headers and routing updates catch every flip.
+type LossyRoutingScenario struct {
+	DropRate float64
+	CorruptRate float64
+}
+
+func (s LossyRoutingScenario) Network() Topology {
+	options := DefaultBellmanFordOptions()
+	options.RefreshInterval = 30
+	return bellmanFordScenarioNetwork(func(
+		subprocess Process,
+		neighbors map[ProcessID]struct{},
+	) TopologyNode {
+		links := make(map[ProcessID]Link, len(neighbors))
+		for neighbor := range neighbors {
+			links[neighbor] = Link{}
+		}
+		node := NewBellmanFordTopologyNodeWithOptions(subprocess, links, options)
+		if id := subprocess.Id(); id != 1 && id != 8 {
+			node.Faults = LinkFaults{DropRate: s.DropRate, ReorderRate: s.DropRate, CorruptRate: s.CorruptRate}
+		}
+		return node
+	})
+}
+
+// An undirected edge, for building scenario graphs.
+type weightedEdge struct {
+	a, b ProcessID
+	link Link
+}
+
+// The links of each node, with every edge usable in both directions.
+func symmetricLinks(edges []weightedEdge) map[ProcessID]map[ProcessID]Link {
+	links := make(map[ProcessID]map[ProcessID]Link)
+	for _, e := range edges {
+		for _, ends := range [][2]ProcessID{{e.a, e.b}, {e.b, e.a}} {
+			if links[ends[0]] == nil {
+				links[ends[0]] = make(map[ProcessID]Link)
+			}
+			links[ends[0]][ends[1]] = e.link
+		}
+	}
+	return links
+}
+
+// The processes of BellmanFordScenario: 1 and 8 chat, the rest only forward.
+func bellmanFordScenarioProcess(id ProcessID) Process {
+	switch id {
+	case 1:
+		return &ConversationProcess{
+			ID: 1,
+			FriendID: 8,
+			Phrases: []string{"hi there", "what's up"},
+			Initiate: true,
+		}
+	case 8:
+		return &ConversationProcess{
+			ID: 8,
+			FriendID: 1,
+			Phrases: []string{"hi", "all good"},
+		}
+	}
+	return SimpleProcess{ID: id}
+}
+
+type WeightedBellmanFordScenario struct{}
+
+func (s WeightedBellmanFordScenario) Network() Topology {
+	// The graph of BellmanFordScenario, with link costs.
+	// The fewest hops from 1 to 8 go through 7, but those links are
+	// expensive and slow, so the cheapest path is 1-4-5-6-8.
+	//       2  -3-  3
+	//    1/            \2
+	//  1 -1- 4 -1- 5 -1- 6 -1- 8
+	//    \         |1        /
+	//     5 -----  7  ----- 5
+	slow := Link{Weight: 5, Latency: 50 * time.Millisecond}
+	links := symmetricLinks([]weightedEdge{
+		{1, 2, Link{Weight: 1}},
+		{2, 3, Link{Weight: 3}},
+		{3, 6, Link{Weight: 2}},
+		{1, 4, Link{Weight: 1}},
+		{4, 5, Link{Weight: 1}},
+		{5, 6, Link{Weight: 1}},
+		{6, 8, Link{Weight: 1}},
+		{1, 7, slow},
+		{5, 7, Link{Weight: 1}},
+		{7, 8, slow},
+	})
+	topo := make(Topology, len(links))
+	for id, nodeLinks := range links {
+		topo[id] = NewWeightedBellmanFordTopologyNode(bellmanFordScenarioProcess(id), nodeLinks)
+	}
+	return topo
+}
+
+// The graph of BellmanFordScenario with an expensive backup link 7-8.
+// After a second the 6-8 link is cut, and routes to 8 have to move over to
+// the backup. Without mitigations, nodes around the cut bounce stale routes
+// off each other and count up slowly, one refresh at a time; with split
+// horizon, poison reverse and triggered updates they converge quickly.
+type BellmanFordLinkFailureScenario struct {
+	Mitigations bool
+}
+
+func (s BellmanFordLinkFailureScenario) Network() Topology {
+	//     2  -  3
+	//   /         \
+	// 1 - 4 - 5 - 6 - 8
+	//   \     |       /
+	//     --- 7 -10-
+	options := BellmanFordOptions{
+		Infinity: 32,
+		RefreshInterval: 30,
+		RouteTimeout: 180,
+		LoggedDestinations: map[ProcessID]struct{}{8: {}},
+	}
+	if s.Mitigations {
+		options.SplitHorizon = true
+		options.PoisonReverse = true
+		options.TriggeredUpdates = true
+	}
+	links := symmetricLinks([]weightedEdge{
+		{1, 2, Link{}},
+		{2, 3, Link{}},
+		{3, 6, Link{}},
+		{1, 4, Link{}},
+		{4, 5, Link{}},
+		{5, 6, Link{}},
+		{6, 8, Link{}},
+		{1, 7, Link{}},
+		{5, 7, Link{}},
+		{7, 8, Link{Weight: 10}},
+	})
+	topo := make(Topology, len(links))
+	for id, nodeLinks := range links {
+		topo[id] = NewBellmanFordTopologyNodeWithOptions(
+			bellmanFordScenarioProcess(id), nodeLinks, options,
+		)
+	}
+	return topo
+}
+
+func (s BellmanFordLinkFailureScenario) Script(c Cluster) {
+	c.Sleep(time.Second)
+	fmt.Fprintln(logOutput, "cutting link 6-8")
+	c.CutLink(6, 8)
+}
+
+func (s BellmanFordScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s BellmanFordScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func (s LossyRoutingScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s LossyRoutingScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func (s WeightedBellmanFordScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s WeightedBellmanFordScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func (s BellmanFordLinkFailureScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, withoutLink(s.Network(), 6, 8))
+}
+
+func (s BellmanFordLinkFailureScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func init() {
+	RegisterScenario("bellman-ford", "a conversation routed by Bellman-Ford", func(ScenarioParams) Scenario {
+		return BellmanFordScenario{}
+	})
+	RegisterScenario("lossy-routing", "Bellman-Ford over routers that drop DropRate of messages", func(params ScenarioParams) Scenario {
+		return LossyRoutingScenario{DropRate: params.DropRate}
+	})
+	RegisterScenario("corrupt-routing", "Bellman-Ford over routers that corrupt DropRate of messages", func(params ScenarioParams) Scenario {
+		return LossyRoutingScenario{CorruptRate: params.DropRate}
+	})
+	RegisterScenario("weighted-bellman-ford", "Bellman-Ford over weighted, slow links", func(ScenarioParams) Scenario {
+		return WeightedBellmanFordScenario{}
+	})
+	RegisterScenario("link-failure", "Bellman-Ford reconverging after a link fails", func(ScenarioParams) Scenario {
+		return BellmanFordLinkFailureScenario{Mitigations: true}
+	})
+	RegisterScenario("count-to-infinity", "link-failure without split horizon or poison reverse", func(ScenarioParams) Scenario {
+		return BellmanFordLinkFailureScenario{}
+	})
+}
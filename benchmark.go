@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Runs an algorithm over families of topologies at several sizes, with
+// several seeds each, and estimates the messages and rounds it takes to
+// settle, with 95% confidence intervals. Dividing the messages by the
+// algorithm's bound shows whether the bound holds: the ratio stays flat
+// as the size grows if it is tight, and shrinks if it is loose.
+
+type benchmarkAlgorithm struct {
+	description string
+	// The topologies it runs on; the first is the default.
+	topologies []string
+	// Its message complexity, for n nodes and m links, with ids in
+	// random order.
+	bound string
+	boundOf func(n, m int) float64
+	newNode func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode
+}
+
+var anyGraph = []string{"random", "ring", "line", "star", "grid", "tree", "complete"}
+
+func nLogN(n, m int) float64 {
+	return float64(n) * math.Log2(float64(n))
+}
+
+var benchmarkAlgorithms = map[string]benchmarkAlgorithm{
+	"lcr": {
+		// shuffleIDs gives random ids, for which LCR sends n H(n) messages
+		// on average, H(n) being the nth harmonic number, about ln n.
+		description: "LCR leader election, n log n messages on average over random ids, n^2 at worst",
+		topologies: []string{"directed-ring"},
+		bound: "n log n",
+		boundOf: nLogN,
+		newNode: func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode {
+			return TopologyNode{Subprocess: &LCRProcess{ID: id}, Neighbors: neighbors}
+		},
+	},
+	"hs": {
+		description: "Hirschberg-Sinclair leader election",
+		topologies: []string{"ring"},
+		bound: "n log n",
+		boundOf: nLogN,
+		newNode: func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode {
+			return TopologyNode{Subprocess: &HSProcess{ID: id}, Neighbors: neighbors}
+		},
+	},
+	"leader-election": {
+		description: "leader election by flooding id lists",
+		topologies: anyGraph,
+		bound: "n m",
+		boundOf: func(n, m int) float64 {
+			return float64(n * m)
+		},
+		newNode: func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode {
+			return TopologyNode{
+				Subprocess: &LeaderElectionProcess{Process: SimpleProcess{ID: id}, GraphSize: n, Neighbors: neighbors},
+				Neighbors: neighbors,
+			}
+		},
+	},
+	"bellman-ford": {
+		description: "Bellman-Ford routing",
+		topologies: anyGraph,
+		bound: "n m",
+		boundOf: func(n, m int) float64 {
+			return float64(n * m)
+		},
+		newNode: func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode {
+			links := make(map[ProcessID]Link, len(neighbors))
+			for neighbor := range neighbors {
+				links[neighbor] = Link{}
+			}
+			// Paths may be longer than the default infinity.
+			options := DefaultBellmanFordOptions()
+			options.Infinity = n
+			return NewBellmanFordTopologyNodeWithOptions(SimpleProcess{ID: id}, links, options)
+		},
+	},
+	"broadcast": {
+		description: "broadcast by flooding from one node",
+		topologies: anyGraph,
+		bound: "m",
+		boundOf: func(n, m int) float64 {
+			return float64(m)
+		},
+		newNode: func(id ProcessID, neighbors map[ProcessID]struct{}, n int) TopologyNode {
+			return TopologyNode{Subprocess: &FloodProcess{ID: id, Origin: id == 0}, Neighbors: neighbors}
+		},
+	},
+}
+
+func BenchmarkAlgorithms() []string {
+	names := make([]string, 0, len(benchmarkAlgorithms))
+	for name := range benchmarkAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// What the algorithm is, the bound it is measured against and the
+// topologies it runs on.
+func BenchmarkDescription(name string) string {
+	algorithm := benchmarkAlgorithms[name]
+	return fmt.Sprintf("%s; against %s, on %s", algorithm.description, algorithm.bound, strings.Join(algorithm.topologies, ", "))
+}
+
+func placeholderProcess(id ProcessID) Process {
+	return SimpleProcess{ID: id}
+}
+
+// Families of topologies of about n nodes; grids are the nearest square.
+var benchmarkTopologies = map[string]func(n int, seed int64) Topology{
+	"ring": func(n int, seed int64) Topology {
+		return RingTopology(n, placeholderProcess)
+	},
+	"directed-ring": func(n int, seed int64) Topology {
+		return DirectedRingTopology(n, placeholderProcess)
+	},
+	"line": func(n int, seed int64) Topology {
+		return LineTopology(n, placeholderProcess)
+	},
+	"star": func(n int, seed int64) Topology {
+		return StarTopology(n, placeholderProcess)
+	},
+	"grid": func(n int, seed int64) Topology {
+		side := int(math.Round(math.Sqrt(float64(n))))
+		return GridTopology(side, side, placeholderProcess)
+	},
+	"tree": func(n int, seed int64) Topology {
+		return TreeTopology(n, 2, placeholderProcess)
+	},
+	"complete": func(n int, seed int64) Topology {
+		e := newEdges(n)
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				e.add(ProcessID(i), ProcessID(j))
+			}
+		}
+		return e.topology(placeholderProcess)
+	},
+	"random": func(n int, seed int64) Topology {
+		return ErdosRenyiTopology(n, 2/float64(n), seed, true, placeholderProcess)
+	},
+}
+
+// topo with its nodes renumbered at random, keeping its shape, so that
+// ids are not in order around rings or down trees.
+func shuffleIDs(topo Topology, seed int64) Topology {
+	ids := sortedIDs(topo)
+	order := rand.New(rand.NewSource(seed)).Perm(len(ids))
+	renamed := make(map[ProcessID]ProcessID, len(ids))
+	for i, id := range ids {
+		renamed[id] = ids[order[i]]
+	}
+	shuffled := make(Topology, len(topo))
+	for id, node := range topo {
+		newNode := TopologyNode{
+			Subprocess: placeholderProcess(renamed[id]),
+			Neighbors: make(map[ProcessID]struct{}, len(node.Neighbors)),
+			Faults: node.Faults,
+			AS: node.AS,
+		}
+		for neighbor := range node.Neighbors {
+			newNode.Neighbors[renamed[neighbor]] = struct{}{}
+		}
+		if node.Links != nil {
+			newNode.Links = make(map[ProcessID]Link, len(node.Links))
+			for neighbor, link := range node.Links {
+				newNode.Links[renamed[neighbor]] = link
+			}
+		}
+		shuffled[renamed[id]] = newNode
+	}
+	return shuffled
+}
+
+// Links counted once however many directions they go.
+func countLinks(topo Topology) int {
+	links := make(map[[2]ProcessID]struct{})
+	for id := range topo {
+		for neighbor := range topo.OutNeighbors(id) {
+			if id < neighbor {
+				links[[2]ProcessID{id, neighbor}] = struct{}{}
+			} else {
+				links[[2]ProcessID{neighbor, id}] = struct{}{}
+			}
+		}
+	}
+	return len(links)
+}
+
+type BenchmarkOptions struct {
+	Algorithm string
+	// Defaults to the algorithm's first.
+	Topologies []string
+	Sizes []int
+	// Runs of each size use seeds Seed to Seed+Seeds-1, for the graph,
+	// its ids and the simulation.
+	Seeds int
+	Seed int64
+	// Virtual time a run has to settle in.
+	Timeout time.Duration
+}
+
+func DefaultBenchmarkOptions() BenchmarkOptions {
+	return BenchmarkOptions{
+		Sizes: []int{8, 16, 32, 64},
+		Seeds: 10,
+		Seed: 1,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// A mean and the half-width of its 95% confidence interval.
+type Estimate struct {
+	Mean float64
+	Interval float64
+}
+
+func (e Estimate) String() string {
+	return fmt.Sprintf("%.4g ± %.2g", e.Mean, e.Interval)
+}
+
+// Two-sided 95% quantiles of Student's t distribution, by degrees of freedom.
+var tQuantiles = []float64{
+	math.NaN(), 12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func estimate(samples []float64) Estimate {
+	n := len(samples)
+	if n == 0 {
+		return Estimate{Mean: math.NaN(), Interval: math.NaN()}
+	}
+	sum := 0.0
+	for _, x := range samples {
+		sum += x
+	}
+	mean := sum / float64(n)
+	if n == 1 {
+		return Estimate{Mean: mean, Interval: math.NaN()}
+	}
+	squares := 0.0
+	for _, x := range samples {
+		squares += (x - mean) * (x - mean)
+	}
+	t := 1.96
+	if n-1 < len(tQuantiles) {
+		t = tQuantiles[n-1]
+	}
+	return Estimate{Mean: mean, Interval: t * math.Sqrt(squares/float64(n-1)/float64(n))}
+}
+
+// The runs of one topology and size. Estimates are over the runs that settled.
+type BenchmarkRow struct {
+	Topology string
+	Nodes int
+	Links Estimate
+	Runs int
+	Settled int
+	Messages Estimate
+	Rounds Estimate
+	Bytes Estimate
+	// Messages over the algorithm's bound.
+	Ratio Estimate
+}
+
+type BenchmarkResult struct {
+	Algorithm string
+	Bound string
+	Seeds int
+	Rows []BenchmarkRow
+}
+
+// Runs the benchmark, returning an error if the options don't make sense.
+func RunBenchmark(options BenchmarkOptions) (BenchmarkResult, error) {
+	algorithm, ok := benchmarkAlgorithms[options.Algorithm]
+	if !ok {
+		return BenchmarkResult{}, fmt.Errorf(
+			"unknown algorithm %q; choose from %s", options.Algorithm, strings.Join(BenchmarkAlgorithms(), ", "),
+		)
+	}
+	topologies := options.Topologies
+	if len(topologies) == 0 {
+		topologies = algorithm.topologies[:1]
+	}
+	for _, topology := range topologies {
+		if !containsString(algorithm.topologies, topology) {
+			return BenchmarkResult{}, fmt.Errorf(
+				"%s does not run on %q; choose from %s", options.Algorithm, topology, strings.Join(algorithm.topologies, ", "),
+			)
+		}
+	}
+	if options.Seeds < 1 {
+		return BenchmarkResult{}, fmt.Errorf("need at least one seed, not %d", options.Seeds)
+	}
+	result := BenchmarkResult{Algorithm: options.Algorithm, Bound: algorithm.bound, Seeds: options.Seeds}
+	for _, topology := range topologies {
+		for _, size := range options.Sizes {
+			if size < 1 {
+				return BenchmarkResult{}, fmt.Errorf("cannot make a graph of %d nodes", size)
+			}
+			result.Rows = append(result.Rows, benchmarkRow(algorithm, topology, size, options))
+		}
+	}
+	return result, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func benchmarkRow(algorithm benchmarkAlgorithm, topology string, size int, options BenchmarkOptions) BenchmarkRow {
+	row := BenchmarkRow{Topology: topology}
+	var links, messages, rounds, bytes, ratios []float64
+	for i := 0; i < options.Seeds; i++ {
+		seed := options.Seed + int64(i)
+		shape := shuffleIDs(benchmarkTopologies[topology](size, seed), seed)
+		n, m := len(shape), countLinks(shape)
+		row.Nodes = n
+		links = append(links, float64(m))
+		scenario := benchmarkScenario{shape: shape, algorithm: algorithm}
+		metrics := measureUntilSettled(scenario, options.Timeout, seed)
+		row.Runs++
+		if metrics.ReachedRound < 0 {
+			continue
+		}
+		row.Settled++
+		messages = append(messages, float64(metrics.ReachedMessages))
+		rounds = append(rounds, float64(metrics.ReachedRound))
+		bytes = append(bytes, float64(metrics.Total.Bytes))
+		if bound := algorithm.boundOf(n, m); bound > 0 {
+			ratios = append(ratios, float64(metrics.ReachedMessages)/bound)
+		}
+	}
+	row.Links = estimate(links)
+	row.Messages = estimate(messages)
+	row.Rounds = estimate(rounds)
+	row.Bytes = estimate(bytes)
+	row.Ratio = estimate(ratios)
+	return row
+}
+
+// An algorithm over a shape from benchmarkTopologies.
+type benchmarkScenario struct {
+	shape Topology
+	algorithm benchmarkAlgorithm
+}
+
+func (s benchmarkScenario) Network() Topology {
+	return MapTopology(s.shape, func(subprocess Process, neighbors map[ProcessID]struct{}) TopologyNode {
+		return s.algorithm.newNode(subprocess.Id(), neighbors, len(s.shape))
+	})
+}
+
+// Simulates scenario until it settles or timeout passes.
+// The run stops as it settles, so only messages up to then are counted.
+func measureUntilSettled(scenario Scenario, timeout time.Duration, seed int64) *Metrics {
+	m := NewMetrics()
+	s := newScenarioSimulation(scenario, seed)
+	s.afterRound = func() { m.observe(s) }
+	AddTracer(m)
+	defer RemoveTracer(m)
+	for s.Elapsed() < timeout && m.ReachedRound < 0 {
+		if err := s.Round(); err != nil {
+			panic(fmt.Sprintf("benchmark scenarios have no invariants, but: %s", err))
+		}
+	}
+	return m
+}
+
+func (r BenchmarkResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, messages and rounds to settle, with 95%% confidence intervals over %d seeds\n", r.Algorithm, r.Seeds)
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	bound := r.Bound
+	if strings.Contains(bound, " ") {
+		bound = "(" + bound + ")"
+	}
+	fmt.Fprintf(w, "topology\tn\tlinks\tsettled\tmessages\trounds\tmessages/%s\n", bound)
+	for _, row := range r.Rows {
+		fmt.Fprintf(w, "%s\t%d\t%.4g\t%d/%d\t%s\t%s\t%s\n",
+			row.Topology, row.Nodes, row.Links.Mean, row.Settled, row.Runs, row.Messages, row.Rounds, row.Ratio,
+		)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func (r BenchmarkResult) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"algorithm", "topology", "nodes", "links", "runs", "settled",
+		"messages", "messages_ci", "rounds", "rounds_ci", "bytes", "bytes_ci",
+		"bound", "ratio", "ratio_ci",
+	})
+	number := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	for _, row := range r.Rows {
+		out.Write([]string{
+			r.Algorithm, row.Topology, strconv.Itoa(row.Nodes), number(row.Links.Mean),
+			strconv.Itoa(row.Runs), strconv.Itoa(row.Settled),
+			number(row.Messages.Mean), number(row.Messages.Interval),
+			number(row.Rounds.Mean), number(row.Rounds.Interval),
+			number(row.Bytes.Mean), number(row.Bytes.Interval),
+			r.Bound, number(row.Ratio.Mean), number(row.Ratio.Interval),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Parses a comma-separated list of sizes, like 8,16,32.
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("bad size %q", field)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	e := estimate([]float64{1, 2, 3, 4})
+	// The standard error is sqrt(5/3)/2, and t with 3 degrees of freedom 3.182.
+	if e.Mean != 2.5 || math.Abs(e.Interval-3.182*math.Sqrt(5.0/3)/2) > 1e-9 {
+		t.Errorf("got %s", e)
+	}
+	if e := estimate([]float64{7}); e.Mean != 7 || !math.IsNaN(e.Interval) {
+		t.Errorf("one sample gave %s", e)
+	}
+}
+
+func TestShuffleIDs(t *testing.T) {
+	topo := LineTopology(10, placeholderProcess)
+	shuffled := shuffleIDs(topo, 3)
+	if len(shuffled) != 10 || countLinks(shuffled) != 9 {
+		t.Fatalf("%d nodes and %d links", len(shuffled), countLinks(shuffled))
+	}
+	if err := CheckTopology(shuffled); err != nil {
+		t.Fatal(err)
+	}
+	if diameter, _ := Diameter(shuffled); diameter != 9 {
+		t.Errorf("diameter %d, not 9", diameter)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	options := DefaultBenchmarkOptions()
+	options.Algorithm = "broadcast"
+	options.Topologies = []string{"random", "grid"}
+	options.Sizes = []int{9, 16}
+	options.Seeds = 3
+	result, err := RunBenchmark(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 4 {
+		t.Fatalf("%d rows", len(result.Rows))
+	}
+	for _, row := range result.Rows {
+		if row.Settled != 3 {
+			t.Errorf("%s of %d: %d of 3 settled", row.Topology, row.Nodes, row.Settled)
+		}
+		// Flooding sends 2m-n+1 messages.
+		if want := 2*row.Links.Mean - float64(row.Nodes) + 1; math.Abs(row.Messages.Mean-want) > 1e-9 {
+			t.Errorf("%s of %d: %g messages, not %g", row.Topology, row.Nodes, row.Messages.Mean, want)
+		}
+	}
+
+	options.Algorithm = "hs"
+	options.Topologies = nil
+	options.Sizes = []int{8, 32}
+	result, err = RunBenchmark(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range result.Rows {
+		if row.Topology != "ring" || row.Settled != 3 {
+			t.Errorf("%s of %d: %d of 3 settled", row.Topology, row.Nodes, row.Settled)
+		}
+		// Each phase sends at most 4n messages and there are 1+log n phases,
+		// with n more to announce the leader.
+		if limit := 4 * (1 + math.Log2(float64(row.Nodes))); row.Ratio.Mean > limit {
+			t.Errorf("%d nodes: %s messages per n log n", row.Nodes, row.Ratio)
+		}
+	}
+
+	options.Topologies = []string{"grid"}
+	if _, err := RunBenchmark(options); err == nil {
+		t.Error("ran hs on a grid")
+	}
+	options.Algorithm = "nope"
+	if _, err := RunBenchmark(options); err == nil {
+		t.Error("ran an unknown algorithm")
+	}
+}
+
+func TestHSSettles(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 7} {
+		params := DefaultScenarioParams()
+		params.GraphSize = size
+		scenario, err := NewScenario("hs", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SimulateScenario(scenario, time.Second, 1); err != nil {
+			t.Errorf("%d nodes: %s", size, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Broadcast by flooding: the origin sends its message to every
+// neighbor, and every other process passes the message on to all its
+// neighbors but the one it first heard it from, and ignores it after.
+// Each link carries the message once or twice, so 2m-n+1 messages
+// reach a connected graph of n nodes and m links.
+type FloodProcess struct {
+	ID ProcessID
+	Origin bool
+	Informed bool
+	neighbors []ProcessID
+	hasStarted bool
+}
+
+type FloodMessage struct {
+	Origin ProcessID
+}
+
+func (m FloodMessage) String() string {
+	return fmt.Sprintf("FLOOD(%s)", m.Origin)
+}
+
+func (m FloodMessage) MessageTag() string {
+	return "flood"
+}
+
+func (m FloodMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.Origin))
+}
+
+func init() {
+	RegisterMessageType("flood", func(d *Decoder) Message {
+		return FloodMessage{Origin: ProcessID(d.ReadInt())}
+	})
+}
+
+func (p *FloodProcess) Id() ProcessID {
+	return p.ID
+}
+
+func (p *FloodProcess) SetNeighbors(in map[ProcessID]struct{}, out map[ProcessID]struct{}) {
+	p.neighbors = sortedSet(out)
+}
+
+func (p *FloodProcess) pass(send func(RoutedMessage), m FloodMessage, except ProcessID) {
+	for _, neighbor := range p.neighbors {
+		if neighbor != except {
+			send(RoutedMessage{Message: m, From: p.ID, To: neighbor})
+		}
+	}
+}
+
+func (p *FloodProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	if !p.hasStarted {
+		p.hasStarted = true
+		if p.Origin {
+			p.Informed = true
+			p.pass(send, FloodMessage{Origin: p.ID}, p.ID)
+		}
+	}
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(FloodMessage)
+		if !ok {
+			panic(fmt.Sprintf("flood unexpected message type %T %s", received.Message, received.Message))
+		}
+		if p.Informed {
+			continue
+		}
+		p.Informed = true
+		p.pass(send, m, received.From)
+	}
+}
+
+// Whether every flooding process heard the broadcast.
+func floodsInformed(c Cluster) error {
+	for _, p := range clusterLayers(c) {
+		if flood, ok := p.(*FloodProcess); ok && !flood.Informed {
+			return fmt.Errorf("%s did not hear the broadcast", flood.ID)
+		}
+	}
+	return nil
+}
+
+// Node 0 floods a random connected graph of Nodes drawn from Seed.
+type BroadcastScenario struct {
+	Nodes int
+	Seed int64
+}
+
+func (s BroadcastScenario) Network() Topology {
+	return ErdosRenyiTopology(s.Nodes, 2/float64(s.Nodes), s.Seed, true, func(id ProcessID) Process {
+		return &FloodProcess{ID: id, Origin: id == 0}
+	})
+}
+
+func (s BroadcastScenario) Check(c Cluster) error {
+	return floodsInformed(c)
+}
+
+func init() {
+	RegisterScenario("broadcast", "flooding a broadcast from node 0 over a random graph of GraphSize drawn from Seed", func(params ScenarioParams) Scenario {
+		return BroadcastScenario{Nodes: params.GraphSize, Seed: params.Seed}
+	})
+}
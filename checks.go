@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Helpers for CheckedScenario, which look inside the wrapped processes
+// of a stopped cluster.
+
+// p and every process it wraps, from the outermost in,
+// including the children of a MuxProcess.
+func processLayers(p Process) []Process {
+	var layers []Process
+	for p != nil {
+		layers = append(layers, p)
+		if mux, ok := p.(*MuxProcess); ok {
+			for _, protocol := range mux.protocolNames() {
+				layers = append(layers, processLayers(mux.Protocols[protocol])...)
+			}
+			break
+		}
+		v := reflect.ValueOf(p)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			break
+		}
+		inner := v.FieldByName("Process")
+		if !inner.IsValid() || inner.Kind() != reflect.Interface || inner.IsNil() {
+			break
+		}
+		p, _ = inner.Interface().(Process)
+	}
+	return layers
+}
+
+// Every process in the cluster at any layer, in id order.
+func clusterLayers(c Cluster) []Process {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	ids := make([]ProcessID, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	sortProcessIDs(ids)
+	var layers []Process
+	for _, id := range ids {
+		layers = append(layers, processLayers(c[id].P)...)
+	}
+	return layers
+}
+
+// Whether both ends of every conversation said all their phrases
+// and heard all of their friend's, each once and in order.
+func conversationsComplete(c Cluster) error {
+	conversations := make(map[ProcessID]*ConversationProcess)
+	var ids []ProcessID
+	for _, p := range clusterLayers(c) {
+		if conversation, ok := p.(*ConversationProcess); ok {
+			conversations[conversation.ID] = conversation
+			ids = append(ids, conversation.ID)
+		}
+	}
+	if len(conversations) == 0 {
+		return fmt.Errorf("no conversations")
+	}
+	for _, id := range ids {
+		p := conversations[id]
+		if p.Misordered > 0 {
+			return fmt.Errorf("%s heard %d phrases twice or out of order", id, p.Misordered)
+		}
+		if p.PhraseIndex < len(p.Phrases) {
+			return fmt.Errorf("%s said %d of %d phrases", id, p.PhraseIndex, len(p.Phrases))
+		}
+		if friend, ok := conversations[p.FriendID]; ok && p.ExpectedPhraseIndex < len(friend.Phrases) {
+			return fmt.Errorf(
+				"%s heard %d of %s's %d phrases",
+				id, p.ExpectedPhraseIndex, p.FriendID, len(friend.Phrases),
+			)
+		}
+	}
+	return nil
+}
+
+type election struct {
+	id ProcessID
+	leader ProcessID
+	found bool
+}
+
+// Every election in the cluster, in id order.
+func elections(c Cluster) []election {
+	var found []election
+	for _, p := range clusterLayers(c) {
+		switch e := p.(type) {
+		case *LeaderElectionProcess:
+			found = append(found, election{id: e.Id(), leader: e.LeaderId, found: e.LeaderFound})
+		case *LCRProcess:
+			found = append(found, election{id: e.Id(), leader: e.LeaderId, found: e.LeaderFound})
+		case *HSProcess:
+			found = append(found, election{id: e.Id(), leader: e.LeaderId, found: e.LeaderFound})
+		}
+	}
+	return found
+}
+
+// Whether every process running an election found the same leader.
+func leadersAgree(c Cluster) error {
+	all := elections(c)
+	if len(all) == 0 {
+		return fmt.Errorf("no elections")
+	}
+	for _, e := range all {
+		if !e.found {
+			return fmt.Errorf("%s found no leader", e.id)
+		}
+	}
+	return leadersConsistent(c)
+}
+
+// Whether the processes that have found a leader so far agree on it.
+// Holds throughout a correct election.
+func leadersConsistent(c Cluster) error {
+	var first *election
+	for _, e := range elections(c) {
+		e := e
+		if !e.found {
+			continue
+		}
+		if first == nil {
+			first = &e
+			continue
+		}
+		if e.leader != first.leader {
+			return fmt.Errorf(
+				"%s chose %s but %s chose %s", first.id, first.leader, e.id, e.leader,
+			)
+		}
+	}
+	return nil
+}
+
+// Whether every router's distances match the shortest paths of topo,
+// to the nodes it can reach there.
+func routesConverged(c Cluster, topo Topology) error {
+	routers := 0
+	for _, p := range clusterLayers(c) {
+		router, ok := p.(Router)
+		if !ok {
+			continue
+		}
+		routers++
+		id := router.Id()
+		want := ShortestPaths(topo, id)
+		got := router.Distances()
+		for _, dest := range sortedIDs(topo) {
+			if dest == id {
+				continue
+			}
+			distance, known := got[dest]
+			expected, reachable := want[dest]
+			switch {
+			case reachable && !known:
+				return fmt.Errorf("%s has no route to %s", id, dest)
+			case !reachable && known:
+				return fmt.Errorf("%s has a route to unreachable %s", id, dest)
+			case reachable && distance != expected:
+				return fmt.Errorf("%s reaches %s at cost %d, not %d", id, dest, distance, expected)
+			}
+		}
+	}
+	if routers == 0 {
+		return fmt.Errorf("no routers")
+	}
+	return nil
+}
+
+// No router may know a path cheaper than the cheapest in topo,
+// which would mean it counted a link that isn't there.
+// Holds throughout a run unless links are added.
+func routesNeverShorter(topo Topology) Invariant {
+	shortest := make(map[ProcessID]map[ProcessID]int, len(topo))
+	for id := range topo {
+		shortest[id] = ShortestPaths(topo, id)
+	}
+	return Invariant{
+		Name: "routes never shorter",
+		Check: func(c Cluster) error {
+			for _, p := range clusterLayers(c) {
+				router, ok := p.(Router)
+				if !ok {
+					continue
+				}
+				id := router.Id()
+				for dest, distance := range router.Distances() {
+					expected, ok := shortest[id][dest]
+					if ok && distance < expected {
+						return fmt.Errorf(
+							"%s reaches %s at cost %d, below the shortest %d", id, dest, distance, expected,
+						)
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// A copy of topo without the link between a and b.
+func withoutLink(topo Topology, a, b ProcessID) Topology {
+	copied := make(Topology, len(topo))
+	for id, node := range topo {
+		copied[id] = node
+	}
+	for _, end := range [][2]ProcessID{{a, b}, {b, a}} {
+		node := copied[end[0]]
+		node.Neighbors = copyIDs(node.Neighbors)
+		delete(node.Neighbors, end[1])
+		copied[end[0]] = node
+	}
+	return copied
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Runs routing scenarios over the same graph, such as bellman-ford and
+// link-state, and compares what their routers report in RoutingStats:
+// how many routing messages they sent and how long until routes settled.
+
+type RoutingComparison struct {
+	Scenario string
+	Routers int
+	// Control messages summed over every router, and the latest
+	// LastRouteChange of any, in steps.
+	Stats RoutingStats
+	// Why the run failed its checks, if it did.
+	Err error
+}
+
+// Totals the RoutingStats of every router in c.
+func clusterRoutingStats(c Cluster) (RoutingStats, int) {
+	total, routers := RoutingStats{}, 0
+	for _, p := range clusterLayers(c) {
+		router, ok := p.(Router)
+		if !ok {
+			continue
+		}
+		routers++
+		stats := router.RoutingStats()
+		total.ControlMessages += stats.ControlMessages
+		if stats.LastRouteChange > total.LastRouteChange {
+			total.LastRouteChange = stats.LastRouteChange
+		}
+	}
+	return total, routers
+}
+
+// Simulates each scenario for duration with the same seed.
+func CompareRouting(names []string, params ScenarioParams, duration time.Duration, seed int64) ([]RoutingComparison, error) {
+	var comparisons []RoutingComparison
+	for _, name := range names {
+		scenario, err := NewScenario(name, params)
+		if err != nil {
+			return nil, err
+		}
+		c, err := SimulateScenario(scenario, duration, seed)
+		stats, routers := clusterRoutingStats(c)
+		if routers == 0 {
+			return nil, fmt.Errorf("%s has no routers", name)
+		}
+		comparisons = append(comparisons, RoutingComparison{
+			Scenario: name,
+			Routers: routers,
+			Stats: stats,
+			Err: err,
+		})
+	}
+	return comparisons, nil
+}
+
+func routingComparisonTable(comparisons []RoutingComparison) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "scenario\trouters\tcontrol messages\tconverged by step\tcheck")
+	for _, c := range comparisons {
+		result := "passed"
+		if c.Err != nil {
+			result = c.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", c.Scenario, c.Routers, c.Stats.ControlMessages, c.Stats.LastRouteChange, result)
+	}
+	w.Flush()
+	return b.String()
+}
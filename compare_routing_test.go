@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareRouting(t *testing.T) {
+	comparisons, err := CompareRouting([]string{"bellman-ford", "link-state"}, DefaultScenarioParams(), 2*time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparisons) != 2 {
+		t.Fatalf("%d comparisons", len(comparisons))
+	}
+	for _, c := range comparisons {
+		if c.Err != nil || c.Routers != 8 {
+			t.Errorf("%s: %d routers, %v", c.Scenario, c.Routers, c.Err)
+		}
+		// Every router advertises at least once, and learns a route.
+		if c.Stats.ControlMessages < c.Routers || c.Stats.LastRouteChange == 0 {
+			t.Errorf("%s: %+v", c.Scenario, c.Stats)
+		}
+	}
+	if _, err := CompareRouting([]string{"lcr"}, DefaultScenarioParams(), time.Second, 1); err == nil {
+		t.Error("compared lcr, which does not route")
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Serves a page, to this machine only, that draws a simulated cluster
+// and animates its messages crossing the links, a round at a time.
+// Nodes are colored by processState. The run starts paused, and the
+// page can resume it, pause it and step it a round at a time. The page
+// follows the run through the trace event stream.
+type Dashboard struct {
+	scenario Scenario
+	duration time.Duration
+	sim *Simulation
+	// Real time between rounds while running.
+	RoundDelay time.Duration
+	mutex sync.Mutex
+	paused bool
+	// Rounds asked for by step while paused.
+	steps int
+	wake chan struct{}
+	view dashboardView
+	clients map[chan TraceEvent]struct{}
+}
+
+// The run as the page draws it when it loads, and after the topology changes.
+type dashboardView struct {
+	Nodes []dashboardNode `json:"nodes"`
+	Links []dashboardLink `json:"links"`
+	Round int `json:"round"`
+	Elapsed time.Duration `json:"elapsed"`
+	Paused bool `json:"paused"`
+	Done bool `json:"done"`
+	Result string `json:"result,omitempty"`
+}
+
+type dashboardNode struct {
+	ID ProcessID `json:"id"`
+	State string `json:"state"`
+}
+
+type dashboardLink struct {
+	From ProcessID `json:"from"`
+	To ProcessID `json:"to"`
+	Up bool `json:"up"`
+}
+
+// Simulates scenario for duration of virtual time, or forever if it is 0.
+func NewDashboard(scenario Scenario, duration time.Duration, seed int64) *Dashboard {
+	return &Dashboard{
+		scenario: scenario,
+		duration: duration,
+		sim: newScenarioSimulation(scenario, seed),
+		RoundDelay: 50 * time.Millisecond,
+		paused: true,
+		wake: make(chan struct{}, 1),
+		clients: make(map[chan TraceEvent]struct{}),
+	}
+}
+
+// How the dashboard colors a process: "leader" or "follower" once its
+// election is over and "electing" before, "decided" or "proposing" for
+// a Paxos proposer, "informed" or "waiting" for a broadcast, and
+// "converged" once a router's distances are the shortest in topo and
+// "routing" before. Otherwise "".
+func processState(p Process, topo Topology) string {
+	for _, layer := range processLayers(p) {
+		var e *election
+		switch process := layer.(type) {
+		case *LeaderElectionProcess:
+			e = &election{id: process.Id(), leader: process.LeaderId, found: process.LeaderFound}
+		case *LCRProcess:
+			e = &election{id: process.Id(), leader: process.LeaderId, found: process.LeaderFound}
+		case *HSProcess:
+			e = &election{id: process.Id(), leader: process.LeaderId, found: process.LeaderFound}
+		case *FloodProcess:
+			if process.Informed {
+				return "informed"
+			}
+			return "waiting"
+		case *PaxosProposer:
+			if process.Decided {
+				return "decided"
+			}
+			return "proposing"
+		case Router:
+			if routesMatch(process, topo) {
+				return "converged"
+			}
+			return "routing"
+		}
+		switch {
+		case e == nil:
+		case !e.found:
+			return "electing"
+		case e.leader == e.id:
+			return "leader"
+		default:
+			return "follower"
+		}
+	}
+	return ""
+}
+
+// Whether router knows the shortest paths of topo, and nothing else.
+func routesMatch(router Router, topo Topology) bool {
+	id := router.Id()
+	want := ShortestPaths(topo, id)
+	got := router.Distances()
+	for dest, distance := range want {
+		if known, ok := got[dest]; dest != id && (!ok || known != distance) {
+			return false
+		}
+	}
+	for dest := range got {
+		if _, ok := want[dest]; !ok && dest != id {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs the simulation as the page says, until duration has passed or
+// an invariant breaks, and then checks the scenario.
+func (d *Dashboard) Run() error {
+	AddTracer(d)
+	defer RemoveTracer(d)
+	defer d.sim.Stop()
+	if scripted, ok := d.scenario.(ScriptedScenario); ok {
+		d.sim.StartScript(scripted.Script)
+	}
+	d.update()
+	var err error
+	for d.duration <= 0 || d.sim.Elapsed() < d.duration {
+		d.waitForTurn()
+		if err = d.sim.Round(); err != nil {
+			break
+		}
+		d.update()
+	}
+	d.sim.Stop()
+	if checked, ok := d.scenario.(CheckedScenario); ok && err == nil {
+		err = checked.Check(d.sim.Cluster)
+	}
+	d.mutex.Lock()
+	d.view.Done = true
+	d.view.Result = resultString(err)
+	d.mutex.Unlock()
+	trace(TraceEvent{Kind: "done", Message: resultString(err)})
+	return err
+}
+
+// Waits until the next round should run.
+func (d *Dashboard) waitForTurn() {
+	d.mutex.Lock()
+	for d.paused && d.steps == 0 {
+		d.mutex.Unlock()
+		<-d.wake
+		d.mutex.Lock()
+	}
+	paused := d.paused
+	if paused {
+		d.steps--
+	}
+	d.mutex.Unlock()
+	if !paused {
+		time.Sleep(d.RoundDelay)
+	}
+}
+
+// Notes the cluster's links and states after a round, tracing the
+// states that changed, and the topology if it did.
+func (d *Dashboard) update() {
+	c := d.sim.Cluster
+	topo := c.CurrentTopology()
+	view := dashboardView{Round: d.sim.Rounds, Elapsed: d.sim.Elapsed()}
+	clusterMutex.Lock()
+	for _, id := range sortedIDs(topo) {
+		view.Nodes = append(view.Nodes, dashboardNode{ID: id, State: processState(topo[id].Subprocess, topo)})
+		p := c[id]
+		p.linkMutex.Lock()
+		neighbors := make([]ProcessID, 0, len(p.OutChans))
+		for neighbor := range p.OutChans {
+			neighbors = append(neighbors, neighbor)
+		}
+		sortProcessIDs(neighbors)
+		for _, neighbor := range neighbors {
+			_, down := p.downLinks[neighbor]
+			view.Links = append(view.Links, dashboardLink{From: id, To: neighbor, Up: !down})
+		}
+		p.linkMutex.Unlock()
+	}
+	clusterMutex.Unlock()
+
+	d.mutex.Lock()
+	old := d.view
+	view.Paused = d.paused
+	d.view = view
+	d.mutex.Unlock()
+	states := make(map[ProcessID]string, len(old.Nodes))
+	for _, node := range old.Nodes {
+		states[node.ID] = node.State
+	}
+	for _, node := range view.Nodes {
+		if state, ok := states[node.ID]; !ok || state != node.State {
+			trace(TraceEvent{Kind: "state", From: node.ID, State: node.State})
+		}
+	}
+	if !sameLinks(old.Links, view.Links) || len(old.Nodes) != len(view.Nodes) {
+		trace(TraceEvent{Kind: "topology"})
+	}
+}
+
+func sameLinks(a, b []dashboardLink) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].From != b[i].From || a[i].To != b[i].To {
+			return false
+		}
+	}
+	return true
+}
+
+// Passes the event on to every page watching. A page too slow to keep
+// up misses events rather than holding up the run.
+func (d *Dashboard) Trace(event TraceEvent) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for client := range d.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+}
+
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.servePage)
+	mux.HandleFunc("/state", d.serveState)
+	mux.HandleFunc("/events", d.serveEvents)
+	mux.HandleFunc("/control", d.serveControl)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !addressedLocally(r) {
+			http.Error(w, fmt.Sprintf("unexpected host %q", r.Host), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Whether r was sent to the address it arrived on, by loopback address or
+// as localhost. Pages elsewhere can still reach a dashboard on this machine
+// through a name of theirs that resolves to it, but they name the host.
+func addressedLocally(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	localHost, localPort, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || port != localPort {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback() && ip.Equal(net.ParseIP(localHost))
+}
+
+func (d *Dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardPage)
+}
+
+func (d *Dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	view := d.view
+	view.Paused = d.paused
+	d.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// Streams trace events as server-sent events.
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan TraceEvent, 4096)
+	d.mutex.Lock()
+	d.clients[client] = struct{}{}
+	d.mutex.Unlock()
+	defer func() {
+		d.mutex.Lock()
+		delete(d.clients, client)
+		d.mutex.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case event := <-client:
+			data, err := json.Marshal(event)
+			if err != nil {
+				panic(fmt.Sprintf("cannot encode %v: %s", event, err))
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Pauses, resumes or steps the run, as the posted {"action": ...} says.
+// Only JSON is accepted, which other pages' scripts cannot send here
+// without the browser asking first, and this never answers that.
+func (d *Dashboard) serveControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST an action", http.StatusMethodNotAllowed)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+		http.Error(w, fmt.Sprintf("unexpected origin %q", origin), http.StatusForbidden)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "POST the action as JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.mutex.Lock()
+	switch action := request.Action; action {
+	case "pause":
+		d.paused = true
+	case "resume":
+		d.paused = false
+	case "step":
+		d.paused = true
+		d.steps++
+	default:
+		d.mutex.Unlock()
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+	d.mutex.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	d.serveState(w, r)
+}
+
+// Listens on addr, which must be on this machine, since anyone who can
+// reach the dashboard can control the run. A bare port listens on 127.0.0.1.
+func listenLocal(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("dashboard address %s is not on this machine; use 127.0.0.1 or localhost", addr)
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>distributed_theory</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+header { padding: 8px; border-bottom: 1px solid #ccc; }
+header span { margin-left: 12px; }
+#legend i { display: inline-block; width: 10px; height: 10px; border: 1px solid #333; margin: 0 3px 0 10px; }
+svg { flex: 1; }
+.link { stroke: #999; stroke-width: 1.5; }
+.link.down { stroke: #d33; stroke-dasharray: 4 4; }
+.node circle { stroke: #333; stroke-width: 1.5; }
+.node text { font-size: 11px; text-anchor: middle; dominant-baseline: central; pointer-events: none; }
+.message { fill: #36c; }
+.message.dropped { fill: #d33; }
+</style>
+</head>
+<body>
+<header>
+<button id="pause">pause</button>
+<button id="resume">resume</button>
+<button id="step">step</button>
+<span id="status"></span>
+<span id="legend"></span>
+</header>
+<svg id="graph"></svg>
+<script>
+var colors = {
+	leader: "#f5c518", follower: "#a5d6a7", electing: "#eee",
+	decided: "#a5d6a7", proposing: "#eee",
+	converged: "#4caf50", routing: "#ffb74d",
+	informed: "#64b5f6", waiting: "#eee", "": "#fff"
+};
+var NS = "http://www.w3.org/2000/svg";
+var svg = document.getElementById("graph");
+var linkLayer = make("g", {}, svg), nodeLayer = make("g", {}, svg), messageLayer = make("g", {}, svg);
+var positions = {}, circles = {}, lines = {}, layoutKey = "", shown = {};
+var flying = 0, dots = {};
+
+function make(name, attributes, parent) {
+	var e = document.createElementNS(NS, name);
+	for (var key in attributes) {
+		e.setAttribute(key, attributes[key]);
+	}
+	parent.appendChild(e);
+	return e;
+}
+
+// A spring layout, starting from where nodes were, or from a circle.
+function layout(nodes, links) {
+	var w = svg.clientWidth, h = svg.clientHeight, n = nodes.length;
+	var pos = {};
+	nodes.forEach(function(node, i) {
+		var old = positions[node.id], angle = 2 * Math.PI * i / n;
+		pos[node.id] = old ? {x: old.x, y: old.y} : {x: w / 2 + Math.cos(angle) * w / 3, y: h / 2 + Math.sin(angle) * h / 3};
+	});
+	var k = 0.6 * Math.sqrt(w * h / Math.max(n, 1));
+	for (var round = 0; round < 300; round++) {
+		var moves = {};
+		nodes.forEach(function(a) {
+			moves[a.id] = {x: 0, y: 0};
+			nodes.forEach(function(b) {
+				if (a.id === b.id) {
+					return;
+				}
+				var dx = pos[a.id].x - pos[b.id].x, dy = pos[a.id].y - pos[b.id].y;
+				var d = Math.max(Math.sqrt(dx * dx + dy * dy), 0.01);
+				moves[a.id].x += dx / d * k * k / d;
+				moves[a.id].y += dy / d * k * k / d;
+			});
+		});
+		links.forEach(function(link) {
+			var a = pos[link.from], b = pos[link.to];
+			if (!a || !b) {
+				return;
+			}
+			var dx = a.x - b.x, dy = a.y - b.y;
+			var d = Math.max(Math.sqrt(dx * dx + dy * dy), 0.01), f = d * d / k;
+			moves[link.from].x -= dx / d * f;
+			moves[link.from].y -= dy / d * f;
+			moves[link.to].x += dx / d * f;
+			moves[link.to].y += dy / d * f;
+		});
+		var limit = 0.1 * w * (1 - round / 300);
+		nodes.forEach(function(node) {
+			var m = moves[node.id], p = pos[node.id];
+			var length = Math.max(Math.sqrt(m.x * m.x + m.y * m.y), 0.01);
+			p.x = Math.min(w - 30, Math.max(30, p.x + m.x / length * Math.min(length, limit)));
+			p.y = Math.min(h - 30, Math.max(30, p.y + m.y / length * Math.min(length, limit)));
+		});
+	}
+	return pos;
+}
+
+function linkKey(a, b) {
+	return Math.min(a, b) + "-" + Math.max(a, b);
+}
+
+function draw(view) {
+	var key = JSON.stringify([view.nodes.map(function(n) { return n.id; }), view.links.map(function(l) { return [l.from, l.to]; })]);
+	if (key !== layoutKey) {
+		layoutKey = key;
+		positions = layout(view.nodes, view.links);
+	}
+	linkLayer.innerHTML = "";
+	nodeLayer.innerHTML = "";
+	lines = {};
+	circles = {};
+	view.links.forEach(function(link) {
+		var key = linkKey(link.from, link.to);
+		if (!lines[key]) {
+			var a = positions[link.from], b = positions[link.to];
+			lines[key] = make("line", {"class": "link", x1: a.x, y1: a.y, x2: b.x, y2: b.y}, linkLayer);
+		}
+		if (!link.up) {
+			lines[key].classList.add("down");
+		}
+	});
+	view.nodes.forEach(function(node) {
+		var p = positions[node.id];
+		var g = make("g", {"class": "node", transform: "translate(" + p.x + "," + p.y + ")"}, nodeLayer);
+		circles[node.id] = make("circle", {r: 14}, g);
+		make("text", {}, g).textContent = node.id;
+		setState(node.id, node.state);
+	});
+	status(view);
+}
+
+function setState(id, state) {
+	if (circles[id]) {
+		circles[id].setAttribute("fill", colors[state] || "#fff");
+		circles[id].innerHTML = "<title>pid:" + id + " " + state + "</title>";
+	}
+}
+
+// Shows what changed of the run's round, time, and whether it is paused or done.
+function status(changed) {
+	for (var key in changed) {
+		shown[key] = changed[key];
+	}
+	var text = "round " + shown.round + ", " + ((shown.elapsed || 0) / 1e9).toFixed(2) + "s";
+	if (shown.done) {
+		text += ", done: " + shown.result;
+	} else if (shown.paused) {
+		text += ", paused";
+	}
+	document.getElementById("status").textContent = text;
+}
+
+// Moves a dot from one node to another.
+function animate(event) {
+	var a = positions[event.from], b = positions[event.to], key = event.from + ">" + event.to;
+	if (!a || !b || flying > 500) {
+		return;
+	}
+	flying++;
+	var message = {dot: make("circle", {"class": "message", r: 4}, messageLayer), end: 1};
+	make("title", {}, message.dot).textContent = event.message || "";
+	(dots[key] = dots[key] || []).push(message);
+	var start = performance.now(), duration = 500;
+	function frame(now) {
+		var t = Math.min((now - start) / duration, message.end);
+		message.dot.setAttribute("cx", a.x + (b.x - a.x) * t);
+		message.dot.setAttribute("cy", a.y + (b.y - a.y) * t);
+		if (t < message.end) {
+			requestAnimationFrame(frame);
+			return;
+		}
+		flying--;
+		messageLayer.removeChild(message.dot);
+		dots[key].splice(dots[key].indexOf(message), 1);
+	}
+	requestAnimationFrame(frame);
+}
+
+// Turns the latest dot between the nodes red, and stops it halfway.
+function drop(event) {
+	var flying = dots[event.from + ">" + event.to] || [];
+	for (var i = flying.length - 1; i >= 0; i--) {
+		if (flying[i].end === 1) {
+			flying[i].end = 0.5;
+			flying[i].dot.classList.add("dropped");
+			return;
+		}
+	}
+}
+
+function refresh() {
+	fetch("/state").then(function(r) { return r.json(); }).then(draw);
+}
+
+["pause", "resume", "step"].forEach(function(action) {
+	document.getElementById(action).onclick = function() {
+		fetch("/control", {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify({action: action})
+		})
+			.then(function(r) { return r.json(); }).then(status);
+	};
+});
+
+var legend = document.getElementById("legend");
+Object.keys(colors).forEach(function(state) {
+	if (state) {
+		var swatch = document.createElement("i");
+		swatch.style.background = colors[state];
+		legend.appendChild(swatch);
+		legend.appendChild(document.createTextNode(state));
+	}
+});
+
+var events = new EventSource("/events");
+events.onmessage = function(e) {
+	var event = JSON.parse(e.data);
+	switch (event.kind) {
+	case "send":
+		animate(event);
+		break;
+	case "drop":
+		drop(event);
+		break;
+	case "link":
+		var line = lines[linkKey(event.from, event.to)];
+		if (line) {
+			line.classList.toggle("down", !event.up);
+		}
+		break;
+	case "state":
+		setState(event.from, event.state);
+		break;
+	case "round":
+		status({round: event.round, elapsed: event.elapsed});
+		break;
+	case "topology":
+	case "done":
+		refresh();
+		break;
+	}
+};
+events.onopen = refresh;
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingTracer struct {
+	mutex sync.Mutex
+	kinds map[string]int
+}
+
+func (t *countingTracer) Trace(event TraceEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.kinds[event.Kind]++
+}
+
+func TestTracer(t *testing.T) {
+	scenario, err := NewScenario("link-failure", DefaultScenarioParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &countingTracer{kinds: make(map[string]int)}
+	AddTracer(tracer)
+	_, err = SimulateScenario(scenario, 5*time.Second, 1)
+	RemoveTracer(tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := tracer.kinds
+	if kinds["send"] == 0 || kinds["receive"] > kinds["send"] {
+		t.Errorf("%d sent, %d received", kinds["send"], kinds["receive"])
+	}
+	if kinds["round"] != 500 {
+		t.Errorf("%d rounds traced, not 500", kinds["round"])
+	}
+	if kinds["link"] != 2 {
+		t.Errorf("%d link changes traced, not 2", kinds["link"])
+	}
+}
+
+func getView(t *testing.T, server *httptest.Server) dashboardView {
+	response, err := http.Get(server.URL + "/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var view dashboardView
+	if err := json.NewDecoder(response.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	return view
+}
+
+func control(t *testing.T, server *httptest.Server, action string) {
+	body := strings.NewReader(fmt.Sprintf(`{"action": %q}`, action))
+	response, err := http.Post(server.URL+"/control", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", action, response.Status)
+	}
+}
+
+// Waits for the dashboard's view to satisfy done.
+func waitForView(t *testing.T, server *httptest.Server, done func(dashboardView) bool) dashboardView {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if view := getView(t, server); done(view) {
+			return view
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+	return dashboardView{}
+}
+
+func TestDashboard(t *testing.T) {
+	params := DefaultScenarioParams()
+	params.GraphSize = 5
+	scenario, err := NewScenario("leader-election", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDashboard(scenario, time.Second, 1)
+	d.RoundDelay = 0
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	finished := make(chan error)
+	go func() { finished <- d.Run() }()
+
+	waitForView(t, server, func(v dashboardView) bool { return len(v.Nodes) == 5 })
+	time.Sleep(20 * time.Millisecond)
+	if view := getView(t, server); view.Round != 0 || !view.Paused {
+		t.Fatalf("started at round %d, paused %t", view.Round, view.Paused)
+	}
+	control(t, server, "step")
+	control(t, server, "step")
+	waitForView(t, server, func(v dashboardView) bool { return v.Round == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if view := getView(t, server); view.Round != 2 {
+		t.Fatalf("stepped twice to round %d", view.Round)
+	}
+	control(t, server, "resume")
+	if err := <-finished; err != nil {
+		t.Fatal(err)
+	}
+	view := getView(t, server)
+	if !view.Done || view.Result != "passed" || view.Round != 100 {
+		t.Fatalf("ended at round %d, done %t: %s", view.Round, view.Done, view.Result)
+	}
+	leaders := 0
+	for _, node := range view.Nodes {
+		switch node.State {
+		case "leader":
+			leaders++
+		case "follower":
+		default:
+			t.Errorf("%s ended %q", node.ID, node.State)
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("%d leaders", leaders)
+	}
+
+	response, err := http.Post(server.URL+"/control", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("no action: %s", response.Status)
+	}
+}
+
+func TestDashboardRejectsOtherSites(t *testing.T) {
+	d := NewDashboard(LCRScenario{RingSize: 3}, time.Second, 1)
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for _, c := range []struct {
+		name, host, origin, contentType string
+		status int
+	}{
+		{"form", "", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"other origin", "", "http://example.com", "application/json", http.StatusForbidden},
+		{"rebound name", "example.com:" + port, "", "application/json", http.StatusForbidden},
+		{"other port", "127.0.0.1:1", "", "application/json", http.StatusForbidden},
+		{"localhost", "localhost:" + port, "http://localhost:" + port, "application/json", http.StatusOK},
+	} {
+		request, err := http.NewRequest("POST", server.URL+"/control", strings.NewReader(`{"action": "pause"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.host != "" {
+			request.Host = c.host
+		}
+		if c.origin != "" {
+			request.Header.Set("Origin", c.origin)
+		}
+		request.Header.Set("Content-Type", c.contentType)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != c.status {
+			t.Errorf("%s: %s", c.name, response.Status)
+		}
+	}
+}
+
+func TestListenLocal(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", "192.0.2.1:0", "example.com:0"} {
+		if listener, err := listenLocal(addr); err == nil {
+			listener.Close()
+			t.Errorf("listened on %s", addr)
+		}
+	}
+	for _, addr := range []string{":0", "127.0.0.1:0", "localhost:0"} {
+		listener, err := listenLocal(addr)
+		if err != nil {
+			t.Errorf("%s: %s", addr, err)
+			continue
+		}
+		listener.Close()
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The UDP counterpart of MultiTCPProcess: unreliable, unordered delivery
+// with no acknowledgements or retransmission.
+// Messages larger than a link's MTU are split into fragments, and the
+// receiver only delivers a message once every fragment has arrived.
+// If a fragment is lost, the whole message is dropped after
+// ReassemblyTimeout steps.
+// The split is only simulated: the first fragment carries the whole
+// message and the others stand in for the rest of its bytes, so that
+// losing any of them loses the message, as it would on the wire.
+type DatagramProcess struct {
+	Process
+	// Maximum fragment size in bytes for the link to each neighbor.
+	// Links that aren't listed use DefaultMTU.
+	MTU map[ProcessID]int
+	nextID DatagramID
+	partial map[datagramKey]*reassembly
+	received []RoutedMessage
+	steps int
+}
+
+const (
+	DefaultMTU = 64
+	ReassemblyTimeout = 100
+)
+
+type DatagramID int
+
+type DatagramFragment struct {
+	// Only the first fragment carries the message itself;
+	// the rest just account for its size on the wire.
+	Message
+	ID DatagramID
+	Index int
+	Count int
+	Length int
+}
+
+func (m DatagramFragment) String() string {
+	if m.Message != nil {
+		return fmt.Sprintf("FRAG(%d, %d/%d, %s)", m.ID, m.Index+1, m.Count, m.Message)
+	}
+	return fmt.Sprintf("FRAG(%d, %d/%d)", m.ID, m.Index+1, m.Count)
+}
+
+func (m DatagramFragment) MessageTag() string {
+	return "fragment"
+}
+
+func (m DatagramFragment) Encode(e *Encoder) {
+	e.WriteInt(int(m.ID))
+	e.WriteInt(m.Index)
+	e.WriteInt(m.Count)
+	e.WriteInt(m.Length)
+	e.WriteMessage(m.Message)
+}
+
+func init() {
+	RegisterMessageType("fragment", func(d *Decoder) Message {
+		m := DatagramFragment{}
+		m.ID = DatagramID(d.ReadInt())
+		m.Index = d.ReadInt()
+		m.Count = d.ReadInt()
+		m.Length = d.ReadInt()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
+
+type datagramKey struct {
+	from ProcessID
+	id DatagramID
+}
+
+type reassembly struct {
+	message Message
+	fragments map[int]struct{}
+	count int
+	startedAt int
+}
+
+// Size of a message on the wire, in bytes.
+// Messages that cannot be encoded are approximated by their String.
+func messageSize(m Message) int {
+	if b, err := EncodeMessage(m); err == nil {
+		return len(b)
+	}
+	return len(m.String())
+}
+
+func (p *DatagramProcess) mtu(pid ProcessID) int {
+	if mtu, ok := p.MTU[pid]; ok && mtu > 0 {
+		return mtu
+	}
+	return DefaultMTU
+}
+
+func (p *DatagramProcess) fragment(m RoutedMessage, send func(RoutedMessage)) {
+	if m.From != p.Id() {
+		panic(fmt.Sprintf("datagram cannot send message %s", m))
+	}
+	size := messageSize(m.Message)
+	mtu := p.mtu(m.To)
+	count := (size + mtu - 1) / mtu
+	if count == 0 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		fragment := DatagramFragment{
+			ID: p.nextID,
+			Index: i,
+			Count: count,
+			Length: mtu,
+		}
+		if i == 0 {
+			fragment.Message = m.Message
+		}
+		if i == count-1 {
+			fragment.Length = size - i*mtu
+		}
+		send(RoutedMessage{
+			Message: fragment,
+			From: p.Id(),
+			To: m.To,
+		})
+	}
+	p.nextID += 1
+}
+
+func (p *DatagramProcess) reassemble(from ProcessID, fragment DatagramFragment) {
+	if p.partial == nil {
+		p.partial = make(map[datagramKey]*reassembly)
+	}
+	key := datagramKey{from: from, id: fragment.ID}
+	if fragment.Index < 0 || fragment.Index >= fragment.Count ||
+		fragment.Index == 0 && fragment.Message == nil {
+		// corrupted; it could otherwise complete a message early,
+		// or without the fragment that carries it
+		return
+	}
+	r, ok := p.partial[key]
+	if !ok {
+		r = &reassembly{
+			fragments: make(map[int]struct{}),
+			count: fragment.Count,
+			startedAt: p.steps,
+		}
+		p.partial[key] = r
+	}
+	if fragment.Count != r.count {
+		// corrupted, or the other fragments were
+		return
+	}
+	if fragment.Index == 0 {
+		r.message = fragment.Message
+	}
+	r.fragments[fragment.Index] = struct{}{}
+	if len(r.fragments) < r.count || r.message == nil {
+		return
+	}
+	delete(p.partial, key)
+	p.received = append(p.received, RoutedMessage{
+		Message: r.message,
+		From: from,
+		To: p.Id(),
+	})
+}
+
+// Drop messages that have been waiting too long for a lost fragment.
+func (p *DatagramProcess) expire() {
+	for key, r := range p.partial {
+		if p.steps-r.startedAt > ReassemblyTimeout {
+			delete(p.partial, key)
+		}
+	}
+}
+
+func (p *DatagramProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	p.steps += 1
+	for received := receive(); received != nil; received = receive() {
+		fragment, ok := received.Message.(DatagramFragment)
+		if !ok {
+			panic(fmt.Sprintf(
+				"datagram unexpected message type %T %s", received.Message, received.Message,
+			))
+		}
+		p.reassemble(received.From, fragment)
+	}
+	p.expire()
+	if p.Process == nil {
+		return
+	}
+	p.Process.Step(
+		func(m RoutedMessage) {
+			p.fragment(m, send)
+		},
+		func() *RoutedMessage {
+			if len(p.received) > 0 {
+				m := p.received[0]
+				p.received = p.received[1:]
+				return &m
+			}
+			return nil
+		},
+	)
+}
+
+// Two processes chatting over a lossy, reordering, corrupting link.
+// Over MultiTCPProcess the conversation completes thanks to checksums and
+// retransmission; over DatagramProcess it stalls as soon as a phrase is lost.
+type LossyConversationScenario struct {
+	Datagram bool
+	DropRate float64
+	ReorderRate float64
+	CorruptRate float64
+}
+
+func (s LossyConversationScenario) transport(p Process, friend ProcessID) Process {
+	if s.Datagram {
+		return &DatagramProcess{
+			Process: p,
+			MTU: map[ProcessID]int{friend: 8},
+		}
+	}
+	return &MultiTCPProcess{Process: p}
+}
+
+func (s LossyConversationScenario) Network() Topology {
+	faults := LinkFaults{
+		DropRate: s.DropRate,
+		ReorderRate: s.ReorderRate,
+		CorruptRate: s.CorruptRate,
+	}
+	return map[ProcessID]TopologyNode {
+		1: TopologyNode{
+			Subprocess: s.transport(&ConversationProcess{
+				ID: 1,
+				FriendID: 2,
+				Phrases: []string{"hello over there", "how is the weather", "lovely, goodbye"},
+				Initiate: true,
+			}, 2),
+			Neighbors: map[ProcessID]struct{}{2: {}},
+			Faults: faults,
+		},
+		2: TopologyNode{
+			Subprocess: s.transport(&ConversationProcess{
+				ID: 2,
+				FriendID: 1,
+				Phrases: []string{"hi, good to hear from you", "sunny all week", "bye"},
+			}, 1),
+			Neighbors: map[ProcessID]struct{}{1: {}},
+			Faults: faults,
+		},
+	}
+}
+
+func (s LossyConversationScenario) Check(c Cluster) error {
+	return conversationsComplete(c)
+}
+
+func init() {
+	RegisterScenario("lossy-tcp", "a conversation over TCP on a link dropping DropRate of messages", func(params ScenarioParams) Scenario {
+		return LossyConversationScenario{DropRate: params.DropRate, ReorderRate: 0.2}
+	})
+	RegisterScenario("lossy-datagram", "lossy-tcp over datagrams instead, which stalls", func(params ScenarioParams) Scenario {
+		return LossyConversationScenario{Datagram: true, DropRate: params.DropRate, ReorderRate: 0.2}
+	})
+}
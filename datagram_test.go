@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDatagramReassembly(t *testing.T) {
+	p := &DatagramProcess{Process: &ConversationProcess{ID: 2, FriendID: 1}}
+	phrase := ConversationMessage{Index: 0, Phrase: "hi"}
+	// Corrupted headers are dropped rather than completing a message.
+	p.reassemble(1, DatagramFragment{ID: 1, Index: 5, Count: 1})
+	p.reassemble(1, DatagramFragment{ID: 2, Index: 0, Count: 0, Message: phrase})
+	p.reassemble(1, DatagramFragment{ID: 3, Index: -1, Count: 2})
+	p.reassemble(1, DatagramFragment{ID: 4, Index: 0, Count: 1})
+	if len(p.received) != 0 {
+		t.Fatalf("delivered %v", p.received)
+	}
+	// A message waits for its first fragment, whatever order they come in.
+	p.reassemble(1, DatagramFragment{ID: 5, Index: 1, Count: 2})
+	p.reassemble(1, DatagramFragment{ID: 5, Index: 1, Count: 3})
+	if len(p.received) != 0 {
+		t.Fatalf("delivered %v without its first fragment", p.received)
+	}
+	p.reassemble(1, DatagramFragment{ID: 5, Index: 0, Count: 2, Message: phrase})
+	if len(p.received) != 1 || p.received[0].Message != phrase {
+		t.Fatalf("delivered %v", p.received)
+	}
+}
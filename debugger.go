@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Steps a cluster by hand from a terminal. Each command makes one of
+// the model checker's choices, or duplicates a message, so nothing
+// happens that wasn't asked for. Rewinding replays the choices made so
+// far, minus the last few, on a fresh network.
+type Debugger struct {
+	scenario Scenario
+	world *modelWorld
+	trace []Choice
+	invariants []Invariant
+	out io.Writer
+	// Notes which scenario a saved trace is of.
+	SetScenario func(record *RunRecord)
+}
+
+const debuggerHelp = `commands:
+  list                  messages waiting, by receiver
+  processes             every process, with messages waiting for it
+  step <pid>            step a process with nothing to receive
+  deliver <n>           deliver message n of the list
+  drop <n>              lose message n
+  dup <n>               send message n again
+  crash <pid>           stop a process for good
+  inspect <pid>         print a process's state
+  back [n]              undo the last n choices, 1 by default
+  trace                 the choices made so far
+  check                 check the scenario's invariants
+  save <file>           write the choices made so far, for -replay
+  help
+  quit
+`
+
+func NewDebugger(scenario Scenario, out io.Writer) *Debugger {
+	d := &Debugger{scenario: scenario, out: out}
+	if withInvariants, ok := scenario.(InvariantScenario); ok {
+		d.invariants = withInvariants.Invariants()
+	}
+	d.world = newModelWorld(scenario)
+	return d
+}
+
+// Makes the choices of a recorded trace, to start from where it ended.
+func (d *Debugger) Load(record *RunRecord) error {
+	if record.Mode != "trace" {
+		return fmt.Errorf("only a trace from -model-check or -fuzz can be debugged, not a %s run", record.Mode)
+	}
+	trace, err := record.trace()
+	if err != nil {
+		return err
+	}
+	for i, choice := range trace {
+		if err := d.choose(choice); err != nil {
+			return fmt.Errorf("choice %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Reads commands from in until it ends or says quit.
+func (d *Debugger) Run(in io.Reader) {
+	fmt.Fprint(d.out, "type help for commands\n(debug) ")
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if quit := d.Execute(scanner.Text()); quit {
+			return
+		}
+		fmt.Fprint(d.out, "(debug) ")
+	}
+	fmt.Fprintln(d.out)
+}
+
+// Runs one command, returning whether it was quit.
+func (d *Debugger) Execute(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	command, args := fields[0], fields[1:]
+	var err error
+	switch command {
+	case "help", "h", "?":
+		fmt.Fprint(d.out, debuggerHelp)
+	case "quit", "q", "exit":
+		return true
+	case "list", "l", "ls":
+		d.list()
+	case "processes", "ps":
+		d.processes()
+	case "step", "s":
+		var id ProcessID
+		if id, err = d.processArg(args); err == nil {
+			err = d.choose(Choice{Kind: StepChoice, Process: id})
+		}
+	case "crash":
+		var id ProcessID
+		if id, err = d.processArg(args); err == nil {
+			err = d.choose(Choice{Kind: CrashChoice, Process: id})
+		}
+	case "deliver", "d", "drop", "dup":
+		kind := map[string]ChoiceKind{"deliver": DeliverChoice, "d": DeliverChoice, "drop": DropChoice, "dup": DuplicateChoice}[command]
+		var m modelMessage
+		if m, err = d.messageArg(args); err == nil {
+			routed := m.RoutedMessage
+			err = d.choose(Choice{Kind: kind, Process: m.To, Message: &routed, messageHash: m.hash})
+		}
+	case "inspect", "p", "print":
+		var id ProcessID
+		if id, err = d.processArg(args); err == nil {
+			fmt.Fprintln(d.out, prettyPrint(d.world.processes[id]))
+		}
+	case "back", "b":
+		err = d.back(args)
+	case "trace", "t":
+		if len(d.trace) == 0 {
+			fmt.Fprintln(d.out, "no choices made")
+		}
+		for i, choice := range d.trace {
+			fmt.Fprintf(d.out, "%3d. %s\n", i+1, choice)
+		}
+	case "check":
+		if err := d.world.check(d.invariants); err != nil {
+			fmt.Fprintln(d.out, err)
+		} else {
+			fmt.Fprintf(d.out, "no invariant of %d broken\n", len(d.invariants))
+		}
+	case "save":
+		err = d.save(args)
+	default:
+		err = fmt.Errorf("unknown command %q; try help", command)
+	}
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+	return false
+}
+
+// Messages waiting, grouped by receiver, in the order list shows them.
+func (d *Debugger) pending() []modelMessage {
+	var pending []modelMessage
+	for _, id := range d.world.ids {
+		for _, m := range d.world.inFlight {
+			if m.To == id {
+				pending = append(pending, m)
+			}
+		}
+	}
+	return pending
+}
+
+func (d *Debugger) list() {
+	pending := d.pending()
+	if len(pending) == 0 {
+		fmt.Fprintln(d.out, "no messages waiting")
+		return
+	}
+	for i, m := range pending {
+		if i == 0 || pending[i-1].To != m.To {
+			fmt.Fprintf(d.out, "to %s:\n", m.To)
+		}
+		fmt.Fprintf(d.out, "%4d. %s\n", i+1, m.RoutedMessage)
+	}
+}
+
+func (d *Debugger) processes() {
+	waiting := make(map[ProcessID]int)
+	for _, m := range d.world.inFlight {
+		waiting[m.To]++
+	}
+	for _, id := range d.world.ids {
+		state := ""
+		if d.world.crashed[id] {
+			state = ", crashed"
+		}
+		fmt.Fprintf(d.out, "%-8s%T, %d waiting%s\n", id, d.world.processes[id], waiting[id], state)
+	}
+}
+
+func (d *Debugger) processArg(args []string) (ProcessID, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("want one process id")
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(args[0], "pid:"))
+	if err != nil {
+		return 0, fmt.Errorf("bad process id %q", args[0])
+	}
+	id := ProcessID(n)
+	if _, ok := d.world.processes[id]; !ok {
+		return 0, fmt.Errorf("no process %s", id)
+	}
+	return id, nil
+}
+
+func (d *Debugger) messageArg(args []string) (modelMessage, error) {
+	if len(args) != 1 {
+		return modelMessage{}, fmt.Errorf("want one message number from list")
+	}
+	pending := d.pending()
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(pending) {
+		return modelMessage{}, fmt.Errorf("no message %q; there are %d", args[0], len(pending))
+	}
+	return pending[n-1], nil
+}
+
+// Makes choice and says what happened.
+func (d *Debugger) choose(choice Choice) error {
+	if choice.Message == nil && choice.Kind != StepChoice && choice.Kind != CrashChoice {
+		for _, m := range d.world.inFlight {
+			if m.hash == choice.messageHash {
+				routed := m.RoutedMessage
+				choice.Message = &routed
+				break
+			}
+		}
+	}
+	sent := len(d.world.inFlight)
+	if choice.Kind == DeliverChoice || choice.Kind == DropChoice {
+		sent--
+	}
+	if !d.world.tryApply(choice) {
+		return fmt.Errorf("cannot %s", choice)
+	}
+	d.trace = append(d.trace, choice)
+	fmt.Fprintf(d.out, "%d. %s\n", len(d.trace), choice)
+	if choice.Kind == StepChoice || choice.Kind == DeliverChoice {
+		for _, m := range d.world.inFlight[sent:] {
+			fmt.Fprintf(d.out, "   sent %s\n", m.RoutedMessage)
+		}
+	}
+	if err := d.world.check(d.invariants); err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+	return nil
+}
+
+func (d *Debugger) back(args []string) error {
+	n := 1
+	if len(args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("bad count %q", args[0])
+		}
+	} else if len(args) > 1 {
+		return fmt.Errorf("want at most one count")
+	}
+	if n > len(d.trace) {
+		n = len(d.trace)
+	}
+	d.trace = d.trace[:len(d.trace)-n]
+	quietly(func() {
+		d.world = replayModel(d.scenario, d.trace)
+	})
+	fmt.Fprintf(d.out, "back to %d choices\n", len(d.trace))
+	return nil
+}
+
+// Runs f without logging, for replays, which would log again
+// what was logged the first time.
+func quietly(f func()) {
+	output := logOutput
+	logOutput = ioutil.Discard
+	defer func() { logOutput = output }()
+	f()
+}
+
+func (d *Debugger) save(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("want one file name")
+	}
+	var record *RunRecord
+	quietly(func() {
+		record = RecordTrace(d.scenario, d.trace, d.world.check(d.invariants))
+	})
+	if d.SetScenario != nil {
+		d.SetScenario(record)
+	}
+	if err := record.Save(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "saved %d choices to %s\n", len(d.trace), args[0])
+	return nil
+}
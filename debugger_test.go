@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebugger(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDebugger(PaxosScenario{SkipPrepare: true}, &out)
+	// Each proposer gets a different pair of acceptors to accept.
+	d.Run(strings.NewReader(`
+step 0
+step 1
+deliver 1
+deliver 1
+deliver 2
+deliver 1
+deliver 1
+deliver 1
+deliver 3
+deliver 1
+`))
+	if !strings.Contains(out.String(), "invariant one value chosen broken") {
+		t.Fatalf("agreement not broken:\n%s", out.String())
+	}
+	out.Reset()
+	d.Execute("back 2")
+	d.Execute("check")
+	if !strings.Contains(out.String(), "back to 8 choices") || !strings.Contains(out.String(), "no invariant of 1 broken") {
+		t.Errorf("rewind did not undo the break:\n%s", out.String())
+	}
+	out.Reset()
+	d.Execute("list")
+	d.Execute("dup 3")
+	d.Execute("list")
+	// Listed, duplicated, then listed twice.
+	if got := strings.Count(out.String(), "(ACCEPT(2, \"banana\")) pid:1->pid:4"); got != 4 {
+		t.Errorf("want the message shown 4 times, got %d:\n%s", got, out.String())
+	}
+	for _, bad := range []string{"step 9", "deliver 0", "deliver x", "back -1", "frobnicate"} {
+		out.Reset()
+		d.Execute(bad)
+		if out.Len() == 0 || len(d.trace) != 9 {
+			t.Errorf("%s: no complaint, or a choice was made", bad)
+		}
+	}
+}
+
+func TestDebuggerLoadsOnlyTraces(t *testing.T) {
+	d := NewDebugger(PaxosScenario{SkipPrepare: true}, &bytes.Buffer{})
+	if err := d.Load(&RunRecord{Mode: "simulation"}); err == nil {
+		t.Error("loaded a simulation as if it were a trace")
+	}
+	if err := d.Load(&RunRecord{Mode: "trace", Choices: []ChoiceRecord{{Kind: "step", Process: 0}}}); err != nil || len(d.trace) != 1 {
+		t.Errorf("%s, %d choices", err, len(d.trace))
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	p := &PaxosProposer{ID: 1, Acceptors: []ProcessID{2, 10}, accepts: map[ProcessID]struct{}{10: {}, 2: {}}}
+	got := prettyPrint(p)
+	for _, want := range []string{"&PaxosProposer{\n", "  ID: pid:1\n", "  Acceptors: [pid:2, pid:10]\n", "  accepts: {pid:2, pid:10}\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q missing from:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Nodes and links can be added and removed while a cluster runs,
+// usually from a ScriptedScenario. Processes hear about their own changes
+// at their next Step, through LinkAddObserver, LinkObserver and NeighborAware.
+
+// Guards the membership of clusters, which scripts change while
+// Cluster.RunTillDone watches for new processes.
+var clusterMutex sync.Mutex
+
+func newDirectConnectedProcess(node TopologyNode) *DirectConnectedProcess {
+	return &DirectConnectedProcess{
+		P: node.Subprocess,
+		InChan: make(chan RoutedMessage, directChannelBuffer),
+		Faults: node.Faults,
+		OutChans: make(map[ProcessID]chan RoutedMessage),
+		InNeighbors: make(map[ProcessID]struct{}),
+		Latencies: make(map[ProcessID]time.Duration),
+		Weights: make(map[ProcessID]int),
+	}
+}
+
+func (p *DirectConnectedProcess) hasLinkTo(neighbor ProcessID) bool {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	_, ok := p.OutChans[neighbor]
+	return ok
+}
+
+func (p *DirectConnectedProcess) isRemoved() bool {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	return p.removed
+}
+
+// Tells a new process its neighbors before it first steps.
+func (p *DirectConnectedProcess) setInitialNeighbors() {
+	p.linkMutex.Lock()
+	p.neighborsChanged = false
+	p.linkMutex.Unlock()
+	if aware, ok := p.P.(NeighborAware); ok {
+		in := copyIDs(p.InNeighbors)
+		out := make(map[ProcessID]struct{}, len(p.OutChans))
+		for pid := range p.OutChans {
+			out[pid] = struct{}{}
+		}
+		aware.SetNeighbors(in, out)
+	}
+}
+
+func (p *DirectConnectedProcess) connect(
+	neighbor ProcessID,
+	outChan chan RoutedMessage,
+	link Link,
+	notify bool,
+) {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	p.OutChans[neighbor] = outChan
+	if link.Latency > 0 {
+		p.Latencies[neighbor] = link.Latency
+	}
+	if link.Weight > 1 {
+		p.Weights[neighbor] = link.Weight
+	}
+	delete(p.downLinks, neighbor)
+	p.neighborsChanged = true
+	if notify {
+		p.linkEvents = append(p.linkEvents, linkEvent{neighbor: neighbor, up: true, added: &link})
+	}
+}
+
+func (p *DirectConnectedProcess) disconnect(neighbor ProcessID) {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	if _, ok := p.OutChans[neighbor]; !ok {
+		return
+	}
+	delete(p.OutChans, neighbor)
+	delete(p.Latencies, neighbor)
+	delete(p.Weights, neighbor)
+	_, wasDown := p.downLinks[neighbor]
+	delete(p.downLinks, neighbor)
+	p.neighborsChanged = true
+	if !wasDown {
+		p.linkEvents = append(p.linkEvents, linkEvent{neighbor: neighbor, up: false})
+	}
+}
+
+func (p *DirectConnectedProcess) setInNeighbor(neighbor ProcessID, in bool) {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	if in {
+		p.InNeighbors[neighbor] = struct{}{}
+	} else {
+		delete(p.InNeighbors, neighbor)
+	}
+	p.neighborsChanged = true
+}
+
+func (c Cluster) addLink(from, to ProcessID, link Link, notify bool) {
+	sender, ok := c[from]
+	if !ok {
+		panic(fmt.Sprintf("cannot link %s to %s: %s is not in the cluster", from, to, from))
+	}
+	receiver, ok := c[to]
+	if !ok {
+		panic(fmt.Sprintf("cannot link %s to %s: %s is not in the cluster", from, to, to))
+	}
+	if from == to {
+		return
+	}
+	sender.connect(to, receiver.InChan, link, notify)
+	receiver.setInNeighbor(from, true)
+}
+
+func (c Cluster) removeLink(from, to ProcessID) {
+	if sender, ok := c[from]; ok {
+		sender.disconnect(to)
+	}
+	if receiver, ok := c[to]; ok {
+		receiver.setInNeighbor(from, false)
+	}
+}
+
+// Links a to b, and b back to a unless link is OneWay.
+// Both ends are told at their next Step.
+func (c Cluster) AddLink(a, b ProcessID, link Link) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	c.addLink(a, b, link, true)
+	if !link.OneWay {
+		c.addLink(b, a, link, true)
+	}
+	trace(TraceEvent{Kind: "topology", From: a, To: b})
+}
+
+// Removes the link between a and b in both directions.
+// Messages still in flight over it are lost.
+func (c Cluster) RemoveLink(a, b ProcessID) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	c.removeLink(a, b)
+	c.removeLink(b, a)
+	trace(TraceEvent{Kind: "topology", From: a, To: b})
+}
+
+// Adds node and its links to the cluster. Links go both ways unless
+// they are marked OneWay, and the neighbors are told at their next Step.
+// The new process starts running if the cluster is.
+func (c Cluster) AddNode(node TopologyNode) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	id := node.Subprocess.Id()
+	if _, ok := c[id]; ok {
+		panic(fmt.Sprintf("cannot add %s: it is already in the cluster", id))
+	}
+	c[id] = newDirectConnectedProcess(node)
+	for _, p := range c {
+		if p.clock != nil {
+			c[id].clock = p.clock
+			break
+		}
+	}
+	for _, neighbor := range sortedNeighbors(node) {
+		if neighbor == id {
+			continue
+		}
+		link := node.Links[neighbor]
+		// The new process was built knowing its neighbors.
+		c.addLink(id, neighbor, link, false)
+		if !link.OneWay {
+			c.addLink(neighbor, id, link, true)
+		}
+	}
+	c[id].setInitialNeighbors()
+	trace(TraceEvent{Kind: "topology", From: id, To: id})
+}
+
+// Removes a node and all its links. Its process stops after its
+// current Step, and messages to it are lost.
+func (c Cluster) RemoveNode(id ProcessID) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	p, ok := c[id]
+	if !ok {
+		panic(fmt.Sprintf("cannot remove %s: it is not in the cluster", id))
+	}
+	p.linkMutex.Lock()
+	neighbors := copyIDs(p.InNeighbors)
+	for pid := range p.OutChans {
+		neighbors[pid] = struct{}{}
+	}
+	p.removed = true
+	p.linkMutex.Unlock()
+	for neighbor := range neighbors {
+		c.removeLink(id, neighbor)
+		c.removeLink(neighbor, id)
+	}
+	delete(c, id)
+	trace(TraceEvent{Kind: "topology", From: id, To: id})
+}
+
+// BellmanFordScenario with churn: node 7, on the shortest path from 1
+// to 8, leaves, and a new node 9 joins next to 3 and 8.
+// Node 1 logs its routes to 8 and 9 as the network reconverges.
+type DynamicRoutingScenario struct{}
+
+func (s DynamicRoutingScenario) options(id ProcessID) BellmanFordOptions {
+	options := DefaultBellmanFordOptions()
+	options.SplitHorizon = true
+	options.PoisonReverse = true
+	if id == 1 {
+		options.LoggedDestinations = map[ProcessID]struct{}{8: {}, 9: {}}
+	}
+	return options
+}
+
+func (s DynamicRoutingScenario) Network() Topology {
+	return bellmanFordScenarioNetwork(func(
+		subprocess Process,
+		neighbors map[ProcessID]struct{},
+	) TopologyNode {
+		links := make(map[ProcessID]Link, len(neighbors))
+		for neighbor := range neighbors {
+			links[neighbor] = Link{}
+		}
+		return NewBellmanFordTopologyNodeWithOptions(
+			subprocess, links, s.options(subprocess.Id()),
+		)
+	})
+}
+
+func (s DynamicRoutingScenario) Script(c Cluster) {
+	c.Sleep(time.Second)
+	fmt.Fprintln(logOutput, "removing 7")
+	c.RemoveNode(7)
+	c.Sleep(time.Second)
+	fmt.Fprintln(logOutput, "adding 9")
+	c.AddNode(NewBellmanFordTopologyNodeWithOptions(
+		SimpleProcess{ID: 9},
+		map[ProcessID]Link{3: {}, 8: {}},
+		s.options(9),
+	))
+}
+
+func (s DynamicRoutingScenario) Check(c Cluster) error {
+	return conversationsComplete(c)
+}
+
+func init() {
+	RegisterScenario("dynamic-routing", "Bellman-Ford as nodes leave and join", func(ScenarioParams) Scenario {
+		return DynamicRoutingScenario{}
+	})
+}
+
+// The cluster as it is now, leaving out links that are cut.
+func (c Cluster) CurrentTopology() Topology {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	topo := make(Topology, len(c))
+	for id, p := range c {
+		p.linkMutex.Lock()
+		node := TopologyNode{
+			Subprocess: p.P,
+			Neighbors: make(map[ProcessID]struct{}, len(p.OutChans)),
+			Links: make(map[ProcessID]Link),
+		}
+		for neighbor := range p.OutChans {
+			if _, down := p.downLinks[neighbor]; down {
+				continue
+			}
+			node.Neighbors[neighbor] = struct{}{}
+			node.Links[neighbor] = Link{Weight: p.Weights[neighbor], Latency: p.Latencies[neighbor]}
+		}
+		p.linkMutex.Unlock()
+		topo[id] = node
+	}
+	return topo
+}
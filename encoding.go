@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// Byte-level encoding of Messages, so they have a real size on the wire
+// and can be checksummed and corrupted like packets.
+// A message type takes part by implementing EncodableMessage and registering
+// a decoder for its tag with RegisterMessageType, usually in an init func
+// next to the type.
+type EncodableMessage interface {
+	Message
+	MessageTag() string
+	Encode(e *Encoder)
+}
+
+type Encoder struct {
+	buf []byte
+	err error
+	// Byte ranges under a checksum of their own, see Checksummed.
+	checksummed [][2]int
+}
+
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+var messageDecoders = map[string]func(d *Decoder) Message{}
+
+func RegisterMessageType(tag string, decode func(d *Decoder) Message) {
+	if _, ok := messageDecoders[tag]; ok {
+		panic(fmt.Sprintf("message tag %q registered twice", tag))
+	}
+	messageDecoders[tag] = decode
+}
+
+func (e *Encoder) WriteInt(v int) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], int64(v))
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *Encoder) WriteUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *Encoder) WriteBytes(b []byte) {
+	e.WriteInt(len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) WriteString(s string) {
+	e.WriteBytes([]byte(s))
+}
+
+func (e *Encoder) WriteProcessIDs(ids map[ProcessID]struct{}) {
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, int(id))
+	}
+	sort.Ints(sorted)
+	e.WriteInt(len(sorted))
+	for _, id := range sorted {
+		e.WriteInt(id)
+	}
+}
+
+// Writes what write writes as a header under its own checksum, as IP
+// headers are: corrupting it gets the frame discarded, not misdelivered.
+// The checksum itself takes no bytes, since only corrupt looks at it.
+func (e *Encoder) Checksummed(write func()) {
+	start := len(e.buf)
+	write()
+	e.checksummed = append(e.checksummed, [2]int{start, len(e.buf)})
+}
+
+// Writes a nested message, which may be nil.
+func (e *Encoder) WriteMessage(m Message) {
+	if m == nil {
+		e.WriteString("")
+		return
+	}
+	encodable, ok := m.(EncodableMessage)
+	if !ok {
+		if e.err == nil {
+			e.err = fmt.Errorf("%T cannot be encoded", m)
+		}
+		return
+	}
+	e.WriteString(encodable.MessageTag())
+	encodable.Encode(e)
+}
+
+func (d *Decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+func (d *Decoder) ReadInt() int {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail(errors.New("malformed varint"))
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *Decoder) ReadUint32() uint32 {
+	if len(d.buf) < 4 {
+		d.fail(errors.New("truncated uint32"))
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+func (d *Decoder) ReadBytes() []byte {
+	n := d.ReadInt()
+	if n < 0 || n > len(d.buf) {
+		d.fail(fmt.Errorf("length %d out of range", n))
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *Decoder) ReadString() string {
+	return string(d.ReadBytes())
+}
+
+func (d *Decoder) ReadProcessIDs() map[ProcessID]struct{} {
+	n := d.ReadInt()
+	if n < 0 || n > len(d.buf) {
+		d.fail(fmt.Errorf("id count %d out of range", n))
+		return nil
+	}
+	ids := make(map[ProcessID]struct{}, n)
+	for i := 0; i < n; i++ {
+		ids[ProcessID(d.ReadInt())] = struct{}{}
+	}
+	return ids
+}
+
+func (d *Decoder) ReadMessage() Message {
+	tag := d.ReadString()
+	if tag == "" || d.err != nil {
+		return nil
+	}
+	decode, ok := messageDecoders[tag]
+	if !ok {
+		d.fail(fmt.Errorf("unknown message tag %q", tag))
+		return nil
+	}
+	return decode(d)
+}
+
+func EncodeMessage(m Message) ([]byte, error) {
+	e := &Encoder{}
+	e.WriteMessage(m)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf, nil
+}
+
+func DecodeMessage(b []byte) (Message, error) {
+	d := &Decoder{buf: b}
+	m := d.ReadMessage()
+	if d.err == nil && len(d.buf) > 0 {
+		d.fail(fmt.Errorf("%d trailing bytes", len(d.buf)))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+// CRC-32 of a message's encoding. Messages that cannot be encoded
+// are checksummed by their String instead; the link layer never corrupts them.
+func Checksum(m Message) uint32 {
+	b, err := EncodeMessage(m)
+	if err != nil {
+		return crc32.ChecksumIEEE([]byte(m.String()))
+	}
+	return crc32.ChecksumIEEE(b)
+}
+
+// Flips a random bit in the message's encoding.
+// Returns false if the bit is in a checksummed header, or the result no
+// longer decodes, as a receiver would discard such a frame outright.
+// Messages that cannot be encoded come back unchanged.
+func corrupt(m Message) (Message, bool) {
+	e := &Encoder{}
+	e.WriteMessage(m)
+	b := e.buf
+	if e.err != nil || len(b) == 0 {
+		return m, true
+	}
+	i := simRand.Intn(len(b) * 8)
+	for _, header := range e.checksummed {
+		if i/8 >= header[0] && i/8 < header[1] {
+			return nil, false
+		}
+	}
+	b[i/8] ^= 1 << uint(i%8)
+	corrupted, err := DecodeMessage(b)
+	if err != nil {
+		return nil, false
+	}
+	return corrupted, true
+}
+
+func (m RoutedMessage) MessageTag() string {
+	return "routed"
+}
+
+func (m RoutedMessage) Encode(e *Encoder) {
+	e.Checksummed(func() {
+		e.WriteInt(int(m.From))
+		e.WriteInt(int(m.To))
+	})
+	e.WriteMessage(m.Message)
+}
+
+func (m MessageWithContent) MessageTag() string {
+	return "content"
+}
+
+func (m MessageWithContent) Encode(e *Encoder) {
+	e.WriteString(m.Content)
+	e.WriteMessage(m.Message)
+}
+
+func init() {
+	RegisterMessageType("routed", func(d *Decoder) Message {
+		m := RoutedMessage{}
+		m.From = ProcessID(d.ReadInt())
+		m.To = ProcessID(d.ReadInt())
+		m.Message = d.ReadMessage()
+		return m
+	})
+	RegisterMessageType("content", func(d *Decoder) Message {
+		m := MessageWithContent{}
+		m.Content = d.ReadString()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
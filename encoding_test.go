@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCorruptSparesCheckedHeaders(t *testing.T) {
+	SeedSimulation(1)
+	data := TCPDataMessage{Message: ConversationMessage{Phrase: "hello", Index: 0}, seq: 3}.withChecksum()
+	m := RoutedMessage{Message: data, From: 1, To: 8}
+	delivered, caught := 0, 0
+	for i := 0; i < 1000; i++ {
+		corrupted, ok := corrupt(m)
+		if !ok {
+			continue
+		}
+		routed, ok := corrupted.(RoutedMessage)
+		if !ok {
+			continue
+		}
+		if routed.From != 1 || routed.To != 8 {
+			t.Fatalf("routed %s->%s", routed.From, routed.To)
+		}
+		delivered++
+		if segment, ok := routed.Message.(TCPDataMessage); !ok || !segment.valid() {
+			caught++
+		}
+	}
+	// Every flip past the header is in the segment, whose checksum fails.
+	if delivered == 0 || caught != delivered {
+		t.Errorf("%d of %d corrupted segments caught", caught, delivered)
+	}
+}
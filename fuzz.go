@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Runs a scenario many times with choices drawn at random, for clusters
+// too big to model check. Each run is a walk over the same choices the
+// model checker makes, so messages are delayed by others overtaking them,
+// and some are dropped and some processes crash. When an invariant
+// breaks, the run's trace is shrunk to a short one that still breaks
+// one, which ReplayModelTrace repeats exactly. Scenario scripts don't run.
+
+type FuzzOptions struct {
+	// Runs use seeds Seed to Seed+Runs-1.
+	Runs int
+	Seed int64
+	// Choices made in each run.
+	Steps int
+	// The chance a message chosen is dropped rather than delivered.
+	DropRate float64
+	// The chance of a crash before each choice, while fewer
+	// than MaxCrashes processes have crashed.
+	CrashRate float64
+	MaxCrashes int
+}
+
+func DefaultFuzzOptions() FuzzOptions {
+	return FuzzOptions{
+		Runs: 1000,
+		Seed: 1,
+		Steps: 500,
+		DropRate: 0.05,
+		CrashRate: 0.005,
+		MaxCrashes: 1,
+	}
+}
+
+type FuzzResult struct {
+	Runs int
+	// The seed of the run that broke an invariant, if any.
+	Seed int64
+	Violation error
+	// The choices of the failing run up to the violation, and shrunk.
+	Original []Choice
+	Trace []Choice
+}
+
+func (r FuzzResult) String() string {
+	if r.Violation == nil {
+		return fmt.Sprintf("%d runs, no violations\n", r.Runs)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "run %d, seed %d: %s\n", r.Runs, r.Seed, r.Violation)
+	fmt.Fprintf(&b, "shrunk from %d choices to %d:\n", len(r.Original), len(r.Trace))
+	for i, choice := range r.Trace {
+		fmt.Fprintf(&b, "%3d. %s\n", i+1, choice)
+	}
+	return b.String()
+}
+
+// Fuzzes scenario against its invariants, stopping at the first run
+// that breaks one.
+func Fuzz(scenario Scenario, options FuzzOptions) FuzzResult {
+	var invariants []Invariant
+	if withInvariants, ok := scenario.(InvariantScenario); ok {
+		invariants = withInvariants.Invariants()
+	}
+	if err := CheckTopology(scenario.Network()); err != nil {
+		panic(err.Error())
+	}
+	result := FuzzResult{}
+	for i := 0; i < options.Runs; i++ {
+		seed := options.Seed + int64(i)
+		result.Runs++
+		trace, err := fuzzRun(scenario, invariants, options, seed)
+		if err != nil {
+			result.Seed = seed
+			result.Original = trace
+			result.Trace, result.Violation = ShrinkTrace(scenario, invariants, trace)
+			return result
+		}
+	}
+	return result
+}
+
+// One random run, returning its trace up to the first violation.
+func fuzzRun(scenario Scenario, invariants []Invariant, options FuzzOptions, seed int64) ([]Choice, error) {
+	r := rand.New(rand.NewSource(seed))
+	w := newModelWorld(scenario)
+	var trace []Choice
+	crashes := 0
+	for len(trace) < options.Steps {
+		var live []ProcessID
+		for _, id := range w.ids {
+			if !w.crashed[id] {
+				live = append(live, id)
+			}
+		}
+		if len(live) == 0 {
+			break
+		}
+		var choice Choice
+		if crashes < options.MaxCrashes && r.Float64() < options.CrashRate {
+			crashes++
+			choice = Choice{Kind: CrashChoice, Process: live[r.Intn(len(live))]}
+		} else if i := r.Intn(len(live) + len(w.inFlight)); i < len(live) {
+			choice = Choice{Kind: StepChoice, Process: live[i]}
+		} else {
+			m := w.inFlight[i-len(live)]
+			routed := m.RoutedMessage
+			choice = Choice{Kind: DeliverChoice, Process: m.To, Message: &routed, messageHash: m.hash}
+			if r.Float64() < options.DropRate {
+				choice.Kind = DropChoice
+			}
+		}
+		w.apply(choice)
+		trace = append(trace, choice)
+		if err := w.check(invariants); err != nil {
+			return trace, err
+		}
+	}
+	return trace, nil
+}
+
+// Replays trace, skipping the choices it makes impossible, and returns
+// the choices made up to the first violation, or nil if there is none.
+func replayToViolation(scenario Scenario, invariants []Invariant, trace []Choice) ([]Choice, error) {
+	w := newModelWorld(scenario)
+	var made []Choice
+	for _, choice := range trace {
+		if !w.tryApply(choice) {
+			continue
+		}
+		made = append(made, choice)
+		if err := w.check(invariants); err != nil {
+			return made, err
+		}
+	}
+	return nil, nil
+}
+
+// Shrinks a trace that breaks an invariant to one that still does,
+// where removing any one choice, or swapping two neighbors to bring
+// the lower process first, would not. Chunks of choices are removed
+// first, halving in size, as in delta debugging.
+func ShrinkTrace(scenario Scenario, invariants []Invariant, trace []Choice) ([]Choice, error) {
+	trace, violation := replayToViolation(scenario, invariants, trace)
+	if violation == nil {
+		panic("cannot shrink a trace that breaks no invariant")
+	}
+	for chunk := len(trace) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(trace); {
+			candidate := append(append([]Choice{}, trace[:start]...), trace[start+chunk:]...)
+			if shrunk, err := replayToViolation(scenario, invariants, candidate); err != nil {
+				trace, violation = shrunk, err
+				continue
+			}
+			start += chunk
+		}
+	}
+	// Put the order in a canonical form, so that traces read alike.
+	for swapped := true; swapped; {
+		swapped = false
+		for i := 0; i+1 < len(trace); i++ {
+			if trace[i+1].Process >= trace[i].Process {
+				continue
+			}
+			candidate := append([]Choice{}, trace...)
+			candidate[i], candidate[i+1] = candidate[i+1], candidate[i]
+			shrunk, err := replayToViolation(scenario, invariants, candidate)
+			if err != nil && len(shrunk) == len(candidate) {
+				trace, violation = shrunk, err
+				swapped = true
+			}
+		}
+	}
+	return trace, violation
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFuzzShrinksTrace(t *testing.T) {
+	scenario := PaxosScenario{SkipPrepare: true}
+	result := Fuzz(scenario, DefaultFuzzOptions())
+	if result.Violation == nil {
+		t.Fatalf("no violation found: %s", result)
+	}
+	// As short as the model checker's.
+	if len(result.Trace) != 10 {
+		t.Errorf("trace not shrunk to 10 choices:\n%s", result)
+	}
+	if err := paxosAgreement(ReplayModelTrace(scenario, result.Trace)); err == nil {
+		t.Errorf("replayed trace does not break agreement:\n%s", result)
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	options := DefaultFuzzOptions()
+	options.Runs = 200
+	options.CrashRate = 0.05
+	for _, name := range []string{"paxos", "lcr", "bellman-ford"} {
+		scenario, err := NewScenario(name, DefaultScenarioParams())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := Fuzz(scenario, options); result.Violation != nil {
+			t.Errorf("%s: %s", name, result)
+		}
+	}
+}
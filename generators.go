@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Generators for common families of graphs.
+// Nodes are numbered from 0, like CompleteTopology and RandomProcess expect,
+// and newProcess creates the subprocess for each id.
+// Random families take a seed, so the same seed always gives the same graph.
+
+type edges map[ProcessID]map[ProcessID]struct{}
+
+func newEdges(n int) edges {
+	e := make(edges, n)
+	for i := 0; i < n; i++ {
+		e[ProcessID(i)] = make(map[ProcessID]struct{})
+	}
+	return e
+}
+
+func (e edges) add(a, b ProcessID) {
+	if a == b {
+		return
+	}
+	e[a][b] = struct{}{}
+	e[b][a] = struct{}{}
+}
+
+func (e edges) has(a, b ProcessID) bool {
+	_, ok := e[a][b]
+	return ok
+}
+
+func (e edges) topology(newProcess func(ProcessID) Process) Topology {
+	topo := make(Topology, len(e))
+	for id, neighbors := range e {
+		topo[id] = TopologyNode{
+			Subprocess: newProcess(id),
+			Neighbors: neighbors,
+		}
+	}
+	return topo
+}
+
+func LineTopology(n int, newProcess func(ProcessID) Process) Topology {
+	e := newEdges(n)
+	for i := 1; i < n; i++ {
+		e.add(ProcessID(i-1), ProcessID(i))
+	}
+	return e.topology(newProcess)
+}
+
+func RingTopology(n int, newProcess func(ProcessID) Process) Topology {
+	e := newEdges(n)
+	for i := 0; i < n; i++ {
+		e.add(ProcessID(i), ProcessID((i+1)%n))
+	}
+	return e.topology(newProcess)
+}
+
+// A ring where node i can only send to node i+1.
+func DirectedRingTopology(n int, newProcess func(ProcessID) Process) Topology {
+	topo := make(Topology, n)
+	for i := 0; i < n; i++ {
+		id, next := ProcessID(i), ProcessID((i+1)%n)
+		node := TopologyNode{
+			Subprocess: newProcess(id),
+			Neighbors: map[ProcessID]struct{}{},
+			Links: map[ProcessID]Link{},
+		}
+		if next != id {
+			node.Neighbors[next] = struct{}{}
+			// With two nodes, the ring links go both ways.
+			node.Links[next] = Link{OneWay: n > 2}
+		}
+		topo[id] = node
+	}
+	return topo
+}
+
+// Node 0 is the hub.
+func StarTopology(n int, newProcess func(ProcessID) Process) Topology {
+	e := newEdges(n)
+	for i := 1; i < n; i++ {
+		e.add(0, ProcessID(i))
+	}
+	return e.topology(newProcess)
+}
+
+// Node r*cols+c sits at row r, column c.
+func GridTopology(rows, cols int, newProcess func(ProcessID) Process) Topology {
+	return grid(rows, cols, false).topology(newProcess)
+}
+
+// A grid whose rows and columns wrap around.
+func TorusTopology(rows, cols int, newProcess func(ProcessID) Process) Topology {
+	return grid(rows, cols, true).topology(newProcess)
+}
+
+func grid(rows, cols int, wrap bool) edges {
+	e := newEdges(rows * cols)
+	id := func(r, c int) ProcessID {
+		return ProcessID(r*cols + c)
+	}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			if c+1 < cols || (wrap && cols > 2) {
+				e.add(id(r, c), id(r, (c+1)%cols))
+			}
+			if r+1 < rows || (wrap && rows > 2) {
+				e.add(id(r, c), id((r+1)%rows, c))
+			}
+		}
+	}
+	return e
+}
+
+// A complete k-ary tree filled in breadth first; node 0 is the root
+// and the children of node i are k*i+1 to k*i+k.
+func TreeTopology(n, k int, newProcess func(ProcessID) Process) Topology {
+	if k < 1 {
+		panic(fmt.Sprintf("tree needs at least one child per node, not %d", k))
+	}
+	e := newEdges(n)
+	for i := 1; i < n; i++ {
+		e.add(ProcessID((i-1)/k), ProcessID(i))
+	}
+	return e.topology(newProcess)
+}
+
+// 2^dimensions nodes, linked when their ids differ in exactly one bit.
+func HypercubeTopology(dimensions int, newProcess func(ProcessID) Process) Topology {
+	n := 1 << uint(dimensions)
+	e := newEdges(n)
+	for i := 0; i < n; i++ {
+		for bit := 0; bit < dimensions; bit++ {
+			e.add(ProcessID(i), ProcessID(i^(1<<uint(bit))))
+		}
+	}
+	return e.topology(newProcess)
+}
+
+// G(n, p): each pair of nodes is linked with probability p.
+// If connected is set, components are then joined by random extra links.
+func ErdosRenyiTopology(
+	n int,
+	p float64,
+	seed int64,
+	connected bool,
+	newProcess func(ProcessID) Process,
+) Topology {
+	r := rand.New(rand.NewSource(seed))
+	e := newEdges(n)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if r.Float64() < p {
+				e.add(ProcessID(i), ProcessID(j))
+			}
+		}
+	}
+	if connected {
+		e.connect(r)
+	}
+	return e.topology(newProcess)
+}
+
+// Preferential attachment: starting from a clique of m+1 nodes,
+// each new node links to m existing nodes chosen in proportion to
+// their degree. Always connected.
+func BarabasiAlbertTopology(
+	n int,
+	m int,
+	seed int64,
+	newProcess func(ProcessID) Process,
+) Topology {
+	if m < 1 {
+		panic(fmt.Sprintf("each new node needs at least one link, not %d", m))
+	}
+	r := rand.New(rand.NewSource(seed))
+	e := newEdges(n)
+	// Every link appears here once per end, so picking uniformly
+	// from it picks nodes in proportion to their degree.
+	var ends []ProcessID
+	for i := 0; i <= m && i < n; i++ {
+		for j := 0; j < i; j++ {
+			e.add(ProcessID(i), ProcessID(j))
+			ends = append(ends, ProcessID(i), ProcessID(j))
+		}
+	}
+	for i := m + 1; i < n; i++ {
+		id := ProcessID(i)
+		for len(e[id]) < m {
+			target := ends[r.Intn(len(ends))]
+			if target != id && !e.has(id, target) {
+				e.add(id, target)
+				ends = append(ends, id, target)
+			}
+		}
+	}
+	return e.topology(newProcess)
+}
+
+// Every node has exactly degree neighbors, drawn by pairing up stubs.
+// n*degree must be even. If connected is set, disconnected graphs are
+// thrown away and drawn again.
+func RandomRegularTopology(
+	n int,
+	degree int,
+	seed int64,
+	connected bool,
+	newProcess func(ProcessID) Process,
+) Topology {
+	if degree >= n || n*degree%2 != 0 {
+		panic(fmt.Sprintf("no %d-regular graph on %d nodes", degree, n))
+	}
+	const attempts = 1000
+	r := rand.New(rand.NewSource(seed))
+	for attempt := 0; attempt < attempts; attempt++ {
+		e, ok := randomRegular(n, degree, r)
+		if !ok || (connected && len(e.components()) > 1) {
+			continue
+		}
+		return e.topology(newProcess)
+	}
+	panic(fmt.Sprintf("failed to draw a %d-regular graph on %d nodes", degree, n))
+}
+
+// Pairs up degree stubs per node at random, as Steger and Wormald do:
+// a pair that would make a self loop or a repeated link is put back and
+// another drawn, and the draw only fails if no stubs left can pair.
+func randomRegular(n, degree int, r *rand.Rand) (edges, bool) {
+	stubs := make([]ProcessID, 0, n*degree)
+	for i := 0; i < n; i++ {
+		for j := 0; j < degree; j++ {
+			stubs = append(stubs, ProcessID(i))
+		}
+	}
+	e := newEdges(n)
+	suitable := func(i, j int) bool {
+		return i != j && stubs[i] != stubs[j] && !e.has(stubs[i], stubs[j])
+	}
+	for len(stubs) > 0 {
+		i, j := r.Intn(len(stubs)), r.Intn(len(stubs))
+		for tries := 0; !suitable(i, j); tries++ {
+			if tries < 100 {
+				i, j = r.Intn(len(stubs)), r.Intn(len(stubs))
+				continue
+			}
+			// Few stubs are left, so look at every pair of them.
+			var pairs [][2]int
+			for a := range stubs {
+				for b := a + 1; b < len(stubs); b++ {
+					if suitable(a, b) {
+						pairs = append(pairs, [2]int{a, b})
+					}
+				}
+			}
+			if len(pairs) == 0 {
+				return nil, false
+			}
+			pair := pairs[r.Intn(len(pairs))]
+			i, j = pair[0], pair[1]
+		}
+		e.add(stubs[i], stubs[j])
+		if i < j {
+			i, j = j, i
+		}
+		// Remove the larger index first, so the smaller stays put.
+		stubs[i] = stubs[len(stubs)-1]
+		stubs = stubs[:len(stubs)-1]
+		stubs[j] = stubs[len(stubs)-1]
+		stubs = stubs[:len(stubs)-1]
+	}
+	return e, true
+}
+
+func (e edges) components() [][]ProcessID {
+	topo := make(Topology, len(e))
+	for id, neighbors := range e {
+		topo[id] = TopologyNode{Neighbors: neighbors}
+	}
+	return Components(topo)
+}
+
+// Links each component to a random node of the one before it.
+func (e edges) connect(r *rand.Rand) {
+	components := e.components()
+	for i := 1; i < len(components); i++ {
+		previous := components[i-1]
+		current := components[i]
+		e.add(previous[r.Intn(len(previous))], current[r.Intn(len(current))])
+	}
+}
+
+// Replaces each node of topo with newNode's, keeping its faults, links
+// and AS unless newNode sets them. newNode has the shape of
+// NewBellmanFordTopologyNode, so generated graphs can be routed.
+func MapTopology(
+	topo Topology,
+	newNode func(subprocess Process, neighbors map[ProcessID]struct{}) TopologyNode,
+) Topology {
+	mapped := make(Topology, len(topo))
+	for id, node := range topo {
+		newTopoNode := newNode(node.Subprocess, node.Neighbors)
+		if newTopoNode.Faults == (LinkFaults{}) {
+			newTopoNode.Faults = node.Faults
+		}
+		if newTopoNode.Links == nil {
+			newTopoNode.Links = node.Links
+		}
+		if newTopoNode.AS == 0 {
+			newTopoNode.AS = node.AS
+		}
+		mapped[id] = newTopoNode
+	}
+	return mapped
+}
+
+// A conversation between the first and last nodes of a random graph,
+// routed by Bellman-Ford.
+type RandomGraphRoutingScenario struct {
+	Nodes int
+	Seed int64
+}
+
+func (s RandomGraphRoutingScenario) Network() Topology {
+	newProcess := conversationEnds(0, ProcessID(s.Nodes-1))
+	topo := ErdosRenyiTopology(s.Nodes, 2/float64(s.Nodes), s.Seed, true, newProcess)
+	return MapTopology(topo, NewBellmanFordTopologyNode)
+}
+
+// Makes a conversation between first and last, and SimpleProcesses elsewhere.
+func conversationEnds(first, last ProcessID) func(ProcessID) Process {
+	return func(id ProcessID) Process {
+		switch id {
+		case first:
+			return &ConversationProcess{
+				ID: first,
+				FriendID: last,
+				Phrases: []string{"anyone out there", "nice to meet you"},
+				Initiate: true,
+			}
+		case last:
+			return &ConversationProcess{
+				ID: last,
+				FriendID: first,
+				Phrases: []string{"right here", "likewise"},
+			}
+		}
+		return SimpleProcess{ID: id}
+	}
+}
+
+func (s RandomGraphRoutingScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s RandomGraphRoutingScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func init() {
+	RegisterScenario("random-graph", "Bellman-Ford over a random graph of GraphSize nodes drawn from Seed", func(params ScenarioParams) Scenario {
+		return RandomGraphRoutingScenario{Nodes: params.GraphSize, Seed: params.Seed}
+	})
+}
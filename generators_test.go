@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRandomRegularTopology(t *testing.T) {
+	for _, degree := range []int{3, 6, 8} {
+		for seed := int64(1); seed <= 5; seed++ {
+			topo := RandomRegularTopology(50, degree, seed, true, placeholderProcess)
+			if err := CheckTopology(topo); err != nil {
+				t.Fatalf("degree %d, seed %d: %s", degree, seed, err)
+			}
+			for id, node := range topo {
+				if len(node.Neighbors) != degree {
+					t.Errorf("degree %d, seed %d: %s has %d neighbors", degree, seed, id, len(node.Neighbors))
+				}
+				if _, ok := node.Neighbors[id]; ok {
+					t.Errorf("degree %d, seed %d: %s links to itself", degree, seed, id)
+				}
+			}
+			if components := len(Components(topo)); components != 1 {
+				t.Errorf("degree %d, seed %d: %d components", degree, seed, components)
+			}
+		}
+	}
+}
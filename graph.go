@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Least total link Weight from source to every reachable node,
+// computed centrally with Dijkstra's algorithm.
+// Useful as ground truth for distributed routing.
+func ShortestPaths(topo Topology, source ProcessID) map[ProcessID]int {
+	distances := map[ProcessID]int{source: 0}
+	done := make(map[ProcessID]struct{}, len(topo))
+	for {
+		closest, found := ProcessID(0), false
+		for id, distance := range distances {
+			if _, ok := done[id]; ok {
+				continue
+			}
+			if !found || distance < distances[closest] ||
+				(distance == distances[closest] && id < closest) {
+				closest, found = id, true
+			}
+		}
+		if !found {
+			return distances
+		}
+		done[closest] = struct{}{}
+		node := topo[closest]
+		for neighbor := range node.Neighbors {
+			if neighbor == closest {
+				continue
+			}
+			distance := distances[closest] + node.Weight(neighbor)
+			if known, ok := distances[neighbor]; !ok || distance < known {
+				distances[neighbor] = distance
+			}
+		}
+	}
+}
+
+// The connected components of topo, ignoring the direction of links,
+// each sorted by id, in order of their smallest id.
+func Components(topo Topology) [][]ProcessID {
+	linked := undirected(topo)
+	seen := make(map[ProcessID]struct{}, len(topo))
+	var components [][]ProcessID
+	for _, start := range sortedIDs(topo) {
+		if _, ok := seen[start]; ok {
+			continue
+		}
+		seen[start] = struct{}{}
+		component := []ProcessID{}
+		frontier := []ProcessID{start}
+		for len(frontier) > 0 {
+			id := frontier[0]
+			frontier = frontier[1:]
+			component = append(component, id)
+			for neighbor := range linked[id] {
+				if _, ok := seen[neighbor]; ok {
+					continue
+				}
+				seen[neighbor] = struct{}{}
+				frontier = append(frontier, neighbor)
+			}
+		}
+		sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+		components = append(components, component)
+	}
+	return components
+}
+
+// Each node's neighbors either way, leaving out self loops and
+// links to nodes not in topo.
+func undirected(topo Topology) map[ProcessID]map[ProcessID]struct{} {
+	linked := make(map[ProcessID]map[ProcessID]struct{}, len(topo))
+	for id, node := range topo {
+		for neighbor := range node.Neighbors {
+			if _, ok := topo[neighbor]; !ok || neighbor == id {
+				continue
+			}
+			for _, pair := range [][2]ProcessID{{id, neighbor}, {neighbor, id}} {
+				if linked[pair[0]] == nil {
+					linked[pair[0]] = make(map[ProcessID]struct{})
+				}
+				linked[pair[0]][pair[1]] = struct{}{}
+			}
+		}
+	}
+	return linked
+}
+
+// Fewest hops from source to every reachable node, ignoring weights.
+func HopDistances(topo Topology, source ProcessID) map[ProcessID]int {
+	distances := map[ProcessID]int{source: 0}
+	frontier := []ProcessID{source}
+	for len(frontier) > 0 {
+		id := frontier[0]
+		frontier = frontier[1:]
+		for neighbor := range topo[id].Neighbors {
+			if _, ok := distances[neighbor]; ok {
+				continue
+			}
+			if _, ok := topo[neighbor]; !ok {
+				continue
+			}
+			distances[neighbor] = distances[id] + 1
+			frontier = append(frontier, neighbor)
+		}
+	}
+	return distances
+}
+
+// A path from source to dest with the least total link Weight,
+// breaking ties towards lower ids. Empty if dest is unreachable.
+func ShortestPath(topo Topology, source, dest ProcessID) []ProcessID {
+	// The least weight from each node to dest, which differs from
+	// dest's to it when links weigh differently each way or go one way.
+	distances := ShortestPaths(reversed(topo), dest)
+	if _, ok := distances[source]; !ok {
+		return nil
+	}
+	path := []ProcessID{source}
+	for at := source; at != dest; {
+		next, found := ProcessID(0), false
+		for _, neighbor := range sortedNeighbors(topo[at]) {
+			distance, ok := distances[neighbor]
+			if ok && neighbor != at && distance+topo[at].Weight(neighbor) == distances[at] {
+				next, found = neighbor, true
+				break
+			}
+		}
+		if !found {
+			panic(fmt.Sprintf("no next hop from %s towards %s", at, dest))
+		}
+		path = append(path, next)
+		at = next
+	}
+	return path
+}
+
+// topo with every link turned around, keeping its weight.
+func reversed(topo Topology) Topology {
+	reverse := make(Topology, len(topo))
+	for id := range topo {
+		reverse[id] = TopologyNode{
+			Neighbors: make(map[ProcessID]struct{}),
+			Links: make(map[ProcessID]Link),
+		}
+	}
+	for id, node := range topo {
+		for neighbor := range node.Neighbors {
+			if _, ok := reverse[neighbor]; !ok {
+				continue
+			}
+			reverse[neighbor].Neighbors[id] = struct{}{}
+			reverse[neighbor].Links[id] = Link{Weight: node.Weight(neighbor)}
+		}
+	}
+	return reverse
+}
+
+// The most hops between any two nodes, or false if some node
+// cannot reach another.
+func Diameter(topo Topology) (int, bool) {
+	diameter := 0
+	for id := range topo {
+		distances := HopDistances(topo, id)
+		if len(distances) < len(topo) {
+			return 0, false
+		}
+		for _, distance := range distances {
+			if distance > diameter {
+				diameter = distance
+			}
+		}
+	}
+	return diameter, true
+}
+
+// Number of neighbors of each node, not counting itself.
+func Degrees(topo Topology) map[ProcessID]int {
+	degrees := make(map[ProcessID]int, len(topo))
+	for id, node := range topo {
+		degrees[id] = len(node.Neighbors)
+		if _, ok := node.Neighbors[id]; ok {
+			degrees[id] -= 1
+		}
+	}
+	return degrees
+}
+
+// How many nodes have each degree.
+func DegreeDistribution(topo Topology) map[int]int {
+	distribution := make(map[int]int)
+	for _, degree := range Degrees(topo) {
+		distribution[degree] += 1
+	}
+	return distribution
+}
+
+// Whether the nodes split into two sides with every link between them,
+// ignoring the direction of links. If so, side gives each node's side, 0 or 1, with the lowest id
+// of each component on side 0.
+func Bipartite(topo Topology) (side map[ProcessID]int, ok bool) {
+	side = make(map[ProcessID]int, len(topo))
+	linked := undirected(topo)
+	for _, start := range sortedIDs(topo) {
+		if _, seen := side[start]; seen {
+			continue
+		}
+		side[start] = 0
+		frontier := []ProcessID{start}
+		for len(frontier) > 0 {
+			id := frontier[0]
+			frontier = frontier[1:]
+			for neighbor := range linked[id] {
+				neighborSide, seen := side[neighbor]
+				if !seen {
+					side[neighbor] = 1 - side[id]
+					frontier = append(frontier, neighbor)
+				} else if neighborSide == side[id] {
+					return nil, false
+				}
+			}
+		}
+	}
+	return side, true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShortestPathOneWay(t *testing.T) {
+	// 0 -> 1 -> 2 cost 1 each, the one-way way round; 0 - 2 costs 10
+	// from 0 but only 1 back, and 2 -> 0 is the only way back.
+	topo := Topology{
+		0: {Neighbors: map[ProcessID]struct{}{1: {}, 2: {}}, Links: map[ProcessID]Link{
+			1: {Weight: 1, OneWay: true}, 2: {Weight: 10},
+		}},
+		1: {Neighbors: map[ProcessID]struct{}{2: {}}, Links: map[ProcessID]Link{
+			2: {Weight: 1, OneWay: true},
+		}},
+		2: {Neighbors: map[ProcessID]struct{}{0: {}}, Links: map[ProcessID]Link{
+			0: {Weight: 1},
+		}},
+	}
+	if path := ShortestPath(topo, 0, 2); !reflect.DeepEqual(path, []ProcessID{0, 1, 2}) {
+		t.Errorf("0 to 2 by %v", path)
+	}
+	if path := ShortestPath(topo, 2, 1); !reflect.DeepEqual(path, []ProcessID{2, 0, 1}) {
+		t.Errorf("2 to 1 by %v", path)
+	}
+	if path := ShortestPath(topo, 1, 1); !reflect.DeepEqual(path, []ProcessID{1}) {
+		t.Errorf("1 to itself by %v", path)
+	}
+}
+
+func TestBipartiteOneWay(t *testing.T) {
+	oneWay := func(to ProcessID) TopologyNode {
+		return TopologyNode{
+			Neighbors: map[ProcessID]struct{}{to: {}},
+			Links: map[ProcessID]Link{to: {OneWay: true}},
+		}
+	}
+	if _, ok := Bipartite(Topology{0: oneWay(1), 1: oneWay(2), 2: oneWay(0)}); ok {
+		t.Error("a one-way triangle is bipartite")
+	}
+	// 1 is only reachable by following 2's link backwards from 0.
+	side, ok := Bipartite(Topology{0: oneWay(2), 1: oneWay(2), 2: {}})
+	if !ok || len(side) != 3 || side[0] != 0 || side[1] != 0 || side[2] != 1 {
+		t.Errorf("sides %v, %t", side, ok)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Hirschberg-Sinclair leader election on a ring of two-way links.
+// In phase l each process still in the running sends its id 2^l hops
+// both ways; a larger id swallows it, and otherwise it turns back at
+// the last hop. A process whose id comes back from both sides goes on
+// to the next phase, and one whose id makes it all the way around has
+// the largest and announces itself. O(n log n) messages, where LCR may
+// need O(n^2).
+type HSProcess struct {
+	ID ProcessID
+	LeaderId ProcessID
+	LeaderFound bool
+	neighbors []ProcessID
+	phase int
+	// Replies heard in the current phase.
+	replies int
+	hasStarted bool
+}
+
+type HSMessageKind int
+
+const (
+	HSOut HSMessageKind = iota
+	HSIn
+	HSElected
+)
+
+type HSMessage struct {
+	UID ProcessID
+	Kind HSMessageKind
+	// Hops travelled outward, of the Limit of its phase.
+	Hop int
+	Limit int
+}
+
+func (m HSMessage) String() string {
+	switch m.Kind {
+	case HSOut:
+		return fmt.Sprintf("HS(%s out %d/%d)", m.UID, m.Hop, m.Limit)
+	case HSIn:
+		return fmt.Sprintf("HS(%s in)", m.UID)
+	}
+	return fmt.Sprintf("HS(%s elected)", m.UID)
+}
+
+func (m HSMessage) MessageTag() string {
+	return "hs"
+}
+
+func (m HSMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.UID))
+	e.WriteInt(int(m.Kind))
+	e.WriteInt(m.Hop)
+	e.WriteInt(m.Limit)
+}
+
+func init() {
+	RegisterMessageType("hs", func(d *Decoder) Message {
+		m := HSMessage{}
+		m.UID = ProcessID(d.ReadInt())
+		m.Kind = HSMessageKind(d.ReadInt())
+		m.Hop = d.ReadInt()
+		m.Limit = d.ReadInt()
+		return m
+	})
+}
+
+func (p *HSProcess) Id() ProcessID {
+	return p.ID
+}
+
+func (p *HSProcess) SetNeighbors(in map[ProcessID]struct{}, out map[ProcessID]struct{}) {
+	if len(out) > 2 {
+		panic(fmt.Sprintf("HS runs on a ring, but %s has %d neighbors", p.ID, len(out)))
+	}
+	p.neighbors = sortedSet(out)
+}
+
+// The neighbor on the other side from from. On a ring of two they are the same.
+func (p *HSProcess) other(from ProcessID) ProcessID {
+	for _, neighbor := range p.neighbors {
+		if neighbor != from {
+			return neighbor
+		}
+	}
+	return from
+}
+
+func (p *HSProcess) startPhase(send func(RoutedMessage)) {
+	p.replies = 0
+	for _, neighbor := range p.neighbors {
+		send(RoutedMessage{Message: HSMessage{UID: p.ID, Kind: HSOut, Hop: 1, Limit: 1 << uint(p.phase)}, From: p.ID, To: neighbor})
+	}
+}
+
+func (p *HSProcess) elect(send func(RoutedMessage)) {
+	p.LeaderId = p.ID
+	p.LeaderFound = true
+	Log(p, "elected leader")
+	if len(p.neighbors) > 0 {
+		send(RoutedMessage{Message: HSMessage{UID: p.ID, Kind: HSElected}, From: p.ID, To: p.neighbors[0]})
+	}
+}
+
+func (p *HSProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	if !p.hasStarted {
+		p.hasStarted = true
+		if len(p.neighbors) == 0 {
+			p.elect(send)
+			return
+		}
+		p.startPhase(send)
+	}
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(HSMessage)
+		if !ok {
+			panic(fmt.Sprintf("HS unexpected message type %T %s", received.Message, received.Message))
+		}
+		onward := p.other(received.From)
+		switch {
+		case m.Kind == HSElected:
+			if m.UID == p.ID {
+				// The announcement made it around.
+				continue
+			}
+			p.LeaderId = m.UID
+			p.LeaderFound = true
+			Log(p, fmt.Sprintf("found leader %s", p.LeaderId))
+			send(RoutedMessage{Message: m, From: p.ID, To: onward})
+		case m.Kind == HSOut && m.UID == p.ID:
+			// Around the ring without meeting a larger id.
+			if !p.LeaderFound {
+				p.elect(send)
+			}
+		case m.Kind == HSOut && m.UID > p.ID && m.Hop < m.Limit:
+			m.Hop++
+			send(RoutedMessage{Message: m, From: p.ID, To: onward})
+		case m.Kind == HSOut && m.UID > p.ID:
+			send(RoutedMessage{Message: HSMessage{UID: m.UID, Kind: HSIn}, From: p.ID, To: received.From})
+		case m.Kind == HSIn && m.UID != p.ID:
+			send(RoutedMessage{Message: m, From: p.ID, To: onward})
+		case m.Kind == HSIn:
+			p.replies++
+			if p.replies == len(p.neighbors) && !p.LeaderFound {
+				p.phase++
+				p.startPhase(send)
+			}
+		}
+	}
+}
+
+// HS on a ring of GraphSize two-way links.
+type HSScenario struct {
+	RingSize int
+}
+
+func (s HSScenario) Network() Topology {
+	return RingTopology(s.RingSize, func(id ProcessID) Process {
+		return &HSProcess{ID: id}
+	})
+}
+
+func (s HSScenario) Check(c Cluster) error {
+	return leadersAgree(c)
+}
+
+func (s HSScenario) Invariants() []Invariant {
+	return []Invariant{{Name: "one leader", Check: leadersConsistent}}
+}
+
+func init() {
+	RegisterScenario("hs", "Hirschberg-Sinclair leader election on a two-way ring of GraphSize", func(params ScenarioParams) Scenario {
+		return HSScenario{RingSize: params.GraphSize}
+	})
+}
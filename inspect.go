@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Looks inside processes through reflection, so that algorithms need
+// no extra code to be model checked.
+
+// Hashes everything v holds, including unexported fields, following
+// pointers and ignoring map order, so two processes in the same state
+// hash the same however they got there. Funcs and channels are skipped.
+func stateHash(v interface{}) uint64 {
+	h := newStateHasher(make(map[uintptr]bool))
+	h.write(reflect.ValueOf(v))
+	return h.sum
+}
+
+// Mixes in a word at a time with a multiply and a shift,
+// as model checking hashes a great many small values.
+const (
+	hashSeed = 14695981039346656037
+	hashMultiplier = 0x9e3779b97f4a7c15
+)
+
+type stateHasher struct {
+	sum uint64
+	// Pointers being hashed, to stop at cycles. Pointers shared
+	// outside of a cycle are hashed in full each time they are met.
+	onPath map[uintptr]bool
+}
+
+func newStateHasher(onPath map[uintptr]bool) *stateHasher {
+	return &stateHasher{sum: hashSeed, onPath: onPath}
+}
+
+func (h *stateHasher) uint(u uint64) {
+	h.sum = (h.sum ^ u) * hashMultiplier
+	h.sum ^= h.sum >> 31
+}
+
+func (h *stateHasher) string(s string) {
+	h.uint(uint64(len(s)))
+	for i := 0; i < len(s); i += 8 {
+		var word uint64
+		for j := i; j < i+8 && j < len(s); j++ {
+			word = word<<8 | uint64(s[j])
+		}
+		h.uint(word)
+	}
+}
+
+func (h *stateHasher) write(v reflect.Value) {
+	if !v.IsValid() {
+		h.uint(0)
+		return
+	}
+	h.uint(uint64(v.Kind()))
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			h.uint(1)
+		} else {
+			h.uint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		h.uint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		h.uint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		h.uint(math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		h.uint(math.Float64bits(real(v.Complex())))
+		h.uint(math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		h.string(v.String())
+	case reflect.Ptr:
+		if v.IsNil() {
+			h.uint(0)
+			return
+		}
+		if h.onPath[v.Pointer()] {
+			h.uint(1)
+			return
+		}
+		h.onPath[v.Pointer()] = true
+		h.uint(2)
+		h.write(v.Elem())
+		delete(h.onPath, v.Pointer())
+	case reflect.Interface:
+		if v.IsNil() {
+			h.uint(0)
+			return
+		}
+		h.string(v.Elem().Type().String())
+		h.write(v.Elem())
+	case reflect.Struct:
+		h.string(v.Type().String())
+		for i := 0; i < v.NumField(); i++ {
+			h.write(v.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		h.uint(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			h.write(v.Index(i))
+		}
+	case reflect.Map:
+		// Entries are hashed on their own and combined in sorted order.
+		entries := make([]uint64, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			entry := newStateHasher(h.onPath)
+			entry.write(key)
+			entry.write(v.MapIndex(key))
+			entries = append(entries, entry.sum)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+		h.uint(uint64(len(entries)))
+		for _, entry := range entries {
+			h.uint(entry)
+		}
+	}
+}
+
+// Prints everything v holds, one field to a line, including unexported
+// fields. Short values and short lists of them stay on one line.
+func prettyPrint(v interface{}) string {
+	p := &prettyPrinter{onPath: make(map[uintptr]bool)}
+	return p.format(reflect.ValueOf(v), 0)
+}
+
+const (
+	prettyIndent = "  "
+	prettyLineWidth = 72
+	prettyMaxDepth = 12
+)
+
+type prettyPrinter struct {
+	onPath map[uintptr]bool
+}
+
+// Uses the value's String method if it has one.
+func (p *prettyPrinter) stringer(v reflect.Value) (string, bool) {
+	if !v.CanInterface() {
+		// Unexported, so copy it out first if it's a simple value.
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			c := reflect.New(v.Type()).Elem()
+			c.SetInt(v.Int())
+			v = c
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			c := reflect.New(v.Type()).Elem()
+			c.SetUint(v.Uint())
+			v = c
+		case reflect.String:
+			c := reflect.New(v.Type()).Elem()
+			c.SetString(v.String())
+			v = c
+		default:
+			return "", false
+		}
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String(), true
+	}
+	return "", false
+}
+
+// Orders numbers as numbers and anything else by how it prints.
+func lessKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func (p *prettyPrinter) format(v reflect.Value, depth int) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		if s, ok := p.stringer(v); ok {
+			return s
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Func:
+		return "func"
+	case reflect.Chan:
+		return fmt.Sprintf("chan (%d queued)", v.Len())
+	case reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+		return p.format(v.Elem(), depth)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "nil"
+		}
+		if p.onPath[v.Pointer()] {
+			return "<cycle>"
+		}
+		p.onPath[v.Pointer()] = true
+		defer delete(p.onPath, v.Pointer())
+		return "&" + p.format(v.Elem(), depth)
+	}
+	if depth >= prettyMaxDepth {
+		return "..."
+	}
+	var open, close string
+	var items []string
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type().PkgPath() == "sync" {
+			return v.Type().String()
+		}
+		open, close = v.Type().Name()+"{", "}"
+		for i := 0; i < v.NumField(); i++ {
+			items = append(items, v.Type().Field(i).Name+": "+p.format(v.Field(i), depth+1))
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "nil"
+		}
+		open, close = "[", "]"
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, p.format(v.Index(i), depth+1))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return "nil"
+		}
+		open, close = "{", "}"
+		// Sets show their members only.
+		isSet := v.Type().Elem().Kind() == reflect.Struct && v.Type().Elem().NumField() == 0
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+		for _, key := range keys {
+			item := p.format(key, depth+1)
+			if !isSet {
+				item += ": " + p.format(v.MapIndex(key), depth+1)
+			}
+			items = append(items, item)
+		}
+	}
+	oneLine := open + strings.Join(items, ", ") + close
+	if len(oneLine) <= prettyLineWidth && !strings.Contains(oneLine, "\n") {
+		return oneLine
+	}
+	indent := strings.Repeat(prettyIndent, depth+1)
+	var b strings.Builder
+	b.WriteString(open + "\n")
+	for _, item := range items {
+		b.WriteString(indent + item + "\n")
+	}
+	b.WriteString(strings.Repeat(prettyIndent, depth) + close)
+	return b.String()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A key-value store replicated from a primary to backups, whose clients
+// record their operations in a History for CheckLinearizable. The primary
+// applies writes and sends the new values on to the backups without
+// waiting for them, so reads from a backup can miss a write that has
+// already returned.
+
+type KVRequest struct {
+	Seq int
+	Input KVInput
+}
+
+func (m KVRequest) String() string {
+	return fmt.Sprintf("REQUEST(%d, %s)", m.Seq, m.Input)
+}
+
+func (m KVRequest) MessageTag() string {
+	return "kv-request"
+}
+
+func (m KVRequest) Encode(e *Encoder) {
+	e.WriteInt(m.Seq)
+	e.WriteInt(int(m.Input.Op))
+	e.WriteString(m.Input.Key)
+	e.WriteString(m.Input.Value)
+}
+
+type KVResponse struct {
+	Seq int
+	// The value got, or after a write the new value.
+	Value string
+}
+
+func (m KVResponse) String() string {
+	return fmt.Sprintf("RESPONSE(%d, %q)", m.Seq, m.Value)
+}
+
+func (m KVResponse) MessageTag() string {
+	return "kv-response"
+}
+
+func (m KVResponse) Encode(e *Encoder) {
+	e.WriteInt(m.Seq)
+	e.WriteString(m.Value)
+}
+
+type KVReplicate struct {
+	Key string
+	Value string
+}
+
+func (m KVReplicate) String() string {
+	return fmt.Sprintf("REPLICATE(%s=%q)", m.Key, m.Value)
+}
+
+func (m KVReplicate) MessageTag() string {
+	return "kv-replicate"
+}
+
+func (m KVReplicate) Encode(e *Encoder) {
+	e.WriteString(m.Key)
+	e.WriteString(m.Value)
+}
+
+func init() {
+	RegisterMessageType("kv-request", func(d *Decoder) Message {
+		m := KVRequest{}
+		m.Seq = d.ReadInt()
+		m.Input.Op = KVOperation(d.ReadInt())
+		m.Input.Key = d.ReadString()
+		m.Input.Value = d.ReadString()
+		return m
+	})
+	RegisterMessageType("kv-response", func(d *Decoder) Message {
+		m := KVResponse{}
+		m.Seq = d.ReadInt()
+		m.Value = d.ReadString()
+		return m
+	})
+	RegisterMessageType("kv-replicate", func(d *Decoder) Message {
+		m := KVReplicate{}
+		m.Key = d.ReadString()
+		m.Value = d.ReadString()
+		return m
+	})
+}
+
+// The primary if it has Backups. Backups serve gets from their copy.
+type KVServer struct {
+	ID ProcessID
+	Backups []ProcessID
+	Store map[string]string
+}
+
+func (p *KVServer) Id() ProcessID {
+	return p.ID
+}
+
+func (p *KVServer) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	if p.Store == nil {
+		p.Store = make(map[string]string)
+	}
+	for received := receive(); received != nil; received = receive() {
+		switch m := received.Message.(type) {
+		case KVRequest:
+			in := m.Input
+			switch in.Op {
+			case KVPut:
+				p.Store[in.Key] = in.Value
+			case KVAppend:
+				p.Store[in.Key] += in.Value
+			}
+			if in.Op != KVGet {
+				for _, backup := range p.Backups {
+					send(RoutedMessage{Message: KVReplicate{Key: in.Key, Value: p.Store[in.Key]}, From: p.ID, To: backup})
+				}
+			}
+			send(RoutedMessage{Message: KVResponse{Seq: m.Seq, Value: p.Store[in.Key]}, From: p.ID, To: received.From})
+		case KVReplicate:
+			p.Store[m.Key] = m.Value
+		default:
+			panic(fmt.Sprintf("kv server unexpected message type %T %s", received.Message, received.Message))
+		}
+	}
+}
+
+// Makes Ops one at a time, writing to Primary and reading from ReadFrom.
+type KVClient struct {
+	ID ProcessID
+	Primary ProcessID
+	ReadFrom ProcessID
+	Ops []KVInput
+	History *History
+	next int
+	waiting bool
+	seq int
+	// The History id of the operation waited on.
+	call int
+}
+
+func (p *KVClient) Id() ProcessID {
+	return p.ID
+}
+
+func (p *KVClient) Done() bool {
+	return p.next == len(p.Ops) && !p.waiting
+}
+
+func (p *KVClient) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(KVResponse)
+		if !ok {
+			panic(fmt.Sprintf("kv client unexpected message type %T %s", received.Message, received.Message))
+		}
+		if !p.waiting || m.Seq != p.seq {
+			continue
+		}
+		p.waiting = false
+		var output interface{}
+		if p.Ops[p.next-1].Op == KVGet {
+			output = m.Value
+		}
+		p.History.Return(p.call, output)
+	}
+	if p.waiting || p.next == len(p.Ops) {
+		return
+	}
+	in := p.Ops[p.next]
+	p.next++
+	p.seq++
+	p.waiting = true
+	p.call = p.History.Invoke(p.ID, in)
+	to := p.Primary
+	if in.Op == KVGet {
+		to = p.ReadFrom
+	}
+	send(RoutedMessage{Message: KVRequest{Seq: p.seq, Input: in}, From: p.ID, To: to})
+}
+
+// Whether the operations of the cluster's KV clients are linearizable.
+func kvLinearizable(c Cluster) error {
+	for _, p := range clusterLayers(c) {
+		if client, ok := p.(*KVClient); ok {
+			return CheckLinearizable(KVModel, client.History.Operations())
+		}
+	}
+	return nil
+}
+
+// Whether every KV client has made all of its operations.
+func kvClientsDone(c Cluster) error {
+	for _, p := range clusterLayers(c) {
+		if client, ok := p.(*KVClient); ok && !client.Done() {
+			return fmt.Errorf("%s made %d of %d operations", client.ID, client.next, len(client.Ops))
+		}
+	}
+	return nil
+}
+
+// A primary, 0, with backups 1 and 2, and clients 3 and 4, all linked.
+// With ReadFromBackups each client reads from its own backup.
+type KVScenario struct {
+	ReadFromBackups bool
+}
+
+func (s KVScenario) Network() Topology {
+	history := NewHistory()
+	clients := []*KVClient{
+		{ID: 3, Ops: []KVInput{
+			{Op: KVPut, Key: "x", Value: "1"},
+			{Op: KVGet, Key: "x"},
+			{Op: KVAppend, Key: "x", Value: "2"},
+			{Op: KVGet, Key: "x"},
+		}},
+		{ID: 4, Ops: []KVInput{
+			{Op: KVGet, Key: "x"},
+			{Op: KVPut, Key: "y", Value: "a"},
+			{Op: KVGet, Key: "y"},
+			{Op: KVGet, Key: "x"},
+		}},
+	}
+	processes := []Process{
+		&KVServer{ID: 0, Backups: []ProcessID{1, 2}},
+		&KVServer{ID: 1},
+		&KVServer{ID: 2},
+	}
+	for i, client := range clients {
+		client.History = history
+		client.ReadFrom = 0
+		if s.ReadFromBackups {
+			client.ReadFrom = ProcessID(i + 1)
+		}
+		processes = append(processes, client)
+	}
+	return CompleteTopology(processes)
+}
+
+func (s KVScenario) Invariants() []Invariant {
+	return []Invariant{{Name: "linearizable", Check: kvLinearizable}}
+}
+
+func (s KVScenario) Check(c Cluster) error {
+	if err := kvClientsDone(c); err != nil {
+		return err
+	}
+	return kvLinearizable(c)
+}
+
+func init() {
+	RegisterScenario("kv-primary", "a primary-backup key-value store whose clients read from the primary", func(ScenarioParams) Scenario {
+		return KVScenario{}
+	})
+	RegisterScenario("kv-backup-reads", "a primary-backup key-value store whose clients read stale values from backups", func(ScenarioParams) Scenario {
+		return KVScenario{ReadFromBackups: true}
+	})
+}
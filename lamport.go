@@ -18,6 +18,24 @@ func (m LamportMessage) String() string {
 	return fmt.Sprintf("%s (%d)", m.Message, m.Clock)
 }
 
+func (m LamportMessage) MessageTag() string {
+	return "lamport"
+}
+
+func (m LamportMessage) Encode(e *Encoder) {
+	e.WriteInt(m.Clock)
+	e.WriteMessage(m.Message)
+}
+
+func init() {
+	RegisterMessageType("lamport", func(d *Decoder) Message {
+		m := LamportMessage{}
+		m.Clock = d.ReadInt()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
+
 func (p *LamportProcess) Step(
 	send func(RoutedMessage),
 	receive func() *RoutedMessage,
@@ -72,3 +90,8 @@ func (s RandomWithLamportScenario) Network() Topology {
 	return CompleteTopology(processes)
 }
 
+func init() {
+	RegisterScenario("random-lamport", "NumProcs random processes with Lamport clocks", func(params ScenarioParams) Scenario {
+		return RandomWithLamportScenario{NumProcs: params.NumProcs}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+)
+
+// LeLann-Chang-Roberts leader election on a unidirectional ring.
+// Each process sends its id to the one neighbor it can send to,
+// and passes on only ids larger than its own, so just the largest id
+// makes it all the way around. Its owner then announces itself
+// around the ring.
+type LCRProcess struct {
+	ID ProcessID
+	LeaderId ProcessID
+	LeaderFound bool
+	next ProcessID
+	hasStarted bool
+}
+
+type LCRMessage struct {
+	UID ProcessID
+	// Set once UID has won, to announce it.
+	Elected bool
+}
+
+func (m LCRMessage) String() string {
+	if m.Elected {
+		return fmt.Sprintf("ELECTED(%s)", m.UID)
+	}
+	return fmt.Sprintf("UID(%s)", m.UID)
+}
+
+func (m LCRMessage) MessageTag() string {
+	return "lcr"
+}
+
+func (m LCRMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.UID))
+	elected := 0
+	if m.Elected {
+		elected = 1
+	}
+	e.WriteInt(elected)
+}
+
+func init() {
+	RegisterMessageType("lcr", func(d *Decoder) Message {
+		m := LCRMessage{}
+		m.UID = ProcessID(d.ReadInt())
+		m.Elected = d.ReadInt() != 0
+		return m
+	})
+}
+
+func (p *LCRProcess) Id() ProcessID {
+	return p.ID
+}
+
+func (p *LCRProcess) SetNeighbors(in map[ProcessID]struct{}, out map[ProcessID]struct{}) {
+	if len(out) != 1 {
+		panic(fmt.Sprintf("LCR needs exactly one neighbor to send to, %s has %d", p.ID, len(out)))
+	}
+	for next := range out {
+		p.next = next
+	}
+}
+
+func (p *LCRProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	pass := func(m LCRMessage) {
+		send(RoutedMessage{Message: m, From: p.ID, To: p.next})
+	}
+	if !p.hasStarted {
+		pass(LCRMessage{UID: p.ID})
+		p.hasStarted = true
+	}
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(LCRMessage)
+		if !ok {
+			panic(fmt.Sprintf("LCR unexpected message type %T %s", received.Message, received.Message))
+		}
+		switch {
+		case m.Elected:
+			if m.UID == p.ID {
+				// The announcement made it around.
+				continue
+			}
+			p.LeaderId = m.UID
+			p.LeaderFound = true
+			Log(p, fmt.Sprintf("found leader %s", p.LeaderId))
+			pass(m)
+		case m.UID > p.ID:
+			pass(m)
+		case m.UID == p.ID:
+			p.LeaderId = p.ID
+			p.LeaderFound = true
+			Log(p, "elected leader")
+			pass(LCRMessage{UID: p.ID, Elected: true})
+		}
+	}
+}
+
+// LCR on a ring of one-way links.
+type LCRScenario struct {
+	RingSize int
+}
+
+func (s LCRScenario) Network() Topology {
+	return DirectedRingTopology(s.RingSize, func(id ProcessID) Process {
+		return &LCRProcess{ID: id}
+	})
+}
+
+func (s LCRScenario) Check(c Cluster) error {
+	return leadersAgree(c)
+}
+
+func (s LCRScenario) Invariants() []Invariant {
+	return []Invariant{{Name: "one leader", Check: leadersConsistent}}
+}
+
+func init() {
+	RegisterScenario("lcr", "LCR leader election on a one-way ring of GraphSize", func(params ScenarioParams) Scenario {
+		return LCRScenario{RingSize: params.GraphSize}
+	})
+}
@@ -24,23 +24,41 @@ func (m LeaderMessage) String() string {
 	return fmt.Sprintf("Message: %s | IdList: %v", m.Message, m.IdList)
 }
 
+func (m LeaderMessage) MessageTag() string {
+	return "leader"
+}
+
+func (m LeaderMessage) Encode(e *Encoder) {
+	e.WriteProcessIDs(m.IdList)
+	e.WriteMessage(m.Message)
+}
+
+func init() {
+	RegisterMessageType("leader", func(d *Decoder) Message {
+		m := LeaderMessage{}
+		m.IdList = d.ReadProcessIDs()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
+
 func (p *LeaderElectionProcess) sendListToNeighbors(send func(RoutedMessage)) {
-	for neighbor := range p.Neighbors {
+	for _, neighbor := range sortedSet(p.Neighbors) {
 		if neighbor == p.Id() { continue }
 		send(RoutedMessage{
-			Message: LeaderMessage{IdList: p.IdList},
+			// Each neighbor gets its own copy, since they read it concurrently.
+			Message: LeaderMessage{IdList: copyIDs(p.IdList)},
 			From: p.Id(),
 			To: neighbor,
 		})
 	}
 }
 
-func (p *LeaderElectionProcess) receiveLeaderMessage(receive func() *RoutedMessage) bool {
-	received := receive()
-	if received == nil {
-		return false
+func (p *LeaderElectionProcess) receiveLeaderMessage(received *RoutedMessage) bool {
+	leaderMessage, ok := received.Message.(LeaderMessage)
+	if !ok {
+		panic(fmt.Sprintf("leader election unexpected message type %T %s", received.Message, received.Message))
 	}
-	leaderMessage := received.Message.(LeaderMessage)
 	// update state
 	originalIDListLen := len(p.IdList)
 	for id := range leaderMessage.IdList {
@@ -49,27 +67,31 @@ func (p *LeaderElectionProcess) receiveLeaderMessage(receive func() *RoutedMessa
 	return len(p.IdList) != originalIDListLen
 }
 
+// Handles whatever has arrived and returns, so the election
+// never holds up the rest of the cluster.
 func (p *LeaderElectionProcess) Step(send func(RoutedMessage), receive func() *RoutedMessage) {
-
 	if p.LeaderFound {
 		return
 	}
-	// init 
-	p.LeaderId = p.Id()
-	p.IdList = make(map[ProcessID] struct{})
-	p.IdList[p.LeaderId] = struct{}{}
-
-	// main loop
-	p.sendListToNeighbors(send)
-	for {
-		updated := p.receiveLeaderMessage(receive)
-		if updated {
-			p.sendListToNeighbors(send)
-			if len(p.IdList) == p.GraphSize	{
-				p.LeaderFound = true
-				break
-			}
+	if p.IdList == nil {
+		// init 
+		p.LeaderId = p.Id()
+		p.IdList = make(map[ProcessID] struct{})
+		p.IdList[p.LeaderId] = struct{}{}
+		p.sendListToNeighbors(send)
+	}
+	for received := receive(); received != nil; received = receive() {
+		if !p.receiveLeaderMessage(received) {
+			continue
 		}
+		p.sendListToNeighbors(send)
+		if len(p.IdList) == p.GraphSize	{
+			p.LeaderFound = true
+			break
+		}
+	}
+	if !p.LeaderFound {
+		return
 	}
 	for id := range p.IdList {
 		if id > p.LeaderId {
@@ -102,3 +124,16 @@ func (s LeaderElectionCompleteScenario) Network() Topology {
 	return CompleteTopology(processes)
 }
 
+func (s LeaderElectionCompleteScenario) Check(c Cluster) error {
+	return leadersAgree(c)
+}
+
+func (s LeaderElectionCompleteScenario) Invariants() []Invariant {
+	return []Invariant{{Name: "one leader", Check: leadersConsistent}}
+}
+
+func init() {
+	RegisterScenario("leader-election", "leader election on a complete graph of GraphSize", func(params ScenarioParams) Scenario {
+		return LeaderElectionCompleteScenario{GraphSize: params.GraphSize}
+	})
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Checks that the operations clients made on a replicated object could
+// have happened one at a time, each at some instant between its call and
+// its return, on a single copy of the object: that the history is
+// linearizable. The search is Wing and Gong's, with the memoization
+// Lowe added and Porcupine uses, and histories are split by key first.
+
+// An operation a client made, with the logical times of its call and
+// return. Return is 0 while the operation is pending, as it is when the
+// client crashed or the run ended first; a pending operation may or may
+// not have taken effect.
+type Operation struct {
+	Client ProcessID
+	Input interface{}
+	Output interface{}
+	Call int64
+	Return int64
+}
+
+func (o Operation) pending() bool {
+	return o.Return == 0
+}
+
+// Records the operations of a run. Clients share one History, which
+// numbers their calls and returns in the order they happen.
+type History struct {
+	mutex sync.Mutex
+	clock int64
+	operations []Operation
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+// Records a call, returning the id to pass to Return.
+func (h *History) Invoke(client ProcessID, input interface{}) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.clock++
+	h.operations = append(h.operations, Operation{Client: client, Input: input, Call: h.clock})
+	return len(h.operations) - 1
+}
+
+func (h *History) Return(id int, output interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.clock++
+	h.operations[id].Output = output
+	h.operations[id].Return = h.clock
+}
+
+func (h *History) Operations() []Operation {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Operation{}, h.operations...)
+}
+
+// A sequential specification of an object.
+type Model struct {
+	Init func() interface{}
+	// Whether the operation could give output in state, and the state
+	// after it. Output is nil for a pending operation, which could have
+	// given anything.
+	Step func(state, input, output interface{}) (bool, interface{})
+	// Splits a history into ones that can be checked on their own,
+	// such as one per key. Optional.
+	Partition func(operations []Operation) [][]Operation
+	DescribeOperation func(input, output interface{}) string
+}
+
+type RegisterInput struct {
+	Write bool
+	Value string
+}
+
+// A register holding a string, initially empty, that is read and written.
+// A read's output is the value read.
+var RegisterModel = Model{
+	Init: func() interface{} { return "" },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		in := input.(RegisterInput)
+		if in.Write {
+			return true, in.Value
+		}
+		return output == nil || output == state, state
+	},
+	DescribeOperation: func(input, output interface{}) string {
+		in := input.(RegisterInput)
+		if in.Write {
+			return fmt.Sprintf("write %q", in.Value)
+		}
+		return fmt.Sprintf("read -> %s", describeOutput(output))
+	},
+}
+
+type KVOperation int
+
+const (
+	KVGet KVOperation = iota
+	KVPut
+	KVAppend
+)
+
+type KVInput struct {
+	Op KVOperation
+	Key string
+	Value string
+}
+
+func (in KVInput) String() string {
+	switch in.Op {
+	case KVPut:
+		return fmt.Sprintf("put %s=%q", in.Key, in.Value)
+	case KVAppend:
+		return fmt.Sprintf("append %s+=%q", in.Key, in.Value)
+	}
+	return fmt.Sprintf("get %s", in.Key)
+}
+
+// A map of strings, whose keys start empty. Gets output the value;
+// puts set it and appends add to it. Each key is checked on its own.
+var KVModel = Model{
+	Init: func() interface{} { return "" },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		in := input.(KVInput)
+		switch in.Op {
+		case KVPut:
+			return true, in.Value
+		case KVAppend:
+			return true, state.(string) + in.Value
+		}
+		return output == nil || output == state, state
+	},
+	Partition: func(operations []Operation) [][]Operation {
+		byKey := make(map[string][]Operation)
+		var keys []string
+		for _, o := range operations {
+			key := o.Input.(KVInput).Key
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+			}
+			byKey[key] = append(byKey[key], o)
+		}
+		sort.Strings(keys)
+		partitions := make([][]Operation, len(keys))
+		for i, key := range keys {
+			partitions[i] = byKey[key]
+		}
+		return partitions
+	},
+	DescribeOperation: func(input, output interface{}) string {
+		in := input.(KVInput)
+		if in.Op == KVGet {
+			return fmt.Sprintf("%s -> %s", in, describeOutput(output))
+		}
+		return in.String()
+	},
+}
+
+func describeOutput(output interface{}) string {
+	if output == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%q", output)
+}
+
+// Returned when a history is not linearizable. Linearized is the longest
+// order found for the operations before the search got stuck, and Stuck
+// the operation that could not come next, as it had returned. Fragment
+// holds the operations around it, and Error draws them.
+type LinearizabilityError struct {
+	Model Model
+	Linearized []Operation
+	Stuck Operation
+	Fragment []Operation
+}
+
+func (e *LinearizabilityError) describe(o Operation) string {
+	return e.Model.DescribeOperation(o.Input, o.Output)
+}
+
+func (e *LinearizabilityError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "not linearizable: %s by %s cannot be ordered\n", e.describe(e.Stuck), e.Stuck.Client)
+	b.WriteString(visualizeOperations(e.Fragment, e.describe))
+	var order []string
+	for _, o := range e.Linearized {
+		order = append(order, e.describe(o))
+	}
+	if len(order) == 0 {
+		order = append(order, "nothing")
+	}
+	fmt.Fprintf(&b, "longest order found: %s", strings.Join(order, ", "))
+	return b.String()
+}
+
+// Draws each operation as a bar from its call to its return, one row
+// per client, on a time axis that keeps only the order of events.
+// Pending operations run off the end.
+func visualizeOperations(operations []Operation, describe func(Operation) string) string {
+	var times []int64
+	seen := make(map[int64]bool)
+	var clients []ProcessID
+	byClient := make(map[ProcessID][]Operation)
+	for _, o := range operations {
+		for _, t := range []int64{o.Call, o.Return} {
+			if t != 0 && !seen[t] {
+				seen[t] = true
+				times = append(times, t)
+			}
+		}
+		if _, ok := byClient[o.Client]; !ok {
+			clients = append(clients, o.Client)
+		}
+		byClient[o.Client] = append(byClient[o.Client], o)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	sortProcessIDs(clients)
+	column := make(map[int64]int, len(times))
+	for i, t := range times {
+		column[t] = i
+	}
+	end := len(times)
+	span := func(o Operation) (int, int) {
+		if o.pending() {
+			return column[o.Call], end
+		}
+		if column[o.Return] <= column[o.Call] {
+			return column[o.Call], column[o.Call] + 1
+		}
+		return column[o.Call], column[o.Return]
+	}
+	// Wide enough for every label to fit in its bar.
+	width := 2
+	for _, o := range operations {
+		from, to := span(o)
+		if w := (len(describe(o)) + 3 + to - from - 1) / (to - from); w > width {
+			width = w
+		}
+	}
+	if len(times) > 0 {
+		if w := len(fmt.Sprint(times[len(times)-1])) + 1; w > width {
+			width = w
+		}
+	}
+	var b strings.Builder
+	axis := fmt.Sprintf("%-10s", "")
+	for _, t := range times {
+		axis += fmt.Sprintf("%-*d", width, t)
+	}
+	b.WriteString(strings.TrimRight(axis, " ") + "\n")
+	for _, client := range clients {
+		row := []byte(strings.Repeat(" ", (end+1)*width))
+		for _, o := range byClient[client] {
+			from, to := span(o)
+			start, stop := from*width, to*width
+			row[start] = '|'
+			for i := start + 1; i < stop; i++ {
+				row[i] = '-'
+			}
+			if o.pending() {
+				row[stop] = '>'
+			} else {
+				row[stop] = '|'
+			}
+			copy(row[start+2:stop-1], describe(o))
+		}
+		fmt.Fprintf(&b, "%-10s%s\n", client, strings.TrimRight(string(row), " "))
+	}
+	return b.String()
+}
+
+// Checks whether operations are linearizable under model,
+// returning a *LinearizabilityError if not.
+func CheckLinearizable(model Model, operations []Operation) error {
+	partitions := [][]Operation{operations}
+	if model.Partition != nil {
+		partitions = model.Partition(operations)
+	}
+	for _, partition := range partitions {
+		if err := checkPartition(model, partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An event in the list the search works through: a call, which links
+// to its return, or a return.
+type linearizationEvent struct {
+	operation int
+	call bool
+	match *linearizationEvent
+	prev, next *linearizationEvent
+}
+
+func (e *linearizationEvent) time(operations []Operation) int64 {
+	o := operations[e.operation]
+	if e.call {
+		return o.Call
+	}
+	if o.pending() {
+		// After every other event.
+		return 1<<63 - 1
+	}
+	return o.Return
+}
+
+// Takes a call and its return out of the list.
+func (e *linearizationEvent) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	r := e.match
+	r.prev.next = r.next
+	if r.next != nil {
+		r.next.prev = r.prev
+	}
+}
+
+// Puts back what lift took out.
+func (e *linearizationEvent) unlift() {
+	r := e.match
+	r.prev.next = r
+	if r.next != nil {
+		r.next.prev = r
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+func checkPartition(model Model, operations []Operation) error {
+	events := make([]*linearizationEvent, 0, 2*len(operations))
+	for i := range operations {
+		call := &linearizationEvent{operation: i, call: true}
+		ret := &linearizationEvent{operation: i}
+		call.match = ret
+		events = append(events, call, ret)
+	}
+	// Calls before returns at the same time, so the operations overlap.
+	sort.SliceStable(events, func(i, j int) bool {
+		ti, tj := events[i].time(operations), events[j].time(operations)
+		if ti != tj {
+			return ti < tj
+		}
+		return events[i].call && !events[j].call
+	})
+	head := &linearizationEvent{}
+	prev := head
+	for _, e := range events {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+	type linearized struct {
+		call *linearizationEvent
+		state interface{}
+	}
+	var stack []linearized
+	var longest []int
+	state := model.Init()
+	done := make([]bool, len(operations))
+	// The sets of operations already tried with each state they led to.
+	type cacheKey struct {
+		done string
+		state uint64
+	}
+	cache := make(map[cacheKey]bool)
+	key := func(state interface{}) cacheKey {
+		b := make([]byte, len(done))
+		for i, d := range done {
+			if d {
+				b[i] = 1
+			}
+		}
+		return cacheKey{string(b), stateHash(state)}
+	}
+	e := head.next
+	for e != nil {
+		o := operations[e.operation]
+		if e.call {
+			if ok, next := model.Step(state, o.Input, o.Output); ok {
+				done[e.operation] = true
+				k := key(next)
+				if !cache[k] {
+					cache[k] = true
+					stack = append(stack, linearized{e, state})
+					state = next
+					if len(stack) > len(longest) {
+						longest = longest[:0]
+						for _, l := range stack {
+							longest = append(longest, l.call.operation)
+						}
+					}
+					e.lift()
+					e = head.next
+					continue
+				}
+				done[e.operation] = false
+			}
+			e = e.next
+			continue
+		}
+		if o.pending() {
+			// Every operation that returned is in order.
+			return nil
+		}
+		if len(stack) == 0 {
+			return linearizabilityError(model, operations, longest)
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		done[top.call.operation] = false
+		top.call.unlift()
+		e = top.call.next
+	}
+	return nil
+}
+
+func linearizabilityError(model Model, operations []Operation, longest []int) error {
+	err := &LinearizabilityError{Model: model}
+	inOrder := make(map[int]bool, len(longest))
+	for _, i := range longest {
+		inOrder[i] = true
+		err.Linearized = append(err.Linearized, operations[i])
+	}
+	// The first operation to return that is not in the order.
+	stuck := -1
+	for i, o := range operations {
+		if !inOrder[i] && !o.pending() && (stuck < 0 || o.Return < operations[stuck].Return) {
+			stuck = i
+		}
+	}
+	err.Stuck = operations[stuck]
+	// The operations from the first call not in the order
+	// to the stuck return.
+	from := err.Stuck.Call
+	for i, o := range operations {
+		if !inOrder[i] && o.Call < from {
+			from = o.Call
+		}
+	}
+	// And the last operation in the order, which set the state.
+	last := -1
+	if len(longest) > 0 {
+		last = longest[len(longest)-1]
+	}
+	for i, o := range operations {
+		if i == last || o.Call <= err.Stuck.Return && (o.pending() || o.Return >= from) {
+			err.Fragment = append(err.Fragment, o)
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func write(client ProcessID, value string, call, ret int64) Operation {
+	return Operation{Client: client, Input: RegisterInput{Write: true, Value: value}, Call: call, Return: ret}
+}
+
+func read(client ProcessID, value string, call, ret int64) Operation {
+	return Operation{Client: client, Input: RegisterInput{}, Output: value, Call: call, Return: ret}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	tests := []struct {
+		name string
+		operations []Operation
+		ok bool
+	}{
+		{"empty", nil, true},
+		{"sequential", []Operation{write(1, "a", 1, 2), read(2, "a", 3, 4)}, true},
+		{"stale read", []Operation{write(1, "a", 1, 2), read(2, "", 3, 4)}, false},
+		{"concurrent read sees old value", []Operation{write(1, "a", 1, 4), read(2, "", 2, 3)}, true},
+		{"concurrent read sees new value", []Operation{write(1, "a", 1, 4), read(2, "a", 2, 3)}, true},
+		{"reads disagree on order", []Operation{
+			write(1, "a", 1, 10),
+			write(2, "b", 2, 10),
+			read(3, "a", 3, 4),
+			read(3, "b", 5, 6),
+			read(4, "b", 3, 4),
+			read(4, "a", 5, 6),
+		}, false},
+		{"pending write seen", []Operation{write(1, "a", 1, 0), read(2, "a", 2, 3)}, true},
+		{"pending write not seen", []Operation{write(1, "a", 1, 0), read(2, "", 2, 3)}, true},
+		{"pending write seen then not", []Operation{write(1, "a", 1, 0), read(2, "a", 2, 3), read(2, "", 4, 5)}, false},
+	}
+	for _, test := range tests {
+		err := CheckLinearizable(RegisterModel, test.operations)
+		if test.ok && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: linearizable, but should not be", test.name)
+		}
+	}
+}
+
+func TestCheckLinearizableKV(t *testing.T) {
+	op := func(client ProcessID, in KVInput, output interface{}, call, ret int64) Operation {
+		return Operation{Client: client, Input: in, Output: output, Call: call, Return: ret}
+	}
+	operations := []Operation{
+		op(1, KVInput{Op: KVPut, Key: "x", Value: "1"}, nil, 1, 2),
+		op(2, KVInput{Op: KVAppend, Key: "y", Value: "a"}, nil, 3, 6),
+		op(1, KVInput{Op: KVGet, Key: "y"}, "a", 4, 5),
+		op(1, KVInput{Op: KVGet, Key: "x"}, "1", 7, 8),
+	}
+	if err := CheckLinearizable(KVModel, operations); err != nil {
+		t.Error(err)
+	}
+	operations = append(operations, op(2, KVInput{Op: KVGet, Key: "x"}, "", 9, 10))
+	err := CheckLinearizable(KVModel, operations)
+	if err == nil {
+		t.Fatal("stale get is linearizable")
+	}
+	lin := err.(*LinearizabilityError)
+	if lin.Stuck.Call != 9 || len(lin.Fragment) != 2 {
+		t.Errorf("wrong fragment:\n%s", err)
+	}
+	for _, want := range []string{`get x -> ""`, `put x="1"`, "pid:2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q missing from:\n%s", want, err)
+		}
+	}
+}
+
+func TestModelCheckFindsStaleRead(t *testing.T) {
+	result := ModelCheck(KVScenario{ReadFromBackups: true}, DefaultModelCheckOptions())
+	if result.Violation == nil || !strings.Contains(result.Violation.Error(), "not linearizable") {
+		t.Errorf("stale read not found: %s", result)
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An OSPF-style alternative to BellmanFordProcess.
+// Every node floods an advertisement of its own links to the whole network,
+// so each node learns the full graph and runs Dijkstra on it to pick next
+// hops. Inner messages are forwarded exactly as BellmanFordProcess does,
+// so the two can be swapped in the same scenario.
+type LinkStateProcess struct {
+	Process
+	neighbors map[ProcessID]struct{}
+	// Cost of the link to each neighbor; 1 if not listed.
+	weights map[ProcessID]int
+	// The newest advertisement from every node, including this one.
+	database map[ProcessID]linkStateEntry
+	nextHops map[ProcessID]ProcessID
+	distances map[ProcessID]int
+	seq int
+	hasStarted bool
+	deliveryQueue chan RoutedMessage
+	steps int
+	lastOriginated int
+	// Link changes to advertise at the next Step.
+	linksChanged []linkEvent
+	stats RoutingStats
+}
+
+// Times are counted in calls to Step.
+const (
+	// How often a node re-floods its own advertisement.
+	LinkStateRefreshInterval = 300
+	// Advertisements older than this are dropped from the database.
+	LinkStateMaxAge = 1000
+)
+
+type LinkStateAdvertisement struct {
+	Origin ProcessID
+	Seq int
+	// Steps since Origin created the advertisement,
+	// plus one for every hop it was flooded.
+	Age int
+	// Cost of the link to each of Origin's neighbors.
+	Links map[ProcessID]int
+}
+
+type linkStateEntry struct {
+	LinkStateAdvertisement
+	installedAt int
+}
+
+type LinkStateUpdateMessage struct {
+	LinkStateAdvertisement
+}
+
+func (m LinkStateUpdateMessage) String() string {
+	return fmt.Sprintf("LSA(%s seq %d age %d: %v)", m.Origin, m.Seq, m.Age, m.Links)
+}
+
+func (m LinkStateUpdateMessage) MessageTag() string {
+	return "link-state"
+}
+
+// Checksummed, as OSPF advertisements are, so corrupted ones are discarded.
+func (m LinkStateUpdateMessage) Encode(e *Encoder) {
+	e.Checksummed(func() {
+		e.WriteInt(int(m.Origin))
+		e.WriteInt(m.Seq)
+		e.WriteInt(m.Age)
+		neighbors := make([]int, 0, len(m.Links))
+		for neighbor := range m.Links {
+			neighbors = append(neighbors, int(neighbor))
+		}
+		sort.Ints(neighbors)
+		e.WriteInt(len(neighbors))
+		for _, neighbor := range neighbors {
+			e.WriteInt(neighbor)
+			e.WriteInt(m.Links[ProcessID(neighbor)])
+		}
+	})
+}
+
+func init() {
+	RegisterMessageType("link-state", func(d *Decoder) Message {
+		m := LinkStateUpdateMessage{}
+		m.Origin = ProcessID(d.ReadInt())
+		m.Seq = d.ReadInt()
+		m.Age = d.ReadInt()
+		n := d.ReadInt()
+		if n < 0 || n > len(d.buf) {
+			d.fail(fmt.Errorf("link count %d out of range", n))
+			return nil
+		}
+		m.Links = make(map[ProcessID]int, n)
+		for i := 0; i < n; i++ {
+			neighbor := ProcessID(d.ReadInt())
+			m.Links[neighbor] = d.ReadInt()
+		}
+		return m
+	})
+}
+
+func NewLinkStateTopologyNode(
+	subprocess Process,
+	neighbors map[ProcessID]struct{},
+) TopologyNode {
+	return TopologyNode{
+		Subprocess: newLinkStateProcess(&MultiTCPProcess{Process: subprocess}, neighbors, nil),
+		Neighbors: neighbors,
+	}
+}
+
+// Like NewLinkStateTopologyNode, but routes over least-cost paths
+// using each link's Weight, and delays messages by each link's Latency.
+func NewWeightedLinkStateTopologyNode(
+	subprocess Process,
+	links map[ProcessID]Link,
+) TopologyNode {
+	neighbors := make(map[ProcessID]struct{}, len(links))
+	weights := make(map[ProcessID]int, len(links))
+	for pid, link := range links {
+		neighbors[pid] = struct{}{}
+		if link.Weight > 0 {
+			weights[pid] = link.Weight
+		}
+	}
+	return TopologyNode{
+		Subprocess: newLinkStateProcess(&MultiTCPProcess{Process: subprocess}, neighbors, weights),
+		Neighbors: neighbors,
+		Links: links,
+	}
+}
+
+// Routes for inner, which is usually the transport layer.
+func newLinkStateProcess(
+	inner Process,
+	neighbors map[ProcessID]struct{},
+	weights map[ProcessID]int,
+) *LinkStateProcess {
+	ownNeighbors := make(map[ProcessID]struct{}, len(neighbors))
+	for neighbor := range neighbors {
+		ownNeighbors[neighbor] = struct{}{}
+	}
+	return &LinkStateProcess{
+		Process: inner,
+		neighbors: ownNeighbors,
+		weights: weights,
+		database: make(map[ProcessID]linkStateEntry),
+		nextHops: map[ProcessID]ProcessID{},
+		distances: map[ProcessID]int{inner.Id(): 0},
+		deliveryQueue: make(chan RoutedMessage, innerDeliveryQueueSize),
+	}
+}
+
+func (p *LinkStateProcess) cost(neighbor ProcessID) int {
+	if weight, ok := p.weights[neighbor]; ok {
+		return weight
+	}
+	return 1
+}
+
+// Total cost of the best known path to each reachable destination.
+func (p *LinkStateProcess) Distances() map[ProcessID]int {
+	distances := make(map[ProcessID]int, len(p.distances))
+	for dest, distance := range p.distances {
+		distances[dest] = distance
+	}
+	return distances
+}
+
+func (p *LinkStateProcess) RoutingStats() RoutingStats {
+	return p.stats
+}
+
+func (p *LinkStateProcess) nextHop(dest ProcessID) (ProcessID, bool) {
+	if dest == p.Id() {
+		return dest, true
+	}
+	nextHop, ok := p.nextHops[dest]
+	return nextHop, ok
+}
+
+func (p *LinkStateProcess) sendAdvertisement(
+	lsa LinkStateAdvertisement,
+	to ProcessID,
+	send func(RoutedMessage),
+) {
+	send(RoutedMessage{
+		Message: LinkStateUpdateMessage{LinkStateAdvertisement: lsa},
+		From: p.Id(),
+		To: to,
+	})
+	p.stats.ControlMessages += 1
+}
+
+// Send an advertisement to every neighbor except the one it came from.
+func (p *LinkStateProcess) flood(
+	lsa LinkStateAdvertisement,
+	from ProcessID,
+	send func(RoutedMessage),
+) {
+	for _, neighbor := range sortedSet(p.neighbors) {
+		if neighbor != from {
+			p.sendAdvertisement(lsa, neighbor, send)
+		}
+	}
+}
+
+// Advertise our current links with a new sequence number.
+func (p *LinkStateProcess) originate(send func(RoutedMessage)) {
+	links := make(map[ProcessID]int, len(p.neighbors))
+	for neighbor := range p.neighbors {
+		links[neighbor] = p.cost(neighbor)
+	}
+	p.seq += 1
+	lsa := LinkStateAdvertisement{
+		Origin: p.Id(),
+		Seq: p.seq,
+		Links: links,
+	}
+	p.database[p.Id()] = linkStateEntry{LinkStateAdvertisement: lsa, installedAt: p.steps}
+	p.lastOriginated = p.steps
+	p.flood(lsa, p.Id(), send)
+	p.computeRoutes()
+}
+
+func (p *LinkStateProcess) receiveAdvertisement(
+	lsa LinkStateAdvertisement,
+	from ProcessID,
+	send func(RoutedMessage),
+) {
+	if _, ok := p.neighbors[from]; !ok {
+		// Sent before the link went down.
+		return
+	}
+	if lsa.Origin == p.Id() {
+		if lsa.Seq > p.seq {
+			// Left over from an earlier life; supersede it.
+			p.seq = lsa.Seq
+			p.originate(send)
+		}
+		return
+	}
+	if known, ok := p.database[lsa.Origin]; ok && known.Seq >= lsa.Seq {
+		return
+	}
+	lsa.Age += 1
+	p.database[lsa.Origin] = linkStateEntry{LinkStateAdvertisement: lsa, installedAt: p.steps}
+	p.flood(lsa, from, send)
+	p.computeRoutes()
+}
+
+// Forget advertisements whose origin has stopped refreshing them.
+func (p *LinkStateProcess) expireAdvertisements() {
+	expired := false
+	for origin, entry := range p.database {
+		if origin != p.Id() && entry.Age+p.steps-entry.installedAt > LinkStateMaxAge {
+			delete(p.database, origin)
+			expired = true
+		}
+	}
+	if expired {
+		p.computeRoutes()
+	}
+}
+
+// Whether both ends of a link advertise it, so a half-dead link
+// or a stale advertisement can't attract traffic.
+// This also means OneWay links are never used.
+func (p *LinkStateProcess) linkCost(from ProcessID, to ProcessID) (int, bool) {
+	fromEntry, ok := p.database[from]
+	if !ok {
+		return 0, false
+	}
+	cost, ok := fromEntry.Links[to]
+	if !ok {
+		return 0, false
+	}
+	toEntry, ok := p.database[to]
+	if !ok {
+		return 0, false
+	}
+	if _, ok := toEntry.Links[from]; !ok {
+		return 0, false
+	}
+	return cost, true
+}
+
+// Dijkstra over the link-state database.
+func (p *LinkStateProcess) computeRoutes() {
+	distances := map[ProcessID]int{p.Id(): 0}
+	nextHops := make(map[ProcessID]ProcessID)
+	done := make(map[ProcessID]struct{}, len(p.database))
+	for {
+		closest, found := ProcessID(0), false
+		for id, distance := range distances {
+			if _, ok := done[id]; ok {
+				continue
+			}
+			if !found || distance < distances[closest] ||
+				(distance == distances[closest] && id < closest) {
+				closest, found = id, true
+			}
+		}
+		if !found {
+			break
+		}
+		done[closest] = struct{}{}
+		links := p.database[closest].Links
+		neighbors := make([]ProcessID, 0, len(links))
+		for neighbor := range links {
+			neighbors = append(neighbors, neighbor)
+		}
+		// Equal paths tie the same way every run.
+		sortProcessIDs(neighbors)
+		for _, neighbor := range neighbors {
+			cost, ok := p.linkCost(closest, neighbor)
+			if !ok {
+				continue
+			}
+			distance := distances[closest] + cost
+			if known, ok := distances[neighbor]; ok && known <= distance {
+				continue
+			}
+			distances[neighbor] = distance
+			if closest == p.Id() {
+				nextHops[neighbor] = neighbor
+			} else {
+				nextHops[neighbor] = nextHops[closest]
+			}
+		}
+	}
+	if !sameRoutes(p.distances, p.nextHops, distances, nextHops) {
+		p.stats.LastRouteChange = p.steps
+	}
+	p.distances = distances
+	p.nextHops = nextHops
+}
+
+func sameRoutes(
+	distances map[ProcessID]int,
+	nextHops map[ProcessID]ProcessID,
+	otherDistances map[ProcessID]int,
+	otherNextHops map[ProcessID]ProcessID,
+) bool {
+	if len(distances) != len(otherDistances) || len(nextHops) != len(otherNextHops) {
+		return false
+	}
+	for dest, distance := range distances {
+		if otherDistance, ok := otherDistances[dest]; !ok || otherDistance != distance {
+			return false
+		}
+	}
+	for dest, nextHop := range nextHops {
+		if otherNextHop, ok := otherNextHops[dest]; !ok || otherNextHop != nextHop {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *LinkStateProcess) LinkAdded(neighbor ProcessID, link Link) {
+	if link.Weight <= 0 {
+		delete(p.weights, neighbor)
+		return
+	}
+	if p.weights == nil {
+		p.weights = make(map[ProcessID]int)
+	}
+	p.weights[neighbor] = link.Weight
+}
+
+// Called by the link layer when the link to a neighbor fails or recovers.
+// Takes effect at the start of the next Step.
+func (p *LinkStateProcess) LinkChanged(neighbor ProcessID, up bool) {
+	if up {
+		p.neighbors[neighbor] = struct{}{}
+	} else {
+		delete(p.neighbors, neighbor)
+	}
+	p.linksChanged = append(p.linksChanged, linkEvent{neighbor: neighbor, up: up})
+}
+
+func (p *LinkStateProcess) innerStep(send func(RoutedMessage)) {
+	if p.Process == nil {
+		return
+	}
+	p.Process.Step(
+		func(m RoutedMessage) {
+			if nextHop, ok := p.nextHop(m.To); ok {
+				send(RoutedMessage{
+					Message: m,
+					From: p.Id(),
+					To: nextHop,
+				})
+			} else {
+				Log(p, fmt.Sprintf("unable to deliver message %v", m))
+			}
+		},
+		func() *RoutedMessage {
+			select {
+			case received := <-p.deliveryQueue:
+				return &received
+			default:
+				return nil
+			}
+		},
+	)
+}
+
+func (p *LinkStateProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	defer p.innerStep(send)
+	p.steps += 1
+	if !p.hasStarted || len(p.linksChanged) > 0 ||
+		p.steps-p.lastOriginated >= LinkStateRefreshInterval {
+		for _, event := range p.linksChanged {
+			if event.up {
+				// Bring the new neighbor's database up to date.
+				for _, entry := range p.database {
+					lsa := entry.LinkStateAdvertisement
+					lsa.Age += p.steps - entry.installedAt
+					p.sendAdvertisement(lsa, event.neighbor, send)
+				}
+			}
+		}
+		p.linksChanged = nil
+		p.hasStarted = true
+		p.originate(send)
+	}
+	p.expireAdvertisements()
+	received := receive()
+	if received == nil {
+		return
+	}
+	messageReceived := received.Message
+	if updateMessage, ok := messageReceived.(LinkStateUpdateMessage); ok {
+		p.receiveAdvertisement(updateMessage.LinkStateAdvertisement, received.From, send)
+	} else if innerMessage, ok := messageReceived.(RoutedMessage); ok {
+		if innerMessage.To == p.Id() {
+			select {
+			case p.deliveryQueue <- innerMessage:
+				// delivered
+			default:
+				// queue full; drop the message
+			}
+		} else {
+			// pass it on, if we know the route
+			if nextHop, ok := p.nextHop(innerMessage.To); ok {
+				send(RoutedMessage{
+					Message: innerMessage,
+					From: p.Id(),
+					To: nextHop,
+				})
+			} else {
+				// Otherwise, drop it.
+				Log(p, fmt.Sprintf("unable to deliver message %v", innerMessage))
+			}
+		}
+	} else {
+		panic(fmt.Sprintf(
+			"link state expected either " +
+			"LinkStateUpdateMessage or RoutedMessage",
+		))
+	}
+}
+
+// BellmanFordScenario, routed by link state instead.
+type LinkStateScenario struct{}
+
+func (s LinkStateScenario) Network() Topology {
+	return bellmanFordScenarioNetwork(NewLinkStateTopologyNode)
+}
+
+func (s LinkStateScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s LinkStateScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func init() {
+	RegisterScenario("link-state", "a conversation routed by link state", func(ScenarioParams) Scenario {
+		return LinkStateScenario{}
+	})
+}
@@ -1,16 +1,355 @@
-package main 
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [scenario]\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Runs a scenario, bellman-ford by default, or the one in a -config file. Flags:\n")
+	flag.PrintDefaults()
+}
+
+func listScenarios() {
+	for _, name := range ScenarioNames() {
+		fmt.Printf("%-24s%s\n", name, ScenarioDescription(name))
+	}
+	fmt.Println("\nalgorithms for -benchmark:")
+	for _, name := range BenchmarkAlgorithms() {
+		fmt.Printf("%-24s%s\n", name, BenchmarkDescription(name))
+	}
+}
+
+// Repeats a recorded run, returning the exit status.
+func replayRun(path string) int {
+	record, err := LoadRunRecord(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	scenario, err := record.NewScenario()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	_, err = Replay(scenario, record)
+	if _, ok := err.(*ReplayDivergedError); ok {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Println("replay matches the recording")
+	if err != nil {
+		fmt.Printf("check failed: %s\n", err)
+		return 1
+	}
+	_, checked := scenario.(CheckedScenario)
+	_, withInvariants := scenario.(InvariantScenario)
+	if checked || withInvariants {
+		fmt.Println("check passed")
+	}
+	return 0
+}
+
+func runBenchmark(
+	algorithm, sizes, topologies, csvPath string,
+	options BenchmarkOptions,
+	seed int64,
+	duration time.Duration,
+	durationSet bool,
+) int {
+	options.Algorithm = algorithm
+	options.Seed = seed
+	if durationSet {
+		options.Timeout = duration
+	}
+	if topologies != "" {
+		options.Topologies = strings.Split(topologies, ",")
+	}
+	var err error
+	if options.Sizes, err = parseSizes(sizes); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	logOutput = ioutil.Discard
+	result, err := RunBenchmark(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(result)
+	if csvPath != "" {
+		f, err := os.Create(csvPath)
+		if err == nil {
+			err = result.WriteCSV(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	return 0
+}
+
+func runRoutingComparison(names []string, params ScenarioParams, duration time.Duration) int {
+	if duration <= 0 {
+		fmt.Fprintln(os.Stderr, "-compare-routing needs a -duration")
+		return 2
+	}
+	logOutput = ioutil.Discard
+	comparisons, err := CompareRouting(names, params, duration, params.Seed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(routingComparisonTable(comparisons))
+	for _, c := range comparisons {
+		if c.Err != nil {
+			return 1
+		}
+	}
+	return 0
+}
 
 func main() {
-	switch 2 {
-	case 0: 
-		RunScenario(RandomWithLamportScenario{
-			NumProcs: 10,
-		})
-	case 1:
-		RunScenario(LeaderElectionCompleteScenario{
-			GraphSize: 10,
-		})
-	case 2:
-		RunScenario(BellmanFordScenario{})
+	defaults := DefaultScenarioParams()
+	params := ScenarioParams{}
+	list := flag.Bool("list", false, "list the scenarios and benchmark algorithms and exit")
+	duration := flag.Duration("duration", 5*time.Second, "how long to run, or 0 to run forever")
+	flag.IntVar(&params.NumProcs, "procs", defaults.NumProcs, "number of processes")
+	flag.IntVar(&params.GraphSize, "graph-size", defaults.GraphSize, "number of nodes in generated graphs")
+	flag.Int64Var(&params.Seed, "seed", defaults.Seed, "seed for random choices and generated graphs")
+	flag.StringVar(&params.TopologyFile, "topology", defaults.TopologyFile, "DOT, JSON or edge-list topology file")
+	flag.Float64Var(&params.DropRate, "drop-rate", defaults.DropRate, "fraction of messages lost on lossy links")
+	config := flag.String("config", "", "YAML or JSON scenario file to run instead of a named scenario")
+	simulate := flag.Bool("simulate", false, "run deterministically in virtual time, checking invariants after every step")
+	modelCheck := flag.Bool("model-check", false, "explore every interleaving of a small scenario, checking its invariants")
+	modelCheckOptions := DefaultModelCheckOptions()
+	flag.IntVar(&modelCheckOptions.MaxDepth, "max-depth", modelCheckOptions.MaxDepth, "longest trace to model check")
+	flag.IntVar(&modelCheckOptions.MaxStates, "max-states", modelCheckOptions.MaxStates, "most states to model check")
+	flag.BoolVar(&modelCheckOptions.Drops, "drops", false, "let the model checker lose messages")
+	fuzz := flag.Bool("fuzz", false, "run many random interleavings with drops and crashes, shrinking any failing trace")
+	fuzzOptions := DefaultFuzzOptions()
+	flag.IntVar(&fuzzOptions.Runs, "runs", fuzzOptions.Runs, "fuzz runs, from -seed on")
+	flag.IntVar(&fuzzOptions.Steps, "steps", fuzzOptions.Steps, "choices in each fuzz run")
+	flag.Float64Var(&fuzzOptions.CrashRate, "crash-rate", fuzzOptions.CrashRate, "chance of a crash before each fuzz choice")
+	record := flag.String("record", "", "write the run to this file, for -replay; needs -simulate, -model-check or -fuzz")
+	replay := flag.String("replay", "", "repeat the run recorded in this file exactly")
+	debug := flag.Bool("debug", false, "step the scenario by hand; with -replay, start where the recorded trace ends")
+	dashboard := flag.String("dashboard", "", "serve a page at this local address, like 127.0.0.1:8080, that animates the scenario simulated")
+	metrics := flag.Bool("metrics", false, "with -simulate, print messages sent, delivered, dropped and retransmitted, rounds and time to settle")
+	metricsCSV := flag.String("metrics-csv", "", "with -simulate, append the -metrics of the run to this CSV file")
+	benchmarkOptions := DefaultBenchmarkOptions()
+	benchmark := flag.String("benchmark", "", "measure an algorithm over -sizes and -topologies, one of "+strings.Join(BenchmarkAlgorithms(), ", "))
+	sizes := flag.String("sizes", "8,16,32,64", "comma-separated graph sizes for -benchmark")
+	topologies := flag.String("topologies", "", "comma-separated topologies for -benchmark, by default the algorithm's usual one")
+	flag.IntVar(&benchmarkOptions.Seeds, "bench-seeds", benchmarkOptions.Seeds, "runs of each -benchmark size, from -seed on")
+	benchCSV := flag.String("bench-csv", "", "write the -benchmark results to this CSV file")
+	compareRouting := flag.String("compare-routing", "", "simulate these comma-separated routing scenarios, like bellman-ford,link-state, and compare their routing messages and convergence")
+	events := flag.String("events", "", "write every message sent, received and lost to this file as JSON lines, or - for standard output")
+	flag.Usage = usage
+	flag.Parse()
+	if *list {
+		listScenarios()
+		return
+	}
+	if flag.NArg() > 1 || (*config != "" && flag.NArg() > 0) {
+		usage()
+		os.Exit(2)
+	}
+	if *replay != "" && !*debug {
+		os.Exit(replayRun(*replay))
+	}
+	if *record != "" && !*simulate && !*modelCheck && !*fuzz {
+		fmt.Fprintln(os.Stderr, "-record needs -simulate, -model-check or -fuzz")
+		os.Exit(2)
+	}
+	*metrics = *metrics || *metricsCSV != ""
+	if *metrics && (!*simulate || *record != "") {
+		fmt.Fprintln(os.Stderr, "-metrics and -metrics-csv need -simulate, without -record")
+		os.Exit(2)
+	}
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *benchmark != "" {
+		os.Exit(runBenchmark(*benchmark, *sizes, *topologies, *benchCSV, benchmarkOptions, params.Seed, *duration, set["duration"]))
+	}
+	if *compareRouting != "" {
+		os.Exit(runRoutingComparison(strings.Split(*compareRouting, ","), params, *duration))
+	}
+	var scenario Scenario
+	name := "bellman-ford"
+	if *config != "" {
+		configScenario, err := LoadScenarioConfig(*config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		// The file's settings stand unless overridden on the command line.
+		if !set["duration"] && configScenario.Duration() > 0 {
+			*duration = configScenario.Duration()
+		}
+		if !set["seed"] && configScenario.Config.Seed != 0 {
+			params.Seed = configScenario.Config.Seed
+		}
+		scenario = configScenario
+	} else {
+		if flag.NArg() == 1 {
+			name = flag.Arg(0)
+		}
+		var err error
+		scenario, err = NewScenario(name, params)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s; try -list\n", err)
+			os.Exit(2)
+		}
+	}
+	if *events != "" {
+		out := os.Stdout
+		if *events != "-" {
+			var err error
+			if out, err = os.Create(*events); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+		AddTracer(NewJSONTracer(out))
+	}
+	// Writes run to -record, if set.
+	save := func(run *RunRecord) {
+		if *record == "" {
+			return
+		}
+		run.SetScenario(name, params, scenario)
+		if err := run.Save(*record); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *debug {
+		var record *RunRecord
+		if *replay != "" {
+			var err error
+			if record, err = LoadRunRecord(*replay); err == nil {
+				scenario, err = record.NewScenario()
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			name, params = record.Scenario, record.Params
+		}
+		debugger := NewDebugger(scenario, os.Stdout)
+		debugger.SetScenario = func(r *RunRecord) {
+			r.SetScenario(name, params, scenario)
+		}
+		if record != nil {
+			if err := debugger.Load(record); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+		debugger.Run(os.Stdin)
+		return
+	}
+	if *dashboard != "" {
+		listener, err := listenLocal(*dashboard)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		d := NewDashboard(scenario, *duration, params.Seed)
+		go http.Serve(listener, d.Handler())
+		fmt.Printf("dashboard at http://%s/, paused\n", listener.Addr())
+		_, checked := scenario.(CheckedScenario)
+		_, withInvariants := scenario.(InvariantScenario)
+		if err := d.Run(); err != nil {
+			fmt.Printf("check failed: %s\n", err)
+		} else if checked || withInvariants {
+			fmt.Println("check passed")
+		}
+		fmt.Println("still serving the dashboard; interrupt to exit")
+		select {}
+	}
+	if *modelCheck {
+		logOutput = ioutil.Discard
+		result := ModelCheck(scenario, modelCheckOptions)
+		save(RecordTrace(scenario, result.Trace, result.Violation))
+		fmt.Print(result)
+		if result.Violation != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if *fuzz {
+		logOutput = ioutil.Discard
+		fuzzOptions.Seed = params.Seed
+		if set["drop-rate"] {
+			fuzzOptions.DropRate = params.DropRate
+		}
+		result := Fuzz(scenario, fuzzOptions)
+		save(RecordTrace(scenario, result.Trace, result.Violation))
+		fmt.Print(result)
+		if result.Violation != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if *simulate {
+		if *duration <= 0 {
+			fmt.Fprintln(os.Stderr, "-simulate needs a -duration")
+			os.Exit(2)
+		}
+		var err error
+		if *record != "" {
+			var run *RunRecord
+			run, _, err = RecordSimulation(scenario, *duration, params.Seed)
+			save(run)
+		} else if *metrics {
+			var measured *Metrics
+			measured, _, err = MeasureScenario(scenario, *duration, params.Seed)
+			fmt.Print(measured)
+			if *metricsCSV != "" {
+				label := name
+				if *config != "" {
+					label = *config
+				}
+				if csvErr := measured.AppendCSV(*metricsCSV, label, params); csvErr != nil {
+					fmt.Fprintln(os.Stderr, csvErr)
+					os.Exit(2)
+				}
+			}
+		} else {
+			_, err = SimulateScenario(scenario, *duration, params.Seed)
+		}
+		if err != nil {
+			fmt.Printf("check failed: %s\n", err)
+			os.Exit(1)
+		}
+		_, checked := scenario.(CheckedScenario)
+		_, withInvariants := scenario.(InvariantScenario)
+		if checked || withInvariants {
+			fmt.Println("check passed")
+		}
+		return
+	}
+	SeedSimulation(params.Seed)
+	c := RunScenarioFor(scenario, *duration)
+	checked, ok := scenario.(CheckedScenario)
+	if !ok {
+		return
+	}
+	if err := checked.Check(c); err != nil {
+		fmt.Printf("check failed: %s\n", err)
+		os.Exit(1)
 	}
+	fmt.Println("check passed")
 }
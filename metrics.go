@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Counts a run's messages from the trace event stream, per process and
+// per message type, along with its rounds and when it first reached a
+// predicate, so algorithms can be compared by message and time complexity.
+
+type MessageCounts struct {
+	Sent int
+	Delivered int
+	Dropped int
+	Retransmitted int
+	// Of the messages sent, for those that can be encoded.
+	Bytes int
+}
+
+type Metrics struct {
+	mutex sync.Mutex
+	// Messages are counted against their sender, except deliveries,
+	// which are counted against their receiver.
+	Processes map[ProcessID]*MessageCounts
+	Types map[string]*MessageCounts
+	Total MessageCounts
+	Rounds int
+	Elapsed time.Duration
+	// Checked after every round; by default, clusterSettled.
+	Predicate func(c Cluster) error
+	// The round from which Predicate held to the end of the run, and the
+	// virtual time and messages sent by then. ReachedRound is -1 if it
+	// did not hold at the end, and NotReached says why.
+	ReachedRound int
+	ReachedAt time.Duration
+	ReachedMessages int
+	NotReached error
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Processes: make(map[ProcessID]*MessageCounts),
+		Types: make(map[string]*MessageCounts),
+		Predicate: clusterSettled,
+		ReachedRound: -1,
+		NotReached: fmt.Errorf("no rounds ran"),
+	}
+}
+
+// Whether every process the dashboard colors has reached a final state:
+// its election or proposal is over, it has heard a broadcast, or its
+// routes have converged.
+func clusterSettled(c Cluster) error {
+	topo := c.CurrentTopology()
+	settling := 0
+	for _, id := range sortedIDs(topo) {
+		switch state := processState(topo[id].Subprocess, topo); state {
+		case "":
+		case "electing", "proposing", "routing", "waiting":
+			return fmt.Errorf("%s is still %s", id, state)
+		default:
+			settling++
+		}
+	}
+	if settling == 0 {
+		return fmt.Errorf("no process elects, proposes, broadcasts or routes")
+	}
+	return nil
+}
+
+func (m *Metrics) counts(id ProcessID, messageType string) []*MessageCounts {
+	process, ok := m.Processes[id]
+	if !ok {
+		process = &MessageCounts{}
+		m.Processes[id] = process
+	}
+	byType, ok := m.Types[messageType]
+	if !ok {
+		byType = &MessageCounts{}
+		m.Types[messageType] = byType
+	}
+	return []*MessageCounts{process, byType, &m.Total}
+}
+
+func (m *Metrics) Trace(event TraceEvent) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	switch event.Kind {
+	case "send":
+		for _, counts := range m.counts(event.From, event.Type) {
+			counts.Sent++
+			counts.Bytes += event.Bytes
+		}
+	case "receive":
+		for _, counts := range m.counts(event.To, event.Type) {
+			counts.Delivered++
+		}
+	case "drop":
+		for _, counts := range m.counts(event.From, event.Type) {
+			counts.Dropped++
+		}
+	case "retransmit":
+		for _, counts := range m.counts(event.From, event.Type) {
+			counts.Retransmitted++
+		}
+	case "round":
+		m.Rounds = event.Round
+		m.Elapsed = event.Elapsed
+	}
+}
+
+// Checks the predicate after a round of s.
+func (m *Metrics) observe(s *Simulation) {
+	err := m.Predicate(s.Cluster)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.NotReached = err
+	switch {
+	case err != nil:
+		m.ReachedRound = -1
+	case m.ReachedRound < 0:
+		m.ReachedRound = s.Rounds
+		m.ReachedAt = s.Elapsed()
+		m.ReachedMessages = m.Total.Sent
+	}
+}
+
+// Simulates scenario as SimulateScenario does, measuring the run.
+func MeasureScenario(scenario Scenario, duration time.Duration, seed int64) (*Metrics, Cluster, error) {
+	m := NewMetrics()
+	s := newScenarioSimulation(scenario, seed)
+	s.afterRound = func() { m.observe(s) }
+	AddTracer(m)
+	defer RemoveTracer(m)
+	err := s.runScenario(scenario, duration)
+	return m, s.Cluster, err
+}
+
+func (m *Metrics) typeNames() []string {
+	names := make([]string, 0, len(m.Types))
+	for name := range m.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Metrics) processIDs() []ProcessID {
+	ids := make([]ProcessID, 0, len(m.Processes))
+	for id := range m.Processes {
+		ids = append(ids, id)
+	}
+	sortProcessIDs(ids)
+	return ids
+}
+
+// A table of the counts, by process, by message type and in total.
+func (m *Metrics) String() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d rounds, %s of virtual time; ", m.Rounds, m.Elapsed)
+	if m.ReachedRound >= 0 {
+		fmt.Fprintf(&b, "settled at round %d, %s, after %d messages\n", m.ReachedRound, m.ReachedAt, m.ReachedMessages)
+	} else {
+		fmt.Fprintf(&b, "not settled: %s\n", m.NotReached)
+	}
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\tsent\tdelivered\tdropped\tretransmitted\tbytes\t")
+	row := func(name string, c *MessageCounts) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t\n", name, c.Sent, c.Delivered, c.Dropped, c.Retransmitted, c.Bytes)
+	}
+	for _, id := range m.processIDs() {
+		row(id.String(), m.Processes[id])
+	}
+	for _, name := range m.typeNames() {
+		row(name, m.Types[name])
+	}
+	row("total", &m.Total)
+	w.Flush()
+	return b.String()
+}
+
+var metricsCSVHeader = []string{
+	"scenario", "graph_size", "procs", "seed", "rounds", "elapsed_ms",
+	"settled_round", "settled_ms", "settled_messages",
+	"scope", "key", "sent", "delivered", "dropped", "retransmitted", "bytes",
+}
+
+// Writes a row for each process, each message type and the total, each
+// labelled with the run, so that rows from many runs can share a file.
+func (m *Metrics) WriteCSV(w io.Writer, header bool, scenario string, params ScenarioParams) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	out := csv.NewWriter(w)
+	if header {
+		out.Write(metricsCSVHeader)
+	}
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	}
+	run := []string{
+		scenario, strconv.Itoa(params.GraphSize), strconv.Itoa(params.NumProcs),
+		strconv.FormatInt(params.Seed, 10), strconv.Itoa(m.Rounds), ms(m.Elapsed),
+		"", "", "",
+	}
+	if m.ReachedRound >= 0 {
+		run[6], run[7], run[8] = strconv.Itoa(m.ReachedRound), ms(m.ReachedAt), strconv.Itoa(m.ReachedMessages)
+	}
+	row := func(scope, key string, c *MessageCounts) {
+		out.Write(append(append([]string{}, run...),
+			scope, key, strconv.Itoa(c.Sent), strconv.Itoa(c.Delivered),
+			strconv.Itoa(c.Dropped), strconv.Itoa(c.Retransmitted), strconv.Itoa(c.Bytes),
+		))
+	}
+	for _, id := range m.processIDs() {
+		row("process", strconv.Itoa(int(id)), m.Processes[id])
+	}
+	for _, name := range m.typeNames() {
+		row("type", name, m.Types[name])
+	}
+	row("total", "", &m.Total)
+	out.Flush()
+	return out.Error()
+}
+
+// Appends to the CSV file at path, starting it with a header if it is new.
+func (m *Metrics) AppendCSV(path string, scenario string, params ScenarioParams) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = m.WriteCSV(f, info.Size() == 0, scenario, params)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
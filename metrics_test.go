@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	params := DefaultScenarioParams()
+	params.GraphSize = 6
+	scenario, err := NewScenario("lcr", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := MeasureScenario(scenario, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := 0
+	for _, counts := range m.Processes {
+		sent += counts.Sent
+	}
+	if sent != m.Total.Sent || m.Types["lcr"].Sent != m.Total.Sent {
+		t.Errorf("processes sent %d and lcr messages %d, but %d in total", sent, m.Types["lcr"].Sent, m.Total.Sent)
+	}
+	if m.Total.Delivered != m.Total.Sent || m.Total.Bytes == 0 {
+		t.Errorf("%d sent, %d delivered, %d bytes", m.Total.Sent, m.Total.Delivered, m.Total.Bytes)
+	}
+	if m.Rounds != 100 || m.ReachedRound < 0 || m.ReachedMessages > m.Total.Sent {
+		t.Errorf("%d rounds, settled at round %d after %d messages: %v", m.Rounds, m.ReachedRound, m.ReachedMessages, m.NotReached)
+	}
+
+	var b bytes.Buffer
+	if err := m.WriteCSV(&b, true, "lcr", params); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header, the processes, lcr and the total.
+	if len(rows) != 1+6+1+1 {
+		t.Errorf("%d rows", len(rows))
+	}
+}
+
+func TestMetricsRetransmissions(t *testing.T) {
+	params := DefaultScenarioParams()
+	params.DropRate = 0.3
+	scenario, err := NewScenario("lossy-tcp", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := MeasureScenario(scenario, 5*time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Total.Dropped == 0 || m.Total.Retransmitted == 0 {
+		t.Errorf("%d dropped, %d retransmitted", m.Total.Dropped, m.Total.Retransmitted)
+	}
+	if m.ReachedRound >= 0 {
+		t.Errorf("settled without settling processes at round %d", m.ReachedRound)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Explores every order in which the processes of a small cluster can
+// step and its messages can arrive, checking the scenario's invariants
+// in each state reached. States are told apart by hashing the processes
+// and the messages in flight, so each is explored once. Processes can't
+// be copied, so a state is rebuilt by replaying the choices that lead
+// to it on a fresh network. The search is breadth first, so the first
+// violation found comes with a shortest trace.
+
+type ChoiceKind int
+
+const (
+	// A process steps with nothing to receive, as it does to start
+	// or when it times out.
+	StepChoice ChoiceKind = iota
+	// A message in flight arrives and its receiver steps.
+	DeliverChoice
+	// A message in flight is lost.
+	DropChoice
+	// A process stops for good, and messages to it are lost.
+	CrashChoice
+	// A message in flight is sent twice.
+	DuplicateChoice
+)
+
+type Choice struct {
+	Kind ChoiceKind
+	// The process that steps, or the message's receiver.
+	Process ProcessID
+	Message *RoutedMessage
+	messageHash uint64
+	// For a step, the state of the process before it.
+	processHash uint64
+}
+
+func (c Choice) String() string {
+	switch c.Kind {
+	case StepChoice:
+		return fmt.Sprintf("step %s", c.Process)
+	case CrashChoice:
+		return fmt.Sprintf("crash %s", c.Process)
+	}
+	// Messages loaded from a recording are known only by their hash.
+	message := fmt.Sprintf("message %016x to %s", c.messageHash, c.Process)
+	if c.Message != nil {
+		message = c.Message.String()
+	}
+	return fmt.Sprintf("%s %s", choiceKindNames[c.Kind], message)
+}
+
+type ModelCheckOptions struct {
+	// Longest trace explored.
+	MaxDepth int
+	// Most states explored.
+	MaxStates int
+	// Whether messages may be lost.
+	Drops bool
+}
+
+func DefaultModelCheckOptions() ModelCheckOptions {
+	return ModelCheckOptions{
+		MaxDepth: 40,
+		MaxStates: 200000,
+	}
+}
+
+type ModelCheckResult struct {
+	States int
+	Transitions int
+	// Whether every state within MaxDepth was explored.
+	Complete bool
+	// The first invariant broken, if any, and a shortest trace breaking it.
+	Violation error
+	Trace []Choice
+}
+
+func (r ModelCheckResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d states, %d transitions", r.States, r.Transitions)
+	if !r.Complete {
+		b.WriteString(", stopped at the bounds")
+	}
+	if r.Violation == nil {
+		b.WriteString(", no violations\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n%s\n", r.Violation)
+	for i, choice := range r.Trace {
+		fmt.Fprintf(&b, "%3d. %s\n", i+1, choice)
+	}
+	return b.String()
+}
+
+// Model checks scenario against its invariants. Scenarios should be small:
+// a few processes that stop sending once they are done.
+func ModelCheck(scenario Scenario, options ModelCheckOptions) ModelCheckResult {
+	var invariants []Invariant
+	if withInvariants, ok := scenario.(InvariantScenario); ok {
+		invariants = withInvariants.Invariants()
+	}
+	if err := CheckTopology(scenario.Network()); err != nil {
+		panic(err.Error())
+	}
+	result := ModelCheckResult{Complete: true}
+	root := newModelWorld(scenario)
+	rootHash := root.hash()
+	visited := map[uint64]struct{}{rootHash: {}}
+	result.States = 1
+	if err := root.check(invariants); err != nil {
+		result.Violation = err
+		return result
+	}
+	// States still to explore, by their traces and the choices from them.
+	type pending struct {
+		trace []Choice
+		hash uint64
+		choices []Choice
+	}
+	queue := []pending{{hash: rootHash, choices: root.choices(options.Drops)}}
+	// The process states in which stepping does nothing, which saves
+	// replaying such steps from every state the process is in.
+	idle := make(map[uint64]struct{})
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if len(state.trace) >= options.MaxDepth {
+			result.Complete = false
+			continue
+		}
+		for _, choice := range state.choices {
+			if _, ok := idle[choice.processHash]; ok && choice.Kind == StepChoice {
+				continue
+			}
+			next := append(append([]Choice{}, state.trace...), choice)
+			w := replayModel(scenario, next)
+			result.Transitions++
+			h := w.hash()
+			if h == state.hash && choice.Kind == StepChoice {
+				idle[choice.processHash] = struct{}{}
+			}
+			if _, ok := visited[h]; ok {
+				continue
+			}
+			if result.States >= options.MaxStates {
+				result.Complete = false
+				return result
+			}
+			visited[h] = struct{}{}
+			result.States++
+			if err := w.check(invariants); err != nil {
+				result.Violation = err
+				result.Trace = next
+				return result
+			}
+			queue = append(queue, pending{trace: next, hash: h, choices: w.choices(options.Drops)})
+		}
+	}
+	return result
+}
+
+// Rebuilds the state a trace leads to, for example to inspect
+// the processes of a counterexample.
+func ReplayModelTrace(scenario Scenario, trace []Choice) Cluster {
+	return replayModel(scenario, trace).cluster
+}
+
+func replayModel(scenario Scenario, trace []Choice) *modelWorld {
+	w := newModelWorld(scenario)
+	for _, choice := range trace {
+		w.apply(choice)
+	}
+	return w
+}
+
+type modelMessage struct {
+	RoutedMessage
+	hash uint64
+}
+
+// A cluster without goroutines or channels, whose messages wait
+// in flight until chosen.
+type modelWorld struct {
+	topo Topology
+	ids []ProcessID
+	processes map[ProcessID]Process
+	inFlight []modelMessage
+	crashed map[ProcessID]bool
+	// The processes as a Cluster, for invariants.
+	cluster Cluster
+	// Set by hash.
+	processHashes map[ProcessID]uint64
+}
+
+func newModelWorld(scenario Scenario) *modelWorld {
+	// Processes that draw random numbers draw the same ones every replay.
+	SeedSimulation(1)
+	topo := scenario.Network()
+	w := &modelWorld{
+		topo: topo,
+		ids: sortedIDs(topo),
+		processes: make(map[ProcessID]Process, len(topo)),
+		cluster: make(Cluster, len(topo)),
+		crashed: make(map[ProcessID]bool),
+	}
+	for _, id := range w.ids {
+		p := topo[id].Subprocess
+		if aware, ok := p.(NeighborAware); ok {
+			aware.SetNeighbors(topo.InNeighbors(id), topo.OutNeighbors(id))
+		}
+		w.processes[id] = p
+		w.cluster[id] = &DirectConnectedProcess{P: p}
+	}
+	return w
+}
+
+func (w *modelWorld) step(id ProcessID, delivered *RoutedMessage) {
+	w.processes[id].Step(
+		func(m RoutedMessage) {
+			if m.From != id {
+				panic(fmt.Sprintf("%s cannot send a message that is 'from' %s", id, m.From))
+			}
+			if _, ok := w.topo.OutNeighbors(id)[m.To]; !ok {
+				panic(fmt.Sprintf("%s does not exist as a neighbor of %s", m.To, id))
+			}
+			if w.crashed[m.To] {
+				return
+			}
+			w.inFlight = append(w.inFlight, modelMessage{RoutedMessage: m, hash: stateHash(m)})
+		},
+		func() *RoutedMessage {
+			m := delivered
+			delivered = nil
+			return m
+		},
+	)
+}
+
+func (w *modelWorld) apply(choice Choice) {
+	if !w.tryApply(choice) {
+		panic(fmt.Sprintf("cannot replay %s: the scenario is not deterministic", choice))
+	}
+}
+
+// Makes choice unless it is impossible in this state: the process has
+// crashed, or the message is not in flight.
+func (w *modelWorld) tryApply(choice Choice) bool {
+	if w.crashed[choice.Process] {
+		return false
+	}
+	switch choice.Kind {
+	case StepChoice:
+		w.step(choice.Process, nil)
+		return true
+	case CrashChoice:
+		w.crashed[choice.Process] = true
+		inFlight := w.inFlight[:0]
+		for _, m := range w.inFlight {
+			if m.To != choice.Process {
+				inFlight = append(inFlight, m)
+			}
+		}
+		w.inFlight = inFlight
+		return true
+	}
+	for i, m := range w.inFlight {
+		if m.hash != choice.messageHash {
+			continue
+		}
+		if choice.Kind == DuplicateChoice {
+			w.inFlight = append(w.inFlight, m)
+			return true
+		}
+		w.inFlight = append(w.inFlight[:i:i], w.inFlight[i+1:]...)
+		if choice.Kind == DeliverChoice {
+			w.step(m.To, &m.RoutedMessage)
+		}
+		return true
+	}
+	return false
+}
+
+// Every choice from this state, in a fixed order.
+// Identical messages in flight are one choice.
+func (w *modelWorld) choices(drops bool) []Choice {
+	if w.processHashes == nil {
+		w.hash()
+	}
+	var choices []Choice
+	for _, id := range w.ids {
+		if !w.crashed[id] {
+			choices = append(choices, Choice{Kind: StepChoice, Process: id, processHash: w.processHashes[id]})
+		}
+	}
+	seen := make(map[uint64]struct{}, len(w.inFlight))
+	var messages []Choice
+	for _, m := range w.inFlight {
+		if _, ok := seen[m.hash]; ok {
+			continue
+		}
+		seen[m.hash] = struct{}{}
+		routed := m.RoutedMessage
+		messages = append(messages, Choice{
+			Kind: DeliverChoice,
+			Process: m.To,
+			Message: &routed,
+			messageHash: m.hash,
+		})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Process != messages[j].Process {
+			return messages[i].Process < messages[j].Process
+		}
+		return messages[i].messageHash < messages[j].messageHash
+	})
+	choices = append(choices, messages...)
+	if drops {
+		for _, choice := range messages {
+			choice.Kind = DropChoice
+			choices = append(choices, choice)
+		}
+	}
+	return choices
+}
+
+// The processes in id order and the messages in flight in any order.
+func (w *modelWorld) hash() uint64 {
+	inFlight := make([]uint64, len(w.inFlight))
+	for i, m := range w.inFlight {
+		inFlight[i] = m.hash
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i] < inFlight[j] })
+	w.processHashes = make(map[ProcessID]uint64, len(w.ids))
+	processes := make([]uint64, len(w.ids))
+	for i, id := range w.ids {
+		// Processes of different ids in the same state differ.
+		processes[i] = stateHash(struct {
+			ID ProcessID
+			Crashed bool
+			P Process
+		}{id, w.crashed[id], w.processes[id]})
+		w.processHashes[id] = processes[i]
+	}
+	return stateHash(struct {
+		Processes []uint64
+		InFlight []uint64
+	}{processes, inFlight})
+}
+
+func (w *modelWorld) check(invariants []Invariant) error {
+	for _, invariant := range invariants {
+		if err := invariant.Check(w.cluster); err != nil {
+			return fmt.Errorf("invariant %s broken: %s", invariant.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestModelCheckFindsSinglePhasePaxosBug(t *testing.T) {
+	scenario := PaxosScenario{SkipPrepare: true}
+	result := ModelCheck(scenario, DefaultModelCheckOptions())
+	if result.Violation == nil {
+		t.Fatalf("no violation found: %s", result)
+	}
+	// Each proposer starts and gets two acceptors to accept.
+	if len(result.Trace) != 10 {
+		t.Errorf("want a 10 step trace, got:\n%s", result)
+	}
+	if err := paxosAgreement(ReplayModelTrace(scenario, result.Trace)); err == nil {
+		t.Errorf("replayed trace does not break agreement:\n%s", result)
+	}
+}
+
+func TestModelCheckPaxos(t *testing.T) {
+	options := DefaultModelCheckOptions()
+	options.MaxStates = 5000
+	if testing.Short() {
+		options.MaxStates = 500
+	}
+	result := ModelCheck(PaxosScenario{}, options)
+	if result.Violation != nil {
+		t.Errorf("violation found:\n%s", result)
+	}
+}
+
+func TestStateHash(t *testing.T) {
+	a := &PaxosProposer{ID: 1, promises: map[ProcessID]struct{}{2: {}, 3: {}, 4: {}}}
+	b := &PaxosProposer{ID: 1, promises: map[ProcessID]struct{}{4: {}, 3: {}, 2: {}}}
+	if stateHash(a) != stateHash(b) {
+		t.Error("map order changes the hash")
+	}
+	b.promises[5] = struct{}{}
+	if stateHash(a) == stateHash(b) {
+		t.Error("unexported map contents don't change the hash")
+	}
+}
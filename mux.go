@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Hosts several protocols on one node, sharing its links.
+// Each child's messages are tagged with its protocol name, and received
+// messages are handed only to the child with the matching protocol,
+// so wrappers that panic on foreign message types can run side by side.
+// Every child must have the mux's Id.
+type MuxProcess struct {
+	ID ProcessID
+	Protocols map[string]Process
+	queues map[string][]RoutedMessage
+}
+
+type MuxMessage struct {
+	Message
+	Protocol string
+}
+
+func (m MuxMessage) String() string {
+	return fmt.Sprintf("[%s] %s", m.Protocol, m.Message)
+}
+
+func (m MuxMessage) MessageTag() string {
+	return "mux"
+}
+
+func (m MuxMessage) Encode(e *Encoder) {
+	e.WriteString(m.Protocol)
+	e.WriteMessage(m.Message)
+}
+
+func init() {
+	RegisterMessageType("mux", func(d *Decoder) Message {
+		m := MuxMessage{}
+		m.Protocol = d.ReadString()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
+
+func NewMuxProcess(id ProcessID, protocols map[string]Process) *MuxProcess {
+	for protocol, p := range protocols {
+		if p.Id() != id {
+			panic(fmt.Sprintf("mux %s cannot host %s protocol of %s", id, protocol, p.Id()))
+		}
+	}
+	return &MuxProcess{
+		ID: id,
+		Protocols: protocols,
+		queues: make(map[string][]RoutedMessage, len(protocols)),
+	}
+}
+
+func (p *MuxProcess) Id() ProcessID {
+	return p.ID
+}
+
+// Children step in name order, so runs don't depend on map iteration.
+func (p *MuxProcess) protocolNames() []string {
+	names := make([]string, 0, len(p.Protocols))
+	for protocol := range p.Protocols {
+		names = append(names, protocol)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *MuxProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	if p.queues == nil {
+		p.queues = make(map[string][]RoutedMessage, len(p.Protocols))
+	}
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(MuxMessage)
+		if !ok {
+			panic(fmt.Sprintf("mux unexpected message type %T %s", received.Message, received.Message))
+		}
+		if _, ok := p.Protocols[m.Protocol]; !ok {
+			Log(p, fmt.Sprintf("dropping message for unknown protocol %q", m.Protocol))
+			continue
+		}
+		p.queues[m.Protocol] = append(p.queues[m.Protocol], RoutedMessage{
+			Message: m.Message,
+			From: received.From,
+			To: received.To,
+		})
+	}
+	for _, protocol := range p.protocolNames() {
+		protocol := protocol
+		p.Protocols[protocol].Step(
+			func(m RoutedMessage) {
+				send(RoutedMessage{
+					Message: MuxMessage{Message: m.Message, Protocol: protocol},
+					From: m.From,
+					To: m.To,
+				})
+			},
+			func() *RoutedMessage {
+				queue := p.queues[protocol]
+				if len(queue) == 0 {
+					return nil
+				}
+				m := queue[0]
+				p.queues[protocol] = queue[1:]
+				return &m
+			},
+		)
+	}
+}
+
+// Two nodes each running a conversation next to random Lamport-clocked
+// chatter, multiplexed over one TCP connection.
+type MuxScenario struct{}
+
+func (s MuxScenario) Network() Topology {
+	phrases := map[ProcessID][]string{
+		0: []string{"shall we talk", "over all this noise"},
+		1: []string{"sure", "it's no bother"},
+	}
+	topo := make(Topology, 2)
+	for id := ProcessID(0); id < 2; id++ {
+		topo[id] = TopologyNode{
+			Subprocess: &MultiTCPProcess{
+				Process: NewMuxProcess(id, map[string]Process{
+					"chat": &ConversationProcess{
+						ID: id,
+						FriendID: 1 - id,
+						Phrases: phrases[id],
+						Initiate: id == 0,
+					},
+					"clock": &LamportProcess{
+						Process: &RandomProcess{
+							IncrementalID: id,
+							NeighborCount: 2,
+						},
+					},
+				}),
+			},
+			Neighbors: map[ProcessID]struct{}{1 - id: {}},
+		}
+	}
+	return topo
+}
+
+func (s MuxScenario) Check(c Cluster) error {
+	return conversationsComplete(c)
+}
+
+func init() {
+	RegisterScenario("mux", "two protocols sharing one link", func(ScenarioParams) Scenario {
+		return MuxScenario{}
+	})
+}
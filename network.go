@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sync"
 	"time"
 )
@@ -60,8 +62,11 @@ type Process interface {
 	)
 }
 
+// Where Log and scenario scripts write, so tests can silence them.
+var logOutput io.Writer = os.Stdout
+
 func Log(p Process, s string) {
-	fmt.Printf("%s: %s\n", p.Id(), s)
+	fmt.Fprintf(logOutput, "%s: %s\n", p.Id(), s)
 }
 
 // A Process that knows how to communicate with other processes.
@@ -70,11 +75,82 @@ func Log(p Process, s string) {
 // to avoid embedding it and causing confusion.
 type DirectConnectedProcess struct {
 	P Process
-	OutChans map[ProcessID]chan RoutedMessage
 	InChan chan RoutedMessage
+	Faults LinkFaults
+	// Messages held back by reordering, released at the end of the next Step.
+	heldBack []RoutedMessage
+	// Messages waiting out their link's latency.
+	inFlight []delayedMessage
+	// Links are cut, restored, added and removed from other goroutines,
+	// so everything below is guarded by linkMutex.
+	linkMutex sync.Mutex
+	OutChans map[ProcessID]chan RoutedMessage
+	// Neighbors that can send to this process, over links that
+	// may not go the other way.
+	InNeighbors map[ProcessID]struct{}
+	Latencies map[ProcessID]time.Duration
+	// Routing costs of links weighted more than 1.
+	Weights map[ProcessID]int
+	downLinks map[ProcessID]struct{}
+	linkEvents []linkEvent
+	neighborsChanged bool
+	removed bool
+	// Guarded by clusterMutex.
+	running bool
+	// Set when a Simulation runs the process, to keep virtual time.
+	clock *simClock
+}
+
+// Implemented by processes that want to know when the links
+// to their neighbors fail or recover.
+type LinkObserver interface {
+	LinkChanged(neighbor ProcessID, up bool)
+}
+
+// Implemented by processes that want to know the properties of links
+// added while the cluster runs. Called just before LinkChanged reports
+// the new link up.
+type LinkAddObserver interface {
+	LinkAdded(neighbor ProcessID, link Link)
+}
+
+// Implemented by processes that want to know which neighbors they can send to
+// and which can send to them, which differ when some links are one way.
+// Called when the cluster is created, and again whenever they change.
+type NeighborAware interface {
+	SetNeighbors(in map[ProcessID]struct{}, out map[ProcessID]struct{})
+}
+
+type linkEvent struct {
+	neighbor ProcessID
+	up bool
+	// Set when the link was just added.
+	added *Link
+}
+
+type delayedMessage struct {
+	RoutedMessage
+	deliverAt time.Time
+}
+
+// Faults injected by the link layer into every message a node sends.
+// The zero value is a reliable, in-order link.
+type LinkFaults struct {
+	// Probability that a message is silently lost.
+	DropRate float64
+	// Probability that a message is held back for a step,
+	// so messages sent after it overtake it.
+	ReorderRate float64
+	// Probability that a bit of the message's encoding is flipped.
+	// Corrupted messages that no longer decode are lost.
+	CorruptRate float64
 }
 
 func (p *DirectConnectedProcess) Step() {
+	p.notifyLinkEvents()
+	p.deliverInFlight()
+	heldBack := p.heldBack
+	p.heldBack = nil
 	p.P.Step(
 		func(m RoutedMessage) {
 			p.Send(m)
@@ -83,12 +159,15 @@ func (p *DirectConnectedProcess) Step() {
 			return p.Receive()
 		},
 	)
+	for _, m := range heldBack {
+		p.transmit(m)
+	}
 }
 
-// Actually infinite loops
+// Runs until the process is removed from its cluster.
 // TODO: track whether channels are empty, and ask process if it's idle.
 func (p *DirectConnectedProcess) RunTillDone() {
-	for {
+	for !p.isRemoved() {
 		p.Step() 
 		time.Sleep(10 * time.Millisecond) // yield
 	}
@@ -101,23 +180,148 @@ func (p *DirectConnectedProcess) Send(m RoutedMessage) {
 	}
 	// validate neighbor
 	nbr := m.To
-	outChan, ok := p.OutChans[nbr]
-	if !ok {
+	p.linkMutex.Lock()
+	_, isOut := p.OutChans[nbr]
+	_, isIn := p.InNeighbors[nbr]
+	p.linkMutex.Unlock()
+	if !isOut {
+		if isIn {
+			panic(fmt.Sprintf("%s cannot send to %s over a one-way link from %s", p.Id(), nbr, nbr))
+		}
 		panic(fmt.Sprintf("%d does not exist as a neighbor of %d", nbr, p.Id()))
 	}
+	traceMessage("send", m)
+	if p.Faults.DropRate > 0 && simRand.Float64() < p.Faults.DropRate {
+		// lost on the link
+		traceMessage("drop", m)
+		return
+	}
+	if p.Faults.CorruptRate > 0 && simRand.Float64() < p.Faults.CorruptRate {
+		corrupted, ok := corrupt(m.Message)
+		if !ok {
+			traceMessage("drop", m)
+			return
+		}
+		m.Message = corrupted
+	}
+	if p.Faults.ReorderRate > 0 && simRand.Float64() < p.Faults.ReorderRate {
+		p.heldBack = append(p.heldBack, m)
+		return
+	}
+	p.transmit(m)
+}
+
+func (p *DirectConnectedProcess) transmit(m RoutedMessage) {
+	p.linkMutex.Lock()
+	latency := p.Latencies[m.To]
+	p.linkMutex.Unlock()
+	if latency > 0 {
+		p.inFlight = append(p.inFlight, delayedMessage{
+			RoutedMessage: m,
+			deliverAt: p.now().Add(latency),
+		})
+		return
+	}
+	p.deliver(m)
+}
+
+func (p *DirectConnectedProcess) now() time.Time {
+	if p.clock != nil {
+		return p.clock.now
+	}
+	return time.Now()
+}
+
+func (p *DirectConnectedProcess) deliverInFlight() {
+	now := p.now()
+	stillInFlight := p.inFlight[:0]
+	for _, m := range p.inFlight {
+		if now.Before(m.deliverAt) {
+			stillInFlight = append(stillInFlight, m)
+		} else {
+			p.deliver(m.RoutedMessage)
+		}
+	}
+	p.inFlight = stillInFlight
+}
+
+func (p *DirectConnectedProcess) deliver(m RoutedMessage) {
+	p.linkMutex.Lock()
+	outChan, ok := p.OutChans[m.To]
+	_, down := p.downLinks[m.To]
+	p.linkMutex.Unlock()
+	if !ok || down {
+		// lost with the link
+		traceMessage("drop", m)
+		return
+	}
 	select {
 	case outChan <- m:
 		// Log(p.P, fmt.Sprintf("sent %s", m))
 		// sent
 	default:
 		// dropped because direct channel is full
+		traceMessage("drop", m)
+	}
+}
+
+func (p *DirectConnectedProcess) setLink(neighbor ProcessID, up bool) {
+	p.linkMutex.Lock()
+	defer p.linkMutex.Unlock()
+	if _, down := p.downLinks[neighbor]; down != up {
+		// no change
+		return
+	}
+	if up {
+		delete(p.downLinks, neighbor)
+	} else {
+		if p.downLinks == nil {
+			p.downLinks = make(map[ProcessID]struct{})
+		}
+		p.downLinks[neighbor] = struct{}{}
 	}
+	p.linkEvents = append(p.linkEvents, linkEvent{neighbor: neighbor, up: up})
+}
+
+// Tell the process about links that changed since its last step,
+// from its own goroutine.
+func (p *DirectConnectedProcess) notifyLinkEvents() {
+	p.linkMutex.Lock()
+	events := p.linkEvents
+	p.linkEvents = nil
+	neighborsChanged := p.neighborsChanged
+	p.neighborsChanged = false
+	in, out := copyIDs(p.InNeighbors), make(map[ProcessID]struct{}, len(p.OutChans))
+	for pid := range p.OutChans {
+		out[pid] = struct{}{}
+	}
+	p.linkMutex.Unlock()
+	if aware, ok := p.P.(NeighborAware); ok && neighborsChanged {
+		aware.SetNeighbors(in, out)
+	}
+	for _, event := range events {
+		if adder, ok := p.P.(LinkAddObserver); ok && event.added != nil {
+			adder.LinkAdded(event.neighbor, *event.added)
+		}
+		if observer, ok := p.P.(LinkObserver); ok {
+			observer.LinkChanged(event.neighbor, event.up)
+		}
+	}
+}
+
+func copyIDs(ids map[ProcessID]struct{}) map[ProcessID]struct{} {
+	copied := make(map[ProcessID]struct{}, len(ids))
+	for id := range ids {
+		copied[id] = struct{}{}
+	}
+	return copied
 }
 
 func (p *DirectConnectedProcess) Receive() *RoutedMessage {
 	select {
 	case m := <-p.InChan:
 		// Log(p.P, fmt.Sprintf("received %s", m))
+		traceMessage("receive", m)
 		return &m
 	default:
 		return nil
@@ -145,52 +349,156 @@ func (p SimpleProcess) Step(send func(RoutedMessage), receive func() *RoutedMess
 
 type Cluster map[ProcessID]*DirectConnectedProcess
 
+// Runs until every process has been removed or the cluster is stopped,
+// starting processes as they are added.
 func (c Cluster) RunTillDone() {
 	var wg sync.WaitGroup
-	wg.Add(len(c))
-	for _, process := range c {
-		process := process
-		go func() {
-			defer wg.Done()
-			process.RunTillDone()
-		}()
+	for {
+		clusterMutex.Lock()
+		done := true
+		for _, process := range c {
+			if process.isRemoved() {
+				continue
+			}
+			done = false
+			if process.running {
+				continue
+			}
+			process.running = true
+			wg.Add(1)
+			process := process
+			go func() {
+				defer wg.Done()
+				process.RunTillDone()
+			}()
+		}
+		clusterMutex.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 	wg.Wait()
 }
 
+// Stops every process after its current Step, leaving the cluster
+// in place so its processes can be inspected once RunTillDone returns.
+func (c Cluster) Stop() {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	for _, process := range c {
+		process.linkMutex.Lock()
+		process.removed = true
+		process.linkMutex.Unlock()
+	}
+}
+
+// Fail the link between a and b in both directions.
+// Messages sent over it are lost until it is restored.
+func (c Cluster) CutLink(a, b ProcessID) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	c.setLink(a, b, false)
+	c.setLink(b, a, false)
+}
+
+func (c Cluster) RestoreLink(a, b ProcessID) {
+	clusterMutex.Lock()
+	defer clusterMutex.Unlock()
+	c.setLink(a, b, true)
+	c.setLink(b, a, true)
+}
+
+// Only the sending end of a one-way link hears about it.
+func (c Cluster) setLink(from, to ProcessID, up bool) {
+	if p, ok := c[from]; ok && p.hasLinkTo(to) {
+		p.setLink(to, up)
+		trace(TraceEvent{Kind: "link", From: from, To: to, Up: up})
+	}
+}
+
 type TopologyNode struct{
 	Subprocess Process
 	Neighbors map[ProcessID]struct{}
+	// Faults on the links from this node to its neighbors.
+	Faults LinkFaults
+	// Optional properties of the links to each neighbor.
+	// Neighbors are the nodes this node sends to, and every link must have
+	// a matching one back unless it is marked OneWay.
+	Links map[ProcessID]Link
+	// The autonomous system the node belongs to, for policy routing.
+	AS ASNumber
+}
+
+type ASNumber int
+
+func (as ASNumber) String() string {
+	return fmt.Sprintf("AS%d", int(as))
+}
+
+type Link struct {
+	// Cost of using the link, for routing. Defaults to 1.
+	Weight int
+	// How long a message takes to cross the link.
+	Latency time.Duration
+	// The neighbor cannot send back to this node.
+	OneWay bool
+}
+
+func (n TopologyNode) Weight(pid ProcessID) int {
+	if link, ok := n.Links[pid]; ok && link.Weight > 0 {
+		return link.Weight
+	}
+	return 1
 }
 
 type Topology map[ProcessID]TopologyNode
 
+// The nodes id can send to.
+func (t Topology) OutNeighbors(id ProcessID) map[ProcessID]struct{} {
+	out := make(map[ProcessID]struct{}, len(t[id].Neighbors))
+	for neighbor := range t[id].Neighbors {
+		if neighbor != id {
+			out[neighbor] = struct{}{}
+		}
+	}
+	return out
+}
+
+// The nodes that can send to id.
+func (t Topology) InNeighbors(id ProcessID) map[ProcessID]struct{} {
+	in := make(map[ProcessID]struct{})
+	for other, node := range t {
+		if _, ok := node.Neighbors[id]; ok && other != id {
+			in[other] = struct{}{}
+		}
+	}
+	return in
+}
+
 const (
 	directChannelBuffer = 1000
 )
 
 func CreateCluster(topo Topology) Cluster {
+	if err := CheckTopology(topo); err != nil {
+		panic(err.Error())
+	}
 	c := make(Cluster, len(topo))
 	for id, topoNode := range topo {
-		if id != topoNode.Subprocess.Id() {
-			panic(fmt.Sprintf("invalid topology: %s has subprocess %s", id, topoNode.Subprocess.Id()))
-		}
-		inChan := make(chan RoutedMessage, directChannelBuffer)
-		c[id] = &DirectConnectedProcess{
-			P: topoNode.Subprocess,
-			InChan: inChan,
-		}
+		c[id] = newDirectConnectedProcess(topoNode)
 	}
 	for id, topoNode := range topo {
-		outChans := make(map[ProcessID]chan RoutedMessage, len(topoNode.Neighbors))
 		for pid := range topoNode.Neighbors {
 			if id == pid {
 				// Skip links to self.
 				continue
 			}
-			outChans[pid] = c[pid].InChan
+			c.addLink(id, pid, topoNode.Links[pid], false)
 		}
-		c[id].OutChans = outChans
+	}
+	for _, p := range c {
+		p.setInitialNeighbors()
 	}
 	return c
 }
@@ -218,6 +526,52 @@ type Scenario interface {
 	Network() Topology
 }
 
+// Implemented by scenarios that act on the cluster while it runs,
+// for example to cut links. Script runs in its own goroutine.
+type ScriptedScenario interface {
+	Scenario
+	Script(c Cluster)
+}
+
+// Implemented by scenarios that can tell whether a run went as expected,
+// for example whether a conversation completed.
+// Check runs after the cluster has stopped.
+type CheckedScenario interface {
+	Scenario
+	Check(c Cluster) error
+}
+
+// A property that must hold throughout a run, such as there never
+// being two leaders.
+type Invariant struct {
+	Name string
+	Check func(c Cluster) error
+}
+
+// Implemented by scenarios with invariants. A Simulation checks them
+// after every step; RunScenarioFor can't, as its processes step concurrently.
+type InvariantScenario interface {
+	Scenario
+	Invariants() []Invariant
+}
+
 func RunScenario(scenario Scenario) {
-	CreateCluster(scenario.Network()).RunTillDone()
+	RunScenarioFor(scenario, 0)
+}
+
+// Runs the scenario for duration, or forever if duration is 0,
+// and returns the stopped cluster.
+func RunScenarioFor(scenario Scenario, duration time.Duration) Cluster {
+	c := CreateCluster(scenario.Network())
+	if scripted, ok := scenario.(ScriptedScenario); ok {
+		go scripted.Script(c)
+	}
+	if duration > 0 {
+		go func() {
+			time.Sleep(duration)
+			c.Stop()
+		}()
+	}
+	c.RunTillDone()
+	return c
 }
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A BGP-like alternative to BellmanFordProcess.
+// Advertisements carry the whole path to the destination, so a node can
+// reject any path that already contains it, and a RoutingPolicy decides
+// which paths a node accepts, which it prefers, and which it passes on.
+// Inner messages are forwarded exactly as BellmanFordProcess does.
+type PathVectorProcess struct {
+	Process
+	Policy RoutingPolicy
+	neighbors map[ProcessID]struct{}
+	// The latest path each neighbor advertised to each destination.
+	// Paths start at the neighbor and end at the destination.
+	advertisedToUs map[ProcessID]map[ProcessID][]ProcessID
+	// The chosen path to each destination, starting at this node.
+	best map[ProcessID][]ProcessID
+	// Destinations we have advertised to each neighbor and not withdrawn.
+	advertisedByUs map[ProcessID]map[ProcessID]struct{}
+	hasStarted bool
+	deliveryQueue chan RoutedMessage
+	steps int
+	stats RoutingStats
+}
+
+// Decides which paths a PathVectorProcess accepts, prefers and passes on.
+// Paths start at the node applying the policy and end at the destination.
+type RoutingPolicy interface {
+	// Preference of a path learned from a neighbor; higher is better.
+	// Paths that aren't ok are rejected.
+	Import(path []ProcessID) (preference int, ok bool)
+	// Whether to advertise a path to a neighbor.
+	Export(path []ProcessID, to ProcessID) bool
+}
+
+type PathVectorUpdateMessage struct {
+	Dest ProcessID
+	// From the sender to Dest. Empty when withdrawing.
+	Path []ProcessID
+	Withdraw bool
+}
+
+func (m PathVectorUpdateMessage) String() string {
+	if m.Withdraw {
+		return fmt.Sprintf("WITHDRAW(%s)", m.Dest)
+	}
+	return fmt.Sprintf("PATH(%s: %v)", m.Dest, m.Path)
+}
+
+func (m PathVectorUpdateMessage) MessageTag() string {
+	return "path-vector"
+}
+
+// Checksummed, as BGP is by TCP, so corrupted updates are discarded.
+func (m PathVectorUpdateMessage) Encode(e *Encoder) {
+	e.Checksummed(func() {
+		e.WriteInt(int(m.Dest))
+		withdraw := 0
+		if m.Withdraw {
+			withdraw = 1
+		}
+		e.WriteInt(withdraw)
+		e.WriteInt(len(m.Path))
+		for _, id := range m.Path {
+			e.WriteInt(int(id))
+		}
+	})
+}
+
+func init() {
+	RegisterMessageType("path-vector", func(d *Decoder) Message {
+		m := PathVectorUpdateMessage{}
+		m.Dest = ProcessID(d.ReadInt())
+		m.Withdraw = d.ReadInt() != 0
+		n := d.ReadInt()
+		if n < 0 || n > len(d.buf) {
+			d.fail(fmt.Errorf("path length %d out of range", n))
+			return nil
+		}
+		for i := 0; i < n; i++ {
+			m.Path = append(m.Path, ProcessID(d.ReadInt()))
+		}
+		return m
+	})
+}
+
+// Routes every node of topo with a PathVectorProcess applying policy.
+func WithPathVectorRouting(topo Topology, policy RoutingPolicy) Topology {
+	routed := make(Topology, len(topo))
+	for id, node := range topo {
+		node.Subprocess = newPathVectorProcess(
+			&MultiTCPProcess{Process: node.Subprocess}, node.Neighbors, policy,
+		)
+		routed[id] = node
+	}
+	return routed
+}
+
+// Routes for inner, which is usually the transport layer.
+func newPathVectorProcess(
+	inner Process,
+	neighbors map[ProcessID]struct{},
+	policy RoutingPolicy,
+) *PathVectorProcess {
+	ownNeighbors := make(map[ProcessID]struct{}, len(neighbors))
+	for neighbor := range neighbors {
+		if neighbor != inner.Id() {
+			ownNeighbors[neighbor] = struct{}{}
+		}
+	}
+	return &PathVectorProcess{
+		Process: inner,
+		Policy: policy,
+		neighbors: ownNeighbors,
+		advertisedToUs: make(map[ProcessID]map[ProcessID][]ProcessID),
+		best: map[ProcessID][]ProcessID{
+			inner.Id(): []ProcessID{inner.Id()},
+		},
+		advertisedByUs: make(map[ProcessID]map[ProcessID]struct{}),
+		deliveryQueue: make(chan RoutedMessage, innerDeliveryQueueSize),
+	}
+}
+
+// Hops along the chosen path to each reachable destination.
+func (p *PathVectorProcess) Distances() map[ProcessID]int {
+	distances := make(map[ProcessID]int, len(p.best))
+	for dest, path := range p.best {
+		distances[dest] = len(path) - 1
+	}
+	return distances
+}
+
+func (p *PathVectorProcess) RoutingStats() RoutingStats {
+	return p.stats
+}
+
+// The chosen path to dest, starting at this node.
+func (p *PathVectorProcess) Path(dest ProcessID) ([]ProcessID, bool) {
+	path, ok := p.best[dest]
+	return path, ok
+}
+
+func (p *PathVectorProcess) nextHop(dest ProcessID) (ProcessID, bool) {
+	path, ok := p.best[dest]
+	if !ok {
+		return 0, false
+	}
+	if len(path) == 1 {
+		return dest, true
+	}
+	return path[1], true
+}
+
+func containsProcess(path []ProcessID, id ProcessID) bool {
+	for _, pathID := range path {
+		if pathID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func samePath(a []ProcessID, b []ProcessID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Pick the most preferred path to dest among those our neighbors offer.
+// Returns whether the choice changed.
+func (p *PathVectorProcess) decide(dest ProcessID) bool {
+	if dest == p.Id() {
+		return false
+	}
+	var best []ProcessID
+	bestPreference := 0
+	for neighbor, paths := range p.advertisedToUs {
+		advertised, ok := paths[dest]
+		if !ok {
+			continue
+		}
+		path := append([]ProcessID{p.Id()}, advertised...)
+		preference, ok := p.Policy.Import(path)
+		if !ok {
+			continue
+		}
+		if best == nil || preference > bestPreference ||
+			(preference == bestPreference && neighbor < best[1]) {
+			best, bestPreference = path, preference
+		}
+	}
+	if samePath(best, p.best[dest]) {
+		return false
+	}
+	if best == nil {
+		delete(p.best, dest)
+		Log(p, fmt.Sprintf("no route to %s", dest))
+	} else {
+		p.best[dest] = best
+		Log(p, fmt.Sprintf("route to %s: %v", dest, best))
+	}
+	p.stats.LastRouteChange = p.steps
+	return true
+}
+
+// Tell every neighbor our choice for dest, as far as policy allows.
+func (p *PathVectorProcess) advertise(dest ProcessID, send func(RoutedMessage)) {
+	path, hasPath := p.best[dest]
+	for _, neighbor := range sortedSet(p.neighbors) {
+		advertised := p.advertisedByUs[neighbor]
+		if advertised == nil {
+			advertised = make(map[ProcessID]struct{})
+			p.advertisedByUs[neighbor] = advertised
+		}
+		var update PathVectorUpdateMessage
+		if hasPath && !containsProcess(path, neighbor) && p.Policy.Export(path, neighbor) {
+			update = PathVectorUpdateMessage{Dest: dest, Path: path}
+			advertised[dest] = struct{}{}
+		} else if _, ok := advertised[dest]; ok {
+			update = PathVectorUpdateMessage{Dest: dest, Withdraw: true}
+			delete(advertised, dest)
+		} else {
+			continue
+		}
+		send(RoutedMessage{
+			Message: update,
+			From: p.Id(),
+			To: neighbor,
+		})
+		p.stats.ControlMessages += 1
+	}
+}
+
+func (p *PathVectorProcess) update(
+	m PathVectorUpdateMessage,
+	from ProcessID,
+	send func(RoutedMessage),
+) {
+	paths := p.advertisedToUs[from]
+	if paths == nil {
+		paths = make(map[ProcessID][]ProcessID)
+		p.advertisedToUs[from] = paths
+	}
+	if m.Withdraw || containsProcess(m.Path, p.Id()) {
+		// Paths through us would be loops.
+		delete(paths, m.Dest)
+	} else {
+		paths[m.Dest] = m.Path
+	}
+	if p.decide(m.Dest) {
+		p.advertise(m.Dest, send)
+	}
+}
+
+func (p *PathVectorProcess) innerStep(send func(RoutedMessage)) {
+	if p.Process == nil {
+		return
+	}
+	p.Process.Step(
+		func(m RoutedMessage) {
+			if nextHop, ok := p.nextHop(m.To); ok {
+				send(RoutedMessage{
+					Message: m,
+					From: p.Id(),
+					To: nextHop,
+				})
+			} else {
+				Log(p, fmt.Sprintf("unable to deliver message %v", m))
+			}
+		},
+		func() *RoutedMessage {
+			select {
+			case received := <-p.deliveryQueue:
+				return &received
+			default:
+				return nil
+			}
+		},
+	)
+}
+
+func (p *PathVectorProcess) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	defer p.innerStep(send)
+	p.steps += 1
+	if !p.hasStarted {
+		p.advertise(p.Id(), send)
+		p.hasStarted = true
+	}
+	received := receive()
+	if received == nil {
+		return
+	}
+	messageReceived := received.Message
+	if updateMessage, ok := messageReceived.(PathVectorUpdateMessage); ok {
+		p.update(updateMessage, received.From, send)
+	} else if innerMessage, ok := messageReceived.(RoutedMessage); ok {
+		if innerMessage.To == p.Id() {
+			select {
+			case p.deliveryQueue <- innerMessage:
+				// delivered
+			default:
+				// queue full; drop the message
+			}
+		} else {
+			// pass it on, if we know the route
+			if nextHop, ok := p.nextHop(innerMessage.To); ok {
+				send(RoutedMessage{
+					Message: innerMessage,
+					From: p.Id(),
+					To: nextHop,
+				})
+			} else {
+				// Otherwise, drop it.
+				Log(p, fmt.Sprintf("unable to deliver message %v", innerMessage))
+			}
+		}
+	} else {
+		panic(fmt.Sprintf(
+			"path vector expected either " +
+			"PathVectorUpdateMessage or RoutedMessage",
+		))
+	}
+}
+
+// What a neighboring AS is to ours.
+type ASRelationship int
+
+const (
+	// The same AS, or a path that never leaves it.
+	Internal ASRelationship = iota
+	// They pay us for transit.
+	Customer
+	// We carry each other's customers' traffic for free.
+	Peer
+	// We pay them for transit.
+	Provider
+)
+
+func (r ASRelationship) String() string {
+	switch r {
+	case Internal:
+		return "internal"
+	case Customer:
+		return "customer"
+	case Peer:
+		return "peer"
+	case Provider:
+		return "provider"
+	}
+	return fmt.Sprintf("relationship:%d", int(r))
+}
+
+// Relationships between ASes: ASRelationships[a][b] is what b is to a.
+type ASRelationships map[ASNumber]map[ASNumber]ASRelationship
+
+// Declares that customer pays provider for transit.
+func (r ASRelationships) AddCustomer(provider ASNumber, customer ASNumber) {
+	r.add(provider, customer, Customer)
+	r.add(customer, provider, Provider)
+}
+
+func (r ASRelationships) AddPeers(a ASNumber, b ASNumber) {
+	r.add(a, b, Peer)
+	r.add(b, a, Peer)
+}
+
+func (r ASRelationships) add(a ASNumber, b ASNumber, relationship ASRelationship) {
+	if r[a] == nil {
+		r[a] = make(map[ASNumber]ASRelationship)
+	}
+	r[a][b] = relationship
+}
+
+// The AS of every node in the topology.
+func ASGroups(topo Topology) map[ProcessID]ASNumber {
+	groups := make(map[ProcessID]ASNumber, len(topo))
+	for id, node := range topo {
+		groups[id] = node.AS
+	}
+	return groups
+}
+
+// The Gao-Rexford conditions, which guarantee convergence:
+// prefer paths through customers over peers over providers,
+// and never carry traffic from one peer or provider to another,
+// so no path goes down into a customer and back up ("no valley").
+type GaoRexfordPolicy struct {
+	AS map[ProcessID]ASNumber
+	Relationships ASRelationships
+}
+
+// What the path's first hop outside our AS is to us.
+func (g GaoRexfordPolicy) class(path []ProcessID) ASRelationship {
+	ourAS := g.AS[path[0]]
+	for _, id := range path[1:] {
+		if g.AS[id] != ourAS {
+			return g.relationship(ourAS, g.AS[id])
+		}
+	}
+	return Internal
+}
+
+func (g GaoRexfordPolicy) relationship(ours ASNumber, theirs ASNumber) ASRelationship {
+	if ours == theirs {
+		return Internal
+	}
+	relationship, ok := g.Relationships[ours][theirs]
+	if !ok {
+		panic(fmt.Sprintf("no relationship declared between %s and %s", ours, theirs))
+	}
+	return relationship
+}
+
+func (g GaoRexfordPolicy) Import(path []ProcessID) (int, bool) {
+	var rank int
+	switch g.class(path) {
+	case Internal:
+		rank = 3
+	case Customer:
+		rank = 2
+	case Peer:
+		rank = 1
+	}
+	// Then prefer shorter paths.
+	return rank*len(g.AS) - len(path), true
+}
+
+func (g GaoRexfordPolicy) Export(path []ProcessID, to ProcessID) bool {
+	ourAS := g.AS[path[0]]
+	switch g.relationship(ourAS, g.AS[to]) {
+	case Internal, Customer:
+		return true
+	}
+	switch g.class(path) {
+	case Internal, Customer:
+		return true
+	}
+	return false
+}
+
+// Whether traffic along path climbs from customers to providers, crosses
+// at most one peering, then only descends, as Export lets it.
+func (g GaoRexfordPolicy) ValleyFree(path []ProcessID) bool {
+	descending := false
+	for i := 1; i < len(path); i++ {
+		switch g.relationship(g.AS[path[i-1]], g.AS[path[i]]) {
+		case Provider:
+			if descending {
+				return false
+			}
+		case Peer:
+			if descending {
+				return false
+			}
+			descending = true
+		case Customer:
+			descending = true
+		}
+	}
+	return true
+}
+
+// Each node ranks the paths it will accept to some destinations, most
+// preferred first; paths to other destinations are ranked by length.
+// Arbitrary rankings like this can keep routing from ever converging.
+type PathRankingPolicy map[ProcessID][][]ProcessID
+
+func (r PathRankingPolicy) Import(path []ProcessID) (int, bool) {
+	dest := path[len(path)-1]
+	rankedDest := false
+	for i, ranked := range r[path[0]] {
+		if ranked[len(ranked)-1] != dest {
+			continue
+		}
+		rankedDest = true
+		if samePath(ranked, path) {
+			return -i, true
+		}
+	}
+	if rankedDest {
+		return 0, false
+	}
+	return -len(path), true
+}
+
+func (r PathRankingPolicy) Export(path []ProcessID, to ProcessID) bool {
+	return true
+}
+
+// Griffin, Shepherd and Wilfong's BAD GADGET: 1, 2 and 3 each prefer to
+// reach 0 through their clockwise neighbor rather than directly, so there is
+// no stable choice and routes to 0 oscillate forever.
+// With Good set, everyone prefers the direct path and routing converges.
+type BadGadgetScenario struct {
+	Good bool
+}
+
+func (s BadGadgetScenario) Network() Topology {
+	//      0
+	//    / | \
+	//   1--2--3
+	//   \_____/
+	policy := PathRankingPolicy{
+		1: {{1, 2, 0}, {1, 0}},
+		2: {{2, 3, 0}, {2, 0}},
+		3: {{3, 1, 0}, {3, 0}},
+	}
+	if s.Good {
+		policy = PathRankingPolicy{
+			1: {{1, 0}, {1, 2, 0}},
+			2: {{2, 0}, {2, 3, 0}},
+			3: {{3, 0}, {3, 1, 0}},
+		}
+	}
+	return WithPathVectorRouting(Topology{
+		0: TopologyNode{
+			Subprocess: SimpleProcess{ID: 0},
+			Neighbors: map[ProcessID]struct{}{1: {}, 2: {}, 3: {}},
+			AS: 0,
+		},
+		1: TopologyNode{
+			Subprocess: SimpleProcess{ID: 1},
+			Neighbors: map[ProcessID]struct{}{0: {}, 2: {}, 3: {}},
+			AS: 1,
+		},
+		2: TopologyNode{
+			Subprocess: SimpleProcess{ID: 2},
+			Neighbors: map[ProcessID]struct{}{0: {}, 1: {}, 3: {}},
+			AS: 2,
+		},
+		3: TopologyNode{
+			Subprocess: SimpleProcess{ID: 3},
+			Neighbors: map[ProcessID]struct{}{0: {}, 1: {}, 2: {}},
+			AS: 3,
+		},
+	}, policy)
+}
+
+// A small internet under Gao-Rexford policies.
+// AS 1 is a transit provider made of nodes 1 and 2. ASes 2 and 3 are its
+// customers and peer with each other, and AS 4 is a customer of both.
+// Node 5 is multihomed, but never carries traffic between its providers,
+// so 3 reaches 4 over the peering link rather than through 5.
+type ValleyFreeScenario struct{}
+
+func pathVectorRouters(c Cluster) map[ProcessID]*PathVectorProcess {
+	routers := make(map[ProcessID]*PathVectorProcess)
+	for _, p := range clusterLayers(c) {
+		if router, ok := p.(*PathVectorProcess); ok {
+			routers[router.Id()] = router
+		}
+	}
+	return routers
+}
+
+// 1, 2 and 3 still change their routes to 0 in the last tenth of the
+// run, or, with Good set, each settled on its direct path.
+func (s BadGadgetScenario) Check(c Cluster) error {
+	routers := pathVectorRouters(c)
+	steps, lastChange := 0, 0
+	for _, id := range []ProcessID{1, 2, 3} {
+		router := routers[id]
+		if s.Good {
+			if path, ok := router.Path(0); !ok || !samePath(path, []ProcessID{id, 0}) {
+				return fmt.Errorf("%s reaches %s by %v, not directly", id, ProcessID(0), path)
+			}
+			continue
+		}
+		if router.steps > steps {
+			steps = router.steps
+		}
+		if router.stats.LastRouteChange > lastChange {
+			lastChange = router.stats.LastRouteChange
+		}
+	}
+	if !s.Good && lastChange <= steps-steps/10 {
+		return fmt.Errorf("routes stopped changing at step %d of %d", lastChange, steps)
+	}
+	return nil
+}
+
+func (s ValleyFreeScenario) Network() Topology {
+	//    AS1: 1 --- 2
+	//         |     |
+	//    AS2: 3 --- 4 :AS3
+	//          \   /
+	//       AS4: 5
+	relationships := ASRelationships{}
+	relationships.AddCustomer(1, 2)
+	relationships.AddCustomer(1, 3)
+	relationships.AddPeers(2, 3)
+	relationships.AddCustomer(2, 4)
+	relationships.AddCustomer(3, 4)
+	topo := Topology{
+		1: TopologyNode{
+			Subprocess: SimpleProcess{ID: 1},
+			Neighbors: map[ProcessID]struct{}{2: {}, 3: {}},
+			AS: 1,
+		},
+		2: TopologyNode{
+			Subprocess: SimpleProcess{ID: 2},
+			Neighbors: map[ProcessID]struct{}{1: {}, 4: {}},
+			AS: 1,
+		},
+		3: TopologyNode{
+			Subprocess: SimpleProcess{ID: 3},
+			Neighbors: map[ProcessID]struct{}{1: {}, 4: {}, 5: {}},
+			AS: 2,
+		},
+		4: TopologyNode{
+			Subprocess: SimpleProcess{ID: 4},
+			Neighbors: map[ProcessID]struct{}{2: {}, 3: {}, 5: {}},
+			AS: 3,
+		},
+		5: TopologyNode{
+			Subprocess: SimpleProcess{ID: 5},
+			Neighbors: map[ProcessID]struct{}{3: {}, 4: {}},
+			AS: 4,
+		},
+	}
+	return WithPathVectorRouting(topo, GaoRexfordPolicy{
+		AS: ASGroups(topo),
+		Relationships: relationships,
+	})
+}
+
+// Every node reaches every other by a valley-free path, and 3 reaches 4
+// over the peering link rather than through their customer 5.
+func (s ValleyFreeScenario) Check(c Cluster) error {
+	routers := pathVectorRouters(c)
+	for _, id := range sortedIDs(c.CurrentTopology()) {
+		router := routers[id]
+		policy := router.Policy.(GaoRexfordPolicy)
+		for dest := range routers {
+			path, ok := router.Path(dest)
+			if !ok {
+				return fmt.Errorf("%s has no route to %s", id, dest)
+			}
+			if !policy.ValleyFree(path) {
+				return fmt.Errorf("%s reaches %s through a valley: %v", id, dest, path)
+			}
+		}
+	}
+	if path, _ := routers[3].Path(4); !samePath(path, []ProcessID{3, 4}) {
+		return fmt.Errorf("%s reaches %s by %v, not over their peering link", ProcessID(3), ProcessID(4), path)
+	}
+	return nil
+}
+
+func init() {
+	RegisterScenario("bad-gadget", "path-vector policies that never converge", func(ScenarioParams) Scenario {
+		return BadGadgetScenario{}
+	})
+	RegisterScenario("good-gadget", "bad-gadget with policies that converge", func(ScenarioParams) Scenario {
+		return BadGadgetScenario{Good: true}
+	})
+	RegisterScenario("valley-free", "Gao-Rexford policies over customer, peer and provider ASes", func(ScenarioParams) Scenario {
+		return ValleyFreeScenario{}
+	})
+}
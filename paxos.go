@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Single-decree Paxos. Proposers each make one attempt, with a ballot
+// no other proposer uses, to get a majority of acceptors to accept
+// their value. In the first phase a proposer asks acceptors to promise
+// to ignore lower ballots and to report what they have already accepted;
+// in the second it proposes the value accepted at the highest ballot
+// reported, or its own if there is none. The proposer learns its value
+// is chosen when a majority accepts it.
+//
+// With SkipPrepare a proposer goes straight to the second phase with its
+// own value, which lets two proposers both see different values chosen.
+// ModelCheck finds the interleaving that does it.
+
+type PaxosPhase int
+
+const (
+	PaxosPrepare PaxosPhase = iota
+	PaxosPromise
+	PaxosAccept
+	PaxosAccepted
+)
+
+func (phase PaxosPhase) String() string {
+	return [...]string{"PREPARE", "PROMISE", "ACCEPT", "ACCEPTED"}[phase]
+}
+
+type PaxosMessage struct {
+	Phase PaxosPhase
+	Ballot int
+	// The value to accept, or in a promise the value already accepted.
+	Value string
+	// In a promise, the ballot Value was accepted at, or 0.
+	AcceptedBallot int
+}
+
+func (m PaxosMessage) String() string {
+	switch m.Phase {
+	case PaxosPromise:
+		return fmt.Sprintf("%s(%d, accepted %q at %d)", m.Phase, m.Ballot, m.Value, m.AcceptedBallot)
+	case PaxosAccept:
+		return fmt.Sprintf("%s(%d, %q)", m.Phase, m.Ballot, m.Value)
+	}
+	return fmt.Sprintf("%s(%d)", m.Phase, m.Ballot)
+}
+
+func (m PaxosMessage) MessageTag() string {
+	return "paxos"
+}
+
+func (m PaxosMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.Phase))
+	e.WriteInt(m.Ballot)
+	e.WriteString(m.Value)
+	e.WriteInt(m.AcceptedBallot)
+}
+
+func init() {
+	RegisterMessageType("paxos", func(d *Decoder) Message {
+		m := PaxosMessage{}
+		m.Phase = PaxosPhase(d.ReadInt())
+		m.Ballot = d.ReadInt()
+		m.Value = d.ReadString()
+		m.AcceptedBallot = d.ReadInt()
+		return m
+	})
+}
+
+type PaxosProposer struct {
+	ID ProcessID
+	// Must be positive and differ from every other proposer's.
+	Ballot int
+	Value string
+	Acceptors []ProcessID
+	SkipPrepare bool
+	Decided bool
+	DecidedValue string
+	hasStarted bool
+	promises map[ProcessID]struct{}
+	// The highest ballot reported accepted in promises, and its value.
+	highestAccepted int
+	proposal string
+	accepts map[ProcessID]struct{}
+}
+
+func (p *PaxosProposer) Id() ProcessID {
+	return p.ID
+}
+
+func (p *PaxosProposer) broadcast(send func(RoutedMessage), m PaxosMessage) {
+	for _, acceptor := range p.Acceptors {
+		send(RoutedMessage{Message: m, From: p.ID, To: acceptor})
+	}
+}
+
+func (p *PaxosProposer) majority() int {
+	return len(p.Acceptors)/2 + 1
+}
+
+func (p *PaxosProposer) propose(send func(RoutedMessage)) {
+	p.accepts = make(map[ProcessID]struct{})
+	p.broadcast(send, PaxosMessage{Phase: PaxosAccept, Ballot: p.Ballot, Value: p.proposal})
+}
+
+func (p *PaxosProposer) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	if !p.hasStarted {
+		p.hasStarted = true
+		p.proposal = p.Value
+		if p.SkipPrepare {
+			p.propose(send)
+		} else {
+			p.promises = make(map[ProcessID]struct{})
+			p.broadcast(send, PaxosMessage{Phase: PaxosPrepare, Ballot: p.Ballot})
+		}
+	}
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(PaxosMessage)
+		if !ok {
+			panic(fmt.Sprintf("paxos proposer unexpected message type %T %s", received.Message, received.Message))
+		}
+		if m.Ballot != p.Ballot {
+			continue
+		}
+		switch m.Phase {
+		case PaxosPromise:
+			if p.promises == nil || p.accepts != nil {
+				// Not preparing, or already proposing.
+				continue
+			}
+			p.promises[received.From] = struct{}{}
+			if m.AcceptedBallot > p.highestAccepted {
+				p.highestAccepted = m.AcceptedBallot
+				p.proposal = m.Value
+			}
+			if len(p.promises) >= p.majority() {
+				p.propose(send)
+			}
+		case PaxosAccepted:
+			if p.accepts == nil || p.Decided {
+				continue
+			}
+			p.accepts[received.From] = struct{}{}
+			if len(p.accepts) >= p.majority() {
+				p.Decided = true
+				p.DecidedValue = p.proposal
+				Log(p, fmt.Sprintf("%q is chosen", p.DecidedValue))
+			}
+		}
+	}
+}
+
+type PaxosAcceptor struct {
+	ID ProcessID
+	// The highest ballot seen; lower ones are ignored.
+	Promised int
+	AcceptedBallot int
+	AcceptedValue string
+}
+
+func (p *PaxosAcceptor) Id() ProcessID {
+	return p.ID
+}
+
+func (p *PaxosAcceptor) Step(
+	send func(RoutedMessage),
+	receive func() *RoutedMessage,
+) {
+	for received := receive(); received != nil; received = receive() {
+		m, ok := received.Message.(PaxosMessage)
+		if !ok {
+			panic(fmt.Sprintf("paxos acceptor unexpected message type %T %s", received.Message, received.Message))
+		}
+		reply := func(m PaxosMessage) {
+			send(RoutedMessage{Message: m, From: p.ID, To: received.From})
+		}
+		switch m.Phase {
+		case PaxosPrepare:
+			if m.Ballot <= p.Promised {
+				continue
+			}
+			p.Promised = m.Ballot
+			reply(PaxosMessage{
+				Phase: PaxosPromise,
+				Ballot: m.Ballot,
+				Value: p.AcceptedValue,
+				AcceptedBallot: p.AcceptedBallot,
+			})
+		case PaxosAccept:
+			if m.Ballot < p.Promised {
+				continue
+			}
+			p.Promised = m.Ballot
+			p.AcceptedBallot = m.Ballot
+			p.AcceptedValue = m.Value
+			reply(PaxosMessage{Phase: PaxosAccepted, Ballot: m.Ballot})
+		}
+	}
+}
+
+// Whether every proposer that has seen a value chosen saw the same one.
+func paxosAgreement(c Cluster) error {
+	var first *PaxosProposer
+	for _, p := range clusterLayers(c) {
+		proposer, ok := p.(*PaxosProposer)
+		if !ok || !proposer.Decided {
+			continue
+		}
+		if first == nil {
+			first = proposer
+			continue
+		}
+		if proposer.DecidedValue != first.DecidedValue {
+			return fmt.Errorf(
+				"%s saw %q chosen but %s saw %q",
+				first.ID, first.DecidedValue, proposer.ID, proposer.DecidedValue,
+			)
+		}
+	}
+	return nil
+}
+
+// Two proposers with different values, 0 and 1, and three acceptors,
+// 2 to 4, all linked to each other.
+type PaxosScenario struct {
+	SkipPrepare bool
+}
+
+func (s PaxosScenario) Network() Topology {
+	acceptors := []ProcessID{2, 3, 4}
+	processes := []Process{
+		&PaxosProposer{ID: 0, Ballot: 1, Value: "apple", Acceptors: acceptors, SkipPrepare: s.SkipPrepare},
+		&PaxosProposer{ID: 1, Ballot: 2, Value: "banana", Acceptors: acceptors, SkipPrepare: s.SkipPrepare},
+	}
+	for _, id := range acceptors {
+		processes = append(processes, &PaxosAcceptor{ID: id})
+	}
+	return CompleteTopology(processes)
+}
+
+func (s PaxosScenario) Invariants() []Invariant {
+	return []Invariant{{Name: "one value chosen", Check: paxosAgreement}}
+}
+
+func (s PaxosScenario) Check(c Cluster) error {
+	return paxosAgreement(c)
+}
+
+func init() {
+	RegisterScenario("paxos", "two Paxos proposers competing for three acceptors", func(ScenarioParams) Scenario {
+		return PaxosScenario{}
+	})
+	RegisterScenario("paxos-single-phase", "paxos without the prepare phase, which can choose two values", func(ScenarioParams) Scenario {
+		return PaxosScenario{SkipPrepare: true}
+	})
+}
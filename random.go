@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Every random choice the simulation makes, from link faults to random
+// processes, draws from simRand, so SeedSimulation can repeat a run's draws.
+// It also restarts the numbering of random messages.
+// Goroutine scheduling still varies between runs.
+var simSource = &lockedSource{source: rand.NewSource(1).(rand.Source64), seed: 1}
+var simRand = rand.New(simSource)
+
+func SeedSimulation(seed int64) {
+	simRand.Seed(seed)
+	incrementingContentMutex.Lock()
+	defer incrementingContentMutex.Unlock()
+	incrementingContent = 0
+}
+
+// Processes draw from their own goroutines.
+type lockedSource struct {
+	mutex sync.Mutex
+	source rand.Source64
+	// Seeding is slow, and model checking reseeds for every replay,
+	// so it is skipped when nothing was drawn since the same seed.
+	seed int64
+	drawn bool
+	// Every draw is written down while recording, and taken from
+	// replay instead of the source while replaying.
+	recording bool
+	recorded []uint64
+	replaying bool
+	replay []uint64
+}
+
+// Int63 masks Uint64 as the math/rand source does, so that
+// recording only Uint64 draws is enough.
+func (s *lockedSource) Int63() int64 {
+	return int64(s.Uint64() & (1<<63 - 1))
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.drawn = true
+	if s.replaying {
+		if len(s.replay) == 0 {
+			panic(&ReplayDivergedError{"more random draws than recorded"})
+		}
+		v := s.replay[0]
+		s.replay = s.replay[1:]
+		return v
+	}
+	v := s.source.Uint64()
+	if s.recording {
+		s.recorded = append(s.recorded, v)
+	}
+	return v
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.drawn && seed == s.seed {
+		return
+	}
+	s.source.Seed(seed)
+	s.seed = seed
+	s.drawn = false
+}
+
+// Writes down every draw from now on.
+func (s *lockedSource) startRecording() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recording = true
+	s.recorded = nil
+}
+
+func (s *lockedSource) stopRecording() []uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recording = false
+	return s.recorded
+}
+
+// Draws draws, in order, from now on.
+func (s *lockedSource) startReplay(draws []uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replaying = true
+	s.replay = draws
+}
+
+// Returns how many draws were left over.
+func (s *lockedSource) stopReplay() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replaying = false
+	left := len(s.replay)
+	s.replay = nil
+	return left
+}
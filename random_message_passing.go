@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"sync"
 )
 
@@ -38,7 +37,7 @@ type RandomProcess struct {
 func (p *RandomProcess) PickNeighbor() ProcessID {
 	nbr := p.Id()
 	for nbr == p.Id() {
-		nbr = ProcessID(rand.Intn(p.NeighborCount))
+		nbr = ProcessID(simRand.Intn(p.NeighborCount))
 	}
 	return nbr
 }
@@ -51,7 +50,7 @@ func (p *RandomProcess) Step(
 	send func(RoutedMessage),
 	receive func() *RoutedMessage,
 ) {
-	switch rand.Intn(4) {
+	switch simRand.Intn(4) {
 	case 1:
 		m := NewMessageWithContent(p.Id(), p.PickNeighbor())
 		send(m)
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// A run written down so that it can be repeated exactly, on another
+// machine too: the scenario, and either the order processes stepped in
+// each round of a Simulation along with every number drawn from simRand,
+// or the choices of a model checker or fuzzer trace. Saved as JSON.
+type RunRecord struct {
+	// A registered scenario and its parameters, or a scenario file's config.
+	Scenario string `json:"scenario,omitempty"`
+	Params ScenarioParams `json:"params"`
+	Config *ScenarioConfig `json:"config,omitempty"`
+	ConfigDir string `json:"config_dir,omitempty"`
+	// "simulation" or "trace".
+	Mode string `json:"mode"`
+	Duration string `json:"duration,omitempty"`
+	Seed int64 `json:"seed"`
+	Rounds [][]ProcessID `json:"rounds,omitempty"`
+	Draws []uint64 `json:"draws,omitempty"`
+	Choices []ChoiceRecord `json:"choices,omitempty"`
+	// The hash of every process when the run ended, and how the run
+	// ended, which a replay must match.
+	Final uint64 `json:"final"`
+	Result string `json:"result"`
+}
+
+type ChoiceRecord struct {
+	Kind string `json:"kind"`
+	Process ProcessID `json:"process"`
+	// For deliveries and drops, the message, and its hash to find it by.
+	Message string `json:"message,omitempty"`
+	Hash uint64 `json:"hash,omitempty"`
+}
+
+var choiceKindNames = []string{"step", "deliver", "drop", "crash", "duplicate"}
+
+// Returned when a replay does not do what the recording did, as when
+// the code or the scenario changed since.
+type ReplayDivergedError struct {
+	Reason string
+}
+
+func (e *ReplayDivergedError) Error() string {
+	return fmt.Sprintf("replay diverged from the recording: %s", e.Reason)
+}
+
+func resultString(err error) string {
+	if err == nil {
+		return "passed"
+	}
+	return err.Error()
+}
+
+// A hash of every process in the cluster, for telling runs apart.
+func clusterHash(c Cluster) uint64 {
+	ids := make([]ProcessID, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	sortProcessIDs(ids)
+	processes := make([]Process, len(ids))
+	for i, id := range ids {
+		processes[i] = c[id].P
+	}
+	return stateHash(struct {
+		IDs []ProcessID
+		Processes []Process
+	}{ids, processes})
+}
+
+func LoadRunRecord(path string) (*RunRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	record := &RunRecord{}
+	if err := decodeStrict(data, record); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return record, nil
+}
+
+func (r *RunRecord) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Notes which scenario the record was made from.
+func (r *RunRecord) SetScenario(name string, params ScenarioParams, scenario Scenario) {
+	if config, ok := scenario.(*ConfigScenario); ok {
+		r.Config = &config.Config
+		r.ConfigDir = config.Dir
+		return
+	}
+	r.Scenario = name
+	r.Params = params
+}
+
+// Builds the scenario the record was made from.
+func (r *RunRecord) NewScenario() (Scenario, error) {
+	if r.Config != nil {
+		scenario, err := NewConfigScenario(*r.Config, r.ConfigDir)
+		if err != nil {
+			return nil, err
+		}
+		return scenario, nil
+	}
+	return NewScenario(r.Scenario, r.Params)
+}
+
+// Simulates scenario as SimulateScenario does, recording the run.
+// The caller fills in which scenario it was.
+func RecordSimulation(scenario Scenario, duration time.Duration, seed int64) (*RunRecord, Cluster, error) {
+	s := newScenarioSimulation(scenario, seed)
+	s.recording = true
+	simSource.startRecording()
+	err := s.runScenario(scenario, duration)
+	record := &RunRecord{
+		Mode: "simulation",
+		Duration: duration.String(),
+		Seed: seed,
+		Rounds: s.recordedRounds,
+		Draws: simSource.stopRecording(),
+		Final: clusterHash(s.Cluster),
+		Result: resultString(err),
+	}
+	return record, s.Cluster, err
+}
+
+// Repeats whichever kind of run was recorded.
+func Replay(scenario Scenario, record *RunRecord) (Cluster, error) {
+	switch record.Mode {
+	case "simulation":
+		return ReplaySimulation(scenario, record)
+	case "trace":
+		return ReplayTrace(scenario, record)
+	}
+	return nil, fmt.Errorf("unknown mode %q", record.Mode)
+}
+
+// Repeats a recorded simulation of scenario, returning how the run
+// ended, or a *ReplayDivergedError if it strayed from the recording.
+func ReplaySimulation(scenario Scenario, record *RunRecord) (c Cluster, err error) {
+	duration, err := time.ParseDuration(record.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("duration: %s", err)
+	}
+	s := newScenarioSimulation(scenario, record.Seed)
+	s.replayRounds = record.Rounds
+	if s.replayRounds == nil {
+		s.replayRounds = [][]ProcessID{}
+	}
+	simSource.startReplay(record.Draws)
+	defer func() {
+		left := simSource.stopReplay()
+		if r := recover(); r != nil {
+			diverged, ok := r.(*ReplayDivergedError)
+			if !ok {
+				panic(r)
+			}
+			c, err = s.Cluster, diverged
+			return
+		}
+		if err != nil {
+			return
+		}
+		if left > 0 {
+			err = &ReplayDivergedError{fmt.Sprintf("%d recorded random draws were left over", left)}
+		}
+	}()
+	runErr := s.runScenario(scenario, duration)
+	if s.Rounds != len(record.Rounds) {
+		return s.Cluster, &ReplayDivergedError{fmt.Sprintf("ran %d rounds, not %d", s.Rounds, len(record.Rounds))}
+	}
+	if result := resultString(runErr); result != record.Result {
+		return s.Cluster, &ReplayDivergedError{fmt.Sprintf("the run ended with %q, not %q", result, record.Result)}
+	}
+	if clusterHash(s.Cluster) != record.Final {
+		return s.Cluster, &ReplayDivergedError{"the processes ended in different states"}
+	}
+	return s.Cluster, runErr
+}
+
+// Records a model checker or fuzzer trace, ending as err says.
+// The caller fills in which scenario it was.
+func RecordTrace(scenario Scenario, trace []Choice, err error) *RunRecord {
+	record := &RunRecord{Mode: "trace", Result: resultString(err)}
+	for _, choice := range trace {
+		r := ChoiceRecord{Kind: choiceKindNames[choice.Kind], Process: choice.Process, Hash: choice.messageHash}
+		if choice.Message != nil {
+			r.Message = choice.Message.String()
+		}
+		record.Choices = append(record.Choices, r)
+	}
+	record.Final = clusterHash(ReplayModelTrace(scenario, trace))
+	return record
+}
+
+// The recorded choices. Messages are known only by their hash.
+func (r *RunRecord) trace() ([]Choice, error) {
+	var trace []Choice
+	for i, c := range r.Choices {
+		choice := Choice{Kind: -1, Process: c.Process, messageHash: c.Hash}
+		for k, name := range choiceKindNames {
+			if name == c.Kind {
+				choice.Kind = ChoiceKind(k)
+			}
+		}
+		if choice.Kind < 0 {
+			return nil, fmt.Errorf("choice %d: unknown kind %q", i+1, c.Kind)
+		}
+		trace = append(trace, choice)
+	}
+	return trace, nil
+}
+
+// Repeats a recorded trace of scenario, checking its invariants at the
+// end, and returns the first broken, or a *ReplayDivergedError.
+func ReplayTrace(scenario Scenario, record *RunRecord) (Cluster, error) {
+	trace, err := record.trace()
+	if err != nil {
+		return nil, err
+	}
+	w := newModelWorld(scenario)
+	for i, choice := range trace {
+		if !w.tryApply(choice) {
+			return w.cluster, &ReplayDivergedError{fmt.Sprintf("choice %d, %s, cannot be made", i+1, choice)}
+		}
+	}
+	var invariants []Invariant
+	if withInvariants, ok := scenario.(InvariantScenario); ok {
+		invariants = withInvariants.Invariants()
+	}
+	err = w.check(invariants)
+	if result := resultString(err); result != record.Result {
+		return w.cluster, &ReplayDivergedError{fmt.Sprintf("the trace ended with %q, not %q", result, record.Result)}
+	}
+	if clusterHash(w.cluster) != record.Final {
+		return w.cluster, &ReplayDivergedError{"the processes ended in different states"}
+	}
+	return w.cluster, err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Saves record and loads it back, as sharing it would.
+func roundTrip(t *testing.T, record *RunRecord) *RunRecord {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.json")
+	if err := record.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRunRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestReplaySimulation(t *testing.T) {
+	for _, name := range []string{"lossy-routing", "random-lamport", "link-failure", "lossy-datagram"} {
+		params := DefaultScenarioParams()
+		scenario, err := NewScenario(name, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, _, runErr := RecordSimulation(scenario, 2*time.Second, 3)
+		record.SetScenario(name, params, scenario)
+		record = roundTrip(t, record)
+		replayed, err := record.NewScenario()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Replay(replayed, record)
+		if _, ok := err.(*ReplayDivergedError); ok {
+			t.Errorf("%s: %s", name, err)
+		} else if resultString(err) != resultString(runErr) {
+			t.Errorf("%s: replay %s, but the run %s", name, resultString(err), resultString(runErr))
+		}
+	}
+}
+
+func TestReplayConfig(t *testing.T) {
+	scenario, err := LoadScenarioConfig("scenarios/lossy_grid.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _, _ := RecordSimulation(scenario, time.Second, 1)
+	record.SetScenario("", ScenarioParams{}, scenario)
+	record = roundTrip(t, record)
+	replayed, err := record.NewScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Too short for the checks to pass, which the replay must repeat.
+	if _, err := Replay(replayed, record); err == nil || err.Error() != record.Result {
+		t.Errorf("replay ended with %v, not %s", err, record.Result)
+	}
+}
+
+func TestReplayDiverges(t *testing.T) {
+	scenario, err := NewScenario("lossy-routing", DefaultScenarioParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _, _ := RecordSimulation(scenario, time.Second, 1)
+	tests := map[string]func(r *RunRecord){
+		"draw changed": func(r *RunRecord) { r.Draws[0] ^= 1 << 62 },
+		"draws missing": func(r *RunRecord) { r.Draws = r.Draws[:len(r.Draws)/2] },
+		"draw added": func(r *RunRecord) { r.Draws = append(r.Draws, 1) },
+		"rounds missing": func(r *RunRecord) { r.Rounds = r.Rounds[:len(r.Rounds)-1] },
+		"order changed": func(r *RunRecord) {
+			order := r.Rounds[0]
+			order[0], order[len(order)-1] = order[len(order)-1], order[0]
+		},
+	}
+	for name, change := range tests {
+		tampered := roundTrip(t, record)
+		change(tampered)
+		if _, err := Replay(scenario, tampered); err == nil {
+			t.Errorf("%s: replay did not diverge", name)
+		} else if _, ok := err.(*ReplayDivergedError); !ok {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestReplayTrace(t *testing.T) {
+	scenario := PaxosScenario{SkipPrepare: true}
+	result := Fuzz(scenario, DefaultFuzzOptions())
+	record := roundTrip(t, RecordTrace(scenario, result.Trace, result.Violation))
+	if _, err := Replay(scenario, record); err == nil || err.Error() != result.Violation.Error() {
+		t.Errorf("replay ended with %v, not %s", err, result.Violation)
+	}
+}
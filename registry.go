@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Scenarios register themselves by name, in an init func next to
+// their type, so the command line can list and run them.
+
+// Settings a scenario may take from the command line.
+// Each scenario uses the ones that make sense for it.
+type ScenarioParams struct {
+	NumProcs int
+	GraphSize int
+	Seed int64
+	TopologyFile string
+	DropRate float64
+}
+
+func DefaultScenarioParams() ScenarioParams {
+	return ScenarioParams{
+		NumProcs: 10,
+		GraphSize: 10,
+		Seed: 1,
+		TopologyFile: "topologies/weighted.txt",
+		DropRate: 0.3,
+	}
+}
+
+type registeredScenario struct {
+	description string
+	new func(params ScenarioParams) Scenario
+}
+
+var scenarioRegistry = map[string]registeredScenario{}
+
+func RegisterScenario(name string, description string, new func(params ScenarioParams) Scenario) {
+	if _, ok := scenarioRegistry[name]; ok {
+		panic(fmt.Sprintf("scenario %q registered twice", name))
+	}
+	scenarioRegistry[name] = registeredScenario{description: description, new: new}
+}
+
+func ScenarioNames() []string {
+	names := make([]string, 0, len(scenarioRegistry))
+	for name := range scenarioRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ScenarioDescription(name string) string {
+	return scenarioRegistry[name].description
+}
+
+func NewScenario(name string, params ScenarioParams) (Scenario, error) {
+	registered, ok := scenarioRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario %q", name)
+	}
+	return registered.new(params), nil
+}
@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scenarios described by a YAML or JSON file rather than Go code:
+// a topology, the protocol stack of each node, the applications on top,
+// link faults, a schedule of changes while running, how long to run
+// and what to check afterwards. See scenarios/ for examples.
+
+type ScenarioConfig struct {
+	Name string `json:"name"`
+	// How long to run, such as "10s"; empty leaves it to the caller.
+	Duration string `json:"duration"`
+	// Seeds the simulation and random generators. 0 leaves it to the caller.
+	Seed int64 `json:"seed"`
+	Topology TopologyConfig `json:"topology"`
+	// Layers from the wire up, the same on every node
+	// unless NodeStacks has an entry for it, keyed by id.
+	Stack []LayerConfig `json:"stack"`
+	NodeStacks map[string][]LayerConfig `json:"node_stacks"`
+	// Nodes not running an app run SimpleProcess.
+	Apps []AppConfig `json:"apps"`
+	Faults LinkFaultsConfig `json:"faults"`
+	Schedule []EventConfig `json:"schedule"`
+	// Any of conversations-complete, leaders-agree and routes-converged.
+	Checks []string `json:"checks"`
+}
+
+// Either a generator and its parameters, or a topology file.
+type TopologyConfig struct {
+	// line, ring, directed-ring, star, grid, torus, tree, hypercube,
+	// complete, erdos-renyi, barabasi-albert or random-regular.
+	Generator string `json:"generator"`
+	// Relative to the config file.
+	File string `json:"file"`
+	Nodes int `json:"nodes"`
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+	// Children per node of a tree.
+	Arity int `json:"arity"`
+	Dimensions int `json:"dimensions"`
+	// Link probability for erdos-renyi.
+	P float64 `json:"p"`
+	// Links per new node for barabasi-albert.
+	M int `json:"m"`
+	Degree int `json:"degree"`
+	Connected bool `json:"connected"`
+	// Defaults to the scenario's seed.
+	Seed int64 `json:"seed"`
+	// Weight of every generated link.
+	Weight int `json:"weight"`
+}
+
+// A layer name, or an object naming it in "layer" with options:
+// lamport, tcp, datagram, link-state and bellman-ford, which takes
+// the fields of BellmanFordOptions.
+type LayerConfig struct {
+	Layer string `json:"layer"`
+	Infinity int `json:"infinity"`
+	SplitHorizon bool `json:"split_horizon"`
+	PoisonReverse bool `json:"poison_reverse"`
+	TriggeredUpdates *bool `json:"triggered_updates"`
+	RefreshInterval int `json:"refresh_interval"`
+	RouteTimeout int `json:"route_timeout"`
+}
+
+func (l *LayerConfig) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*l = LayerConfig{Layer: name}
+		return nil
+	}
+	type plain LayerConfig
+	return decodeStrict(data, (*plain)(l))
+}
+
+// conversation runs between From and To; leader-election, lcr
+// and random run on every node.
+type AppConfig struct {
+	Type string `json:"type"`
+	From ProcessID `json:"from"`
+	To ProcessID `json:"to"`
+	// What From and To say, in turn.
+	Phrases []string `json:"phrases"`
+	Replies []string `json:"replies"`
+}
+
+type LinkFaultsConfig struct {
+	DropRate float64 `json:"drop_rate"`
+	ReorderRate float64 `json:"reorder_rate"`
+	CorruptRate float64 `json:"corrupt_rate"`
+}
+
+// One change at a time since the start, such as "2s".
+// Links are given as [a, b].
+type EventConfig struct {
+	At string `json:"at"`
+	Cut []ProcessID `json:"cut"`
+	Restore []ProcessID `json:"restore"`
+	AddLink []ProcessID `json:"add_link"`
+	RemoveLink []ProcessID `json:"remove_link"`
+	// Weight of the added links.
+	Weight int `json:"weight"`
+	RemoveNode *ProcessID `json:"remove_node"`
+	AddNode *AddNodeConfig `json:"add_node"`
+}
+
+// The new node runs SimpleProcess over its stack.
+type AddNodeConfig struct {
+	ID ProcessID `json:"id"`
+	Links []ProcessID `json:"links"`
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// Reads YAML from .yaml and .yml files and JSON from anything else.
+func LoadScenarioConfig(path string) (*ConfigScenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		tree, err := ParseYAML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if data, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	var config ScenarioConfig
+	if err := decodeStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	scenario, err := NewConfigScenario(config, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return scenario, nil
+}
+
+type scheduledEvent struct {
+	at time.Duration
+	EventConfig
+}
+
+type ConfigScenario struct {
+	Config ScenarioConfig
+	// Where topology files are looked up.
+	Dir string
+	duration time.Duration
+	schedule []scheduledEvent
+}
+
+// Checks config by building its network once.
+func NewConfigScenario(config ScenarioConfig, dir string) (*ConfigScenario, error) {
+	s := &ConfigScenario{Config: config, Dir: dir}
+	if config.Duration != "" {
+		duration, err := time.ParseDuration(config.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("duration: %s", err)
+		}
+		s.duration = duration
+	}
+	for i, event := range config.Schedule {
+		at, err := time.ParseDuration(event.At)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %d: at: %s", i, err)
+		}
+		if err := checkEvent(event); err != nil {
+			return nil, fmt.Errorf("schedule %d: %s", i, err)
+		}
+		s.schedule = append(s.schedule, scheduledEvent{at: at, EventConfig: event})
+	}
+	sort.SliceStable(s.schedule, func(i, j int) bool {
+		return s.schedule[i].at < s.schedule[j].at
+	})
+	for _, check := range config.Checks {
+		if _, ok := configChecks[check]; !ok {
+			return nil, fmt.Errorf("unknown check %q", check)
+		}
+	}
+	if _, err := buildLayers(config.Stack); err != nil {
+		return nil, fmt.Errorf("stack: %s", err)
+	}
+	for key, stack := range config.NodeStacks {
+		if _, err := strconv.Atoi(key); err != nil {
+			return nil, fmt.Errorf("node_stacks: %q is not a node id", key)
+		}
+		if _, err := buildLayers(stack); err != nil {
+			return nil, fmt.Errorf("node_stacks %s: %s", key, err)
+		}
+	}
+	topo, err := s.network()
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckTopology(topo); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func checkEvent(event EventConfig) error {
+	actions := 0
+	for _, link := range [][]ProcessID{event.Cut, event.Restore, event.AddLink, event.RemoveLink} {
+		if link == nil {
+			continue
+		}
+		actions++
+		if len(link) != 2 {
+			return fmt.Errorf("a link needs two ends, not %v", link)
+		}
+	}
+	if event.RemoveNode != nil {
+		actions++
+	}
+	if event.AddNode != nil {
+		actions++
+	}
+	if actions != 1 {
+		return fmt.Errorf("need exactly one change, not %d", actions)
+	}
+	return nil
+}
+
+// How long the config says to run, or 0.
+func (s *ConfigScenario) Duration() time.Duration {
+	return s.duration
+}
+
+func (s *ConfigScenario) Network() Topology {
+	topo, err := s.network()
+	if err != nil {
+		panic(fmt.Sprintf("invalid scenario config: %s", err))
+	}
+	return topo
+}
+
+func (s *ConfigScenario) network() (Topology, error) {
+	topo, err := s.Config.Topology.build(s.Dir, s.Config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("topology: %s", err)
+	}
+	apps, err := s.apps(topo)
+	if err != nil {
+		return nil, err
+	}
+	faults := LinkFaults(s.Config.Faults)
+	for id, node := range topo {
+		node.Subprocess = apps[id].process
+		node.Faults = faults
+		topo[id] = node
+	}
+	built, err := BuildStacks(topo, func(node StackNode) Stack {
+		app := apps[node.ID]
+		return Stack{
+			Layers: s.stackFor(node.ID),
+			AppSends: app.sends,
+			AppAccepts: app.accepts,
+			AppPeers: app.peers,
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stack: %s", err)
+	}
+	return built, nil
+}
+
+func (t TopologyConfig) build(dir string, seed int64) (Topology, error) {
+	if t.File != "" {
+		if t.Generator != "" {
+			return nil, fmt.Errorf("give a generator or a file, not both")
+		}
+		path := t.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return LoadTopologyFile(path, func(id ProcessID) Process { return SimpleProcess{ID: id} })
+	}
+	if t.Seed != 0 {
+		seed = t.Seed
+	}
+	topo, err := t.generate(seed)
+	if err != nil {
+		return nil, err
+	}
+	if t.Weight > 0 {
+		for id, node := range topo {
+			if node.Links == nil {
+				node.Links = make(map[ProcessID]Link, len(node.Neighbors))
+			}
+			for neighbor := range node.Neighbors {
+				link := node.Links[neighbor]
+				link.Weight = t.Weight
+				node.Links[neighbor] = link
+			}
+			topo[id] = node
+		}
+	}
+	return topo, nil
+}
+
+// The generators panic on bad parameters; those become errors here.
+func (t TopologyConfig) generate(seed int64) (topo Topology, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", t.Generator, r)
+		}
+	}()
+	newProcess := func(id ProcessID) Process { return SimpleProcess{ID: id} }
+	needs := func(name string, value int) error {
+		if value < 1 {
+			return fmt.Errorf("%s needs %s", t.Generator, name)
+		}
+		return nil
+	}
+	switch t.Generator {
+	case "line", "ring", "directed-ring", "star", "complete", "erdos-renyi":
+		if err := needs("nodes", t.Nodes); err != nil {
+			return nil, err
+		}
+	case "grid", "torus":
+		if err := needs("rows", t.Rows); err != nil {
+			return nil, err
+		}
+		if err := needs("cols", t.Cols); err != nil {
+			return nil, err
+		}
+	case "tree":
+		if err := needs("nodes", t.Nodes); err != nil {
+			return nil, err
+		}
+		if err := needs("arity", t.Arity); err != nil {
+			return nil, err
+		}
+	case "hypercube":
+		if err := needs("dimensions", t.Dimensions); err != nil {
+			return nil, err
+		}
+	case "barabasi-albert":
+		if err := needs("nodes", t.Nodes); err != nil {
+			return nil, err
+		}
+		if err := needs("m", t.M); err != nil {
+			return nil, err
+		}
+	case "random-regular":
+		if err := needs("nodes", t.Nodes); err != nil {
+			return nil, err
+		}
+		if err := needs("degree", t.Degree); err != nil {
+			return nil, err
+		}
+	case "":
+		return nil, fmt.Errorf("needs a generator or a file")
+	default:
+		return nil, fmt.Errorf("unknown generator %q", t.Generator)
+	}
+	switch t.Generator {
+	case "line":
+		return LineTopology(t.Nodes, newProcess), nil
+	case "ring":
+		return RingTopology(t.Nodes, newProcess), nil
+	case "directed-ring":
+		return DirectedRingTopology(t.Nodes, newProcess), nil
+	case "star":
+		return StarTopology(t.Nodes, newProcess), nil
+	case "complete":
+		processes := make([]Process, t.Nodes)
+		for i := range processes {
+			processes[i] = newProcess(ProcessID(i))
+		}
+		return CompleteTopology(processes), nil
+	case "grid":
+		return GridTopology(t.Rows, t.Cols, newProcess), nil
+	case "torus":
+		return TorusTopology(t.Rows, t.Cols, newProcess), nil
+	case "tree":
+		return TreeTopology(t.Nodes, t.Arity, newProcess), nil
+	case "hypercube":
+		return HypercubeTopology(t.Dimensions, newProcess), nil
+	case "erdos-renyi":
+		return ErdosRenyiTopology(t.Nodes, t.P, seed, t.Connected, newProcess), nil
+	case "barabasi-albert":
+		return BarabasiAlbertTopology(t.Nodes, t.M, seed, newProcess), nil
+	default:
+		return RandomRegularTopology(t.Nodes, t.Degree, seed, t.Connected, newProcess), nil
+	}
+}
+
+func (l LayerConfig) layer() (Layer, error) {
+	switch l.Layer {
+	case "lamport":
+		return LamportLayer(), nil
+	case "tcp":
+		return TCPLayer(), nil
+	case "datagram":
+		return DatagramLayer(), nil
+	case "link-state":
+		return LinkStateLayer(), nil
+	case "bellman-ford":
+		options := DefaultBellmanFordOptions()
+		if l.Infinity > 0 {
+			options.Infinity = l.Infinity
+		}
+		options.SplitHorizon = l.SplitHorizon
+		options.PoisonReverse = l.PoisonReverse
+		if l.TriggeredUpdates != nil {
+			options.TriggeredUpdates = *l.TriggeredUpdates
+		}
+		options.RefreshInterval = l.RefreshInterval
+		options.RouteTimeout = l.RouteTimeout
+		return BellmanFordLayer(options), nil
+	}
+	return Layer{}, fmt.Errorf("unknown layer %q", l.Layer)
+}
+
+func buildLayers(configs []LayerConfig) ([]Layer, error) {
+	layers := make([]Layer, 0, len(configs))
+	for _, config := range configs {
+		layer, err := config.layer()
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// Checked by NewConfigScenario.
+func (s *ConfigScenario) stackFor(id ProcessID) []Layer {
+	configs := s.Config.Stack
+	if nodeStack, ok := s.Config.NodeStacks[strconv.Itoa(int(id))]; ok {
+		configs = nodeStack
+	}
+	stack, _ := buildLayers(configs)
+	return stack
+}
+
+// A node's application and what its stack needs to know about it.
+type configApp struct {
+	process Process
+	sends []Message
+	accepts []Message
+	peers []ProcessID
+}
+
+func (s *ConfigScenario) apps(topo Topology) (map[ProcessID]configApp, error) {
+	apps := make(map[ProcessID]configApp, len(topo))
+	assign := func(id ProcessID, app configApp) error {
+		if _, ok := topo[id]; !ok {
+			return fmt.Errorf("apps: %s is not in the topology", id)
+		}
+		if _, ok := apps[id]; ok {
+			return fmt.Errorf("apps: %s runs two apps", id)
+		}
+		apps[id] = app
+		return nil
+	}
+	for _, config := range s.Config.Apps {
+		switch config.Type {
+		case "conversation":
+			phrases, replies := config.Phrases, config.Replies
+			if phrases == nil {
+				phrases = []string{"anyone out there", "nice to meet you"}
+			}
+			if replies == nil {
+				replies = []string{"right here", "likewise"}
+			}
+			ends := []struct {
+				id, friend ProcessID
+				phrases []string
+			}{
+				{config.From, config.To, phrases},
+				{config.To, config.From, replies},
+			}
+			for _, end := range ends {
+				err := assign(end.id, configApp{
+					process: &ConversationProcess{
+						ID: end.id,
+						FriendID: end.friend,
+						Phrases: end.phrases,
+						Initiate: end.id == config.From,
+					},
+					sends: []Message{ConversationMessage{}},
+					accepts: []Message{ConversationMessage{}},
+					peers: []ProcessID{end.friend},
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		case "leader-election", "lcr", "random":
+			for _, id := range sortedIDs(topo) {
+				app, err := everyNodeApp(config.Type, id, topo)
+				if err != nil {
+					return nil, err
+				}
+				if err := assign(id, app); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("apps: unknown type %q", config.Type)
+		}
+	}
+	for id := range topo {
+		if _, ok := apps[id]; !ok {
+			// SimpleProcess neither sends nor receives.
+			apps[id] = configApp{
+				process: SimpleProcess{ID: id},
+				accepts: []Message{},
+				peers: []ProcessID{},
+			}
+		}
+	}
+	return apps, nil
+}
+
+func everyNodeApp(kind string, id ProcessID, topo Topology) (configApp, error) {
+	switch kind {
+	case "leader-election":
+		return configApp{
+			process: &LeaderElectionProcess{
+				Process: SimpleProcess{ID: id},
+				GraphSize: len(topo),
+				Neighbors: topo.OutNeighbors(id),
+			},
+			sends: []Message{LeaderMessage{}},
+			accepts: []Message{LeaderMessage{}},
+		}, nil
+	case "lcr":
+		// Told its next node here, since it may sit under other layers
+		// and never hear from the cluster.
+		in, out := topo.InNeighbors(id), topo.OutNeighbors(id)
+		if len(out) != 1 {
+			return configApp{}, fmt.Errorf("apps: lcr needs one link out of %s, not %d", id, len(out))
+		}
+		p := &LCRProcess{ID: id}
+		p.SetNeighbors(in, out)
+		return configApp{
+			process: p,
+			sends: []Message{LCRMessage{}},
+			accepts: []Message{LCRMessage{}},
+		}, nil
+	}
+	if int(id) >= len(topo) || id < 0 {
+		return configApp{}, fmt.Errorf("apps: random needs nodes numbered from 0, not %s", id)
+	}
+	return configApp{
+		process: &RandomProcess{IncrementalID: id, NeighborCount: len(topo)},
+		sends: []Message{MessageWithContent{}},
+		accepts: []Message{MessageWithContent{}},
+	}, nil
+}
+
+func (s *ConfigScenario) Script(c Cluster) {
+	var elapsed time.Duration
+	for _, event := range s.schedule {
+		c.Sleep(event.at - elapsed)
+		elapsed = event.at
+		s.apply(c, event.EventConfig)
+	}
+}
+
+func (s *ConfigScenario) apply(c Cluster, event EventConfig) {
+	switch {
+	case event.Cut != nil:
+		fmt.Fprintf(logOutput, "cutting %s-%s\n", event.Cut[0], event.Cut[1])
+		c.CutLink(event.Cut[0], event.Cut[1])
+	case event.Restore != nil:
+		fmt.Fprintf(logOutput, "restoring %s-%s\n", event.Restore[0], event.Restore[1])
+		c.RestoreLink(event.Restore[0], event.Restore[1])
+	case event.AddLink != nil:
+		fmt.Fprintf(logOutput, "adding link %s-%s\n", event.AddLink[0], event.AddLink[1])
+		c.AddLink(event.AddLink[0], event.AddLink[1], Link{Weight: event.Weight})
+	case event.RemoveLink != nil:
+		fmt.Fprintf(logOutput, "removing link %s-%s\n", event.RemoveLink[0], event.RemoveLink[1])
+		c.RemoveLink(event.RemoveLink[0], event.RemoveLink[1])
+	case event.RemoveNode != nil:
+		fmt.Fprintf(logOutput, "removing %s\n", *event.RemoveNode)
+		c.RemoveNode(*event.RemoveNode)
+	case event.AddNode != nil:
+		fmt.Fprintf(logOutput, "adding %s\n", event.AddNode.ID)
+		c.AddNode(s.newNode(*event.AddNode, event.Weight))
+	}
+}
+
+func (s *ConfigScenario) newNode(config AddNodeConfig, weight int) TopologyNode {
+	node := TopologyNode{
+		Neighbors: make(map[ProcessID]struct{}, len(config.Links)),
+		Links: make(map[ProcessID]Link, len(config.Links)),
+		Faults: LinkFaults(s.Config.Faults),
+	}
+	for _, neighbor := range config.Links {
+		node.Neighbors[neighbor] = struct{}{}
+		node.Links[neighbor] = Link{Weight: weight}
+	}
+	layers := s.stackFor(config.ID)
+	var p Process = SimpleProcess{ID: config.ID}
+	for i := len(layers) - 1; i >= 0; i-- {
+		p = layers[i].Wrap(p, stackNode(config.ID, node))
+	}
+	node.Subprocess = p
+	return node
+}
+
+// The links the network should have once the schedule has run.
+// As in the cluster, cutting and restoring only take existing links
+// down and up, whatever they were added with.
+func (s *ConfigScenario) finalTopology() Topology {
+	topo := s.Network()
+	for id, node := range topo {
+		node.Neighbors = copyIDs(node.Neighbors)
+		links := make(map[ProcessID]Link, len(node.Links))
+		for pid, l := range node.Links {
+			links[pid] = l
+		}
+		node.Links = links
+		topo[id] = node
+	}
+	down := make(map[[2]ProcessID]bool)
+	setUp := func(a, b ProcessID, up bool) {
+		for _, end := range [][2]ProcessID{{a, b}, {b, a}} {
+			if _, ok := topo[end[0]].Neighbors[end[1]]; !ok {
+				continue
+			}
+			if up {
+				delete(down, end)
+			} else {
+				down[end] = true
+			}
+		}
+	}
+	link := func(a, b ProcessID, l Link) {
+		for _, end := range [][2]ProcessID{{a, b}, {b, a}} {
+			if node, ok := topo[end[0]]; ok {
+				node.Neighbors[end[1]] = struct{}{}
+				node.Links[end[1]] = l
+				delete(down, end)
+			}
+		}
+	}
+	unlink := func(a, b ProcessID) {
+		for _, end := range [][2]ProcessID{{a, b}, {b, a}} {
+			if node, ok := topo[end[0]]; ok {
+				delete(node.Neighbors, end[1])
+				delete(node.Links, end[1])
+				delete(down, end)
+			}
+		}
+	}
+	for _, event := range s.schedule {
+		switch {
+		case event.Cut != nil:
+			setUp(event.Cut[0], event.Cut[1], false)
+		case event.Restore != nil:
+			setUp(event.Restore[0], event.Restore[1], true)
+		case event.AddLink != nil:
+			link(event.AddLink[0], event.AddLink[1], Link{Weight: event.Weight})
+		case event.RemoveLink != nil:
+			unlink(event.RemoveLink[0], event.RemoveLink[1])
+		case event.RemoveNode != nil:
+			// Links into the node go too, one-way ones included.
+			for id := range topo {
+				unlink(*event.RemoveNode, id)
+			}
+			delete(topo, *event.RemoveNode)
+		case event.AddNode != nil:
+			topo[event.AddNode.ID] = TopologyNode{
+				Neighbors: map[ProcessID]struct{}{},
+				Links: map[ProcessID]Link{},
+			}
+			for _, neighbor := range event.AddNode.Links {
+				link(event.AddNode.ID, neighbor, Link{Weight: event.Weight})
+			}
+		}
+	}
+	for end := range down {
+		delete(topo[end[0]].Neighbors, end[1])
+	}
+	return topo
+}
+
+var configChecks = map[string]func(s *ConfigScenario, c Cluster) error{
+	"conversations-complete": func(s *ConfigScenario, c Cluster) error {
+		return conversationsComplete(c)
+	},
+	"leaders-agree": func(s *ConfigScenario, c Cluster) error {
+		return leadersAgree(c)
+	},
+	"routes-converged": func(s *ConfigScenario, c Cluster) error {
+		return routesConverged(c, s.finalTopology())
+	},
+}
+
+// Runs the config's checks in order, stopping at the first failure.
+func (s *ConfigScenario) Check(c Cluster) error {
+	for _, check := range s.Config.Checks {
+		if err := configChecks[check](s, c); err != nil {
+			return fmt.Errorf("%s: %s", check, err)
+		}
+	}
+	return nil
+}
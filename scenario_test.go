@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var seeds = flag.Int("seeds", 10, "seeds to run each scenario with")
+
+// Scenarios that show a failure on purpose.
+var failingScenarios = map[string]bool{
+	"lossy-datagram": true,
+}
+
+// Scenarios that fail only in some interleavings, so some seeds pass.
+// ModelCheck finds them every time.
+var racyScenarios = map[string]bool{
+	"paxos-single-phase": true,
+	"kv-backup-reads": true,
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	logOutput = ioutil.Discard
+	os.Exit(m.Run())
+}
+
+func TestScenarios(t *testing.T) {
+	for _, name := range ScenarioNames() {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			for seed := int64(1); seed <= int64(*seeds); seed++ {
+				params := DefaultScenarioParams()
+				params.Seed = seed
+				scenario, err := NewScenario(name, params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = SimulateScenario(scenario, 5*time.Second, seed)
+				if racyScenarios[name] {
+					continue
+				}
+				if failingScenarios[name] {
+					if err == nil {
+						t.Errorf("seed %d: passed, but should fail", seed)
+					}
+				} else if err != nil {
+					t.Errorf("seed %d: %s", seed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestScenarioConfigs(t *testing.T) {
+	paths, err := filepath.Glob("scenarios/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		scenario, err := LoadScenarioConfig(path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		if _, err := SimulateScenario(scenario, scenario.Duration(), 1); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	defer func() { logOutput = ioutil.Discard }()
+	run := func() (string, int) {
+		var out bytes.Buffer
+		logOutput = &out
+		scenario, _ := NewScenario("count-to-infinity", DefaultScenarioParams())
+		s := NewSimulation(scenario.Network(), 7)
+		s.StartScript(scenario.(ScriptedScenario).Script)
+		if err := s.RunFor(3 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		s.Stop()
+		return out.String(), s.Steps
+	}
+	firstLog, firstSteps := run()
+	secondLog, secondSteps := run()
+	if firstLog != secondLog || firstSteps != secondSteps {
+		t.Errorf("runs differ:\n%s\n%s", firstLog, secondLog)
+	}
+}
+
+func TestInvariantFailureStopsSimulation(t *testing.T) {
+	s := NewSimulation(LCRScenario{RingSize: 5}.Network(), 1)
+	s.Invariants = []Invariant{{
+		Name: "no leader",
+		Check: func(c Cluster) error {
+			for _, e := range elections(c) {
+				if e.found {
+					return fmt.Errorf("%s found %s", e.id, e.leader)
+				}
+			}
+			return nil
+		},
+	}}
+	err := s.RunFor(time.Second)
+	invariantErr, ok := err.(*InvariantError)
+	if !ok {
+		t.Fatalf("got %v, want an InvariantError", err)
+	}
+	if invariantErr.Invariant != "no leader" || invariantErr.Step != s.Steps {
+		t.Errorf("got %+v after %d steps", invariantErr, s.Steps)
+	}
+}
+
+func TestConfigFinalTopology(t *testing.T) {
+	removed := ProcessID(2)
+	s, err := NewConfigScenario(ScenarioConfig{
+		Topology: TopologyConfig{Generator: "directed-ring", Nodes: 4},
+		Schedule: []EventConfig{
+			{At: "1s", AddLink: []ProcessID{0, 3}, Weight: 5},
+			{At: "2s", Cut: []ProcessID{0, 3}},
+			{At: "3s", Restore: []ProcessID{3, 0}},
+			{At: "4s", RemoveNode: &removed},
+		},
+	}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo := s.finalTopology()
+	if _, ok := topo[1].Neighbors[2]; ok {
+		t.Error("1 still links to the removed 2")
+	}
+	if err := CheckTopology(topo); err != nil {
+		t.Error(err)
+	}
+	// The restored link is the one added, not the ring's one-way link.
+	if link := topo[3].Links[0]; link.Weight != 5 || link.OneWay {
+		t.Errorf("restored %+v", link)
+	}
+	if _, ok := topo[0].Neighbors[3]; !ok {
+		t.Error("0-3 was not restored")
+	}
+}
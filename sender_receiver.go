@@ -15,6 +15,9 @@ type ConversationProcess struct{
 	Phrases []string
 	Initiate bool
 	PhraseIndex int
+	// Phrases heard twice or out of order, which a reliable
+	// transport never delivers.
+	Misordered int
 }
 
 func (p *ConversationProcess) Id() ProcessID {
@@ -23,12 +26,33 @@ func (p *ConversationProcess) Id() ProcessID {
 
 type ConversationMessage struct {
 	Phrase string
+	// Position of the phrase in the sender's Phrases,
+	// so the receiver can tell if phrases are lost, repeated or reordered.
+	Index int
 }
 
 func (m ConversationMessage) String() string {
 	return m.Phrase
 }
 
+func (m ConversationMessage) MessageTag() string {
+	return "conversation"
+}
+
+func (m ConversationMessage) Encode(e *Encoder) {
+	e.WriteString(m.Phrase)
+	e.WriteInt(m.Index)
+}
+
+func init() {
+	RegisterMessageType("conversation", func(d *Decoder) Message {
+		m := ConversationMessage{}
+		m.Phrase = d.ReadString()
+		m.Index = d.ReadInt()
+		return m
+	})
+}
+
 func (p *ConversationProcess) sendMessage(send func(RoutedMessage)) {
 	if p.PhraseIndex >= len(p.Phrases) {
 		return
@@ -36,6 +60,7 @@ func (p *ConversationProcess) sendMessage(send func(RoutedMessage)) {
 	send(RoutedMessage{
 		Message: ConversationMessage{
 			Phrase: p.Phrases[p.PhraseIndex],
+			Index: p.PhraseIndex,
 		},
 		From: p.Id(),
 		To: p.FriendID,
@@ -59,6 +84,17 @@ func (p *ConversationProcess) Step(send func(RoutedMessage), receive func() *Rou
 	if !ok {
 		panic(fmt.Sprintf("conversation expects phrase, not %T %s", received.Message, received.Message))
 	}
+	if contentMessage.Index != p.ExpectedPhraseIndex {
+		// Lost, repeated or reordered on the way; conversationsComplete
+		// reports it.
+		p.Misordered += 1
+		Log(p, fmt.Sprintf(
+			"expected phrase %d from %s, got %d '%s'; ignoring it",
+			p.ExpectedPhraseIndex, p.FriendID, contentMessage.Index, contentMessage,
+		))
+		return
+	}
+	p.ExpectedPhraseIndex += 1
 	Log(p, fmt.Sprintf("received phrase '%s'", contentMessage))
 	p.sendMessage(send)
 	if p.PhraseIndex >= len(p.Phrases) {
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConversationIgnoresOutOfOrderPhrases(t *testing.T) {
+	p := &ConversationProcess{ID: 2, FriendID: 1, Phrases: []string{"hi", "bye"}}
+	var sent []RoutedMessage
+	send := func(m RoutedMessage) { sent = append(sent, m) }
+	inbox := []RoutedMessage{
+		{Message: ConversationMessage{Phrase: "how are you", Index: 1}, From: 1, To: 2},
+		{Message: ConversationMessage{Phrase: "hello", Index: 0}, From: 1, To: 2},
+		{Message: ConversationMessage{Phrase: "hello", Index: 0}, From: 1, To: 2},
+	}
+	receive := func() *RoutedMessage {
+		if len(inbox) == 0 {
+			return nil
+		}
+		m := inbox[0]
+		inbox = inbox[1:]
+		return &m
+	}
+	for i := 0; i < 3; i++ {
+		p.Step(send, receive)
+	}
+	if p.ExpectedPhraseIndex != 1 || len(sent) != 1 || p.Misordered != 2 {
+		t.Errorf("expecting phrase %d after sending %v, %d misordered", p.ExpectedPhraseIndex, sent, p.Misordered)
+	}
+}
+
+func TestConversationsCompleteCatchesMisordering(t *testing.T) {
+	a := &ConversationProcess{ID: 1, FriendID: 2, Phrases: []string{"hi"}, PhraseIndex: 1, ExpectedPhraseIndex: 1}
+	b := &ConversationProcess{ID: 2, FriendID: 1, Phrases: []string{"hello"}, PhraseIndex: 1, ExpectedPhraseIndex: 1}
+	c := CreateCluster(CompleteTopology([]Process{a, b}))
+	if err := conversationsComplete(c); err != nil {
+		t.Fatal(err)
+	}
+	b.Misordered = 1
+	if err := conversationsComplete(c); err == nil {
+		t.Error("a repeated phrase went unnoticed")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Runs a cluster deterministically in one goroutine. Each round every
+// process steps once, in an order drawn from the seed, and then virtual
+// time moves on by Tick. Random draws come from simRand, seeded the same
+// way, so a topology and seed always give the same run, which makes
+// failures reproducible and scenarios testable.
+type Simulation struct {
+	Cluster Cluster
+	// Virtual time between rounds, like the yield of the real runner.
+	Tick time.Duration
+	// Checked after every step.
+	Invariants []Invariant
+	Steps int
+	Rounds int
+	order *rand.Rand
+	clock *simClock
+	// The script's pending sleep, if it has one.
+	script *scriptSleep
+	// While recording, the order processes stepped in each round.
+	recording bool
+	recordedRounds [][]ProcessID
+	// While replaying, the orders to step them in instead.
+	replayRounds [][]ProcessID
+	// Called after every round, as by MeasureScenario.
+	afterRound func()
+}
+
+// Virtual time starts here, so latencies work as they do in real time.
+var simEpoch = time.Unix(0, 0)
+
+type simClock struct {
+	now time.Time
+	// Scripts hand control back to the simulation here when they sleep
+	// or finish, so they change the cluster only between rounds.
+	sleeps chan *scriptSleep
+}
+
+type scriptSleep struct {
+	wakeAt time.Time
+	resume chan bool
+}
+
+// Unwinds a script whose simulation has stopped.
+type simulationStopped struct{}
+
+func (clock *simClock) sleep(d time.Duration) {
+	s := &scriptSleep{wakeAt: clock.now.Add(d), resume: make(chan bool)}
+	clock.sleeps <- s
+	if stopped := <-s.resume; stopped {
+		panic(simulationStopped{})
+	}
+}
+
+// Sleeps for d of the cluster's time: real time, unless a Simulation
+// runs the cluster. Scripts should sleep this way, so they stay
+// deterministic when simulated.
+func (c Cluster) Sleep(d time.Duration) {
+	clusterMutex.Lock()
+	var clock *simClock
+	for _, p := range c {
+		clock = p.clock
+		break
+	}
+	clusterMutex.Unlock()
+	if clock != nil {
+		clock.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+func NewSimulation(topo Topology, seed int64) *Simulation {
+	SeedSimulation(seed)
+	c := CreateCluster(topo)
+	clock := &simClock{now: simEpoch, sleeps: make(chan *scriptSleep)}
+	for _, p := range c {
+		p.clock = clock
+	}
+	return &Simulation{
+		Cluster: c,
+		Tick: 10 * time.Millisecond,
+		order: rand.New(rand.NewSource(seed)),
+		clock: clock,
+	}
+}
+
+// Virtual time since the simulation began.
+func (s *Simulation) Elapsed() time.Duration {
+	return s.clock.now.Sub(simEpoch)
+}
+
+// Runs script alongside the cluster, as RunScenarioFor does,
+// but only ever between rounds.
+func (s *Simulation) StartScript(script func(c Cluster)) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(simulationStopped); !ok {
+					panic(r)
+				}
+				return
+			}
+			s.clock.sleeps <- nil
+		}()
+		script(s.Cluster)
+	}()
+	s.script = <-s.clock.sleeps
+}
+
+func (s *Simulation) wakeScript() {
+	for s.script != nil && !s.clock.now.Before(s.script.wakeAt) {
+		s.script.resume <- false
+		s.script = <-s.clock.sleeps
+	}
+}
+
+// Stops a script still sleeping, without letting it run on.
+func (s *Simulation) Stop() {
+	if s.script != nil {
+		s.script.resume <- true
+		s.script = nil
+	}
+}
+
+// Returned when an invariant fails, saying when it did.
+type InvariantError struct {
+	Invariant string
+	Step int
+	// The process that had just stepped.
+	Process ProcessID
+	Elapsed time.Duration
+	Err error
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf(
+		"invariant %s broken at step %d, after %s stepped %s in: %s",
+		e.Invariant, e.Step, e.Process, e.Elapsed, e.Err,
+	)
+}
+
+// Steps every process once, checking the invariants after each step.
+func (s *Simulation) Round() error {
+	s.wakeScript()
+	ids := make([]ProcessID, 0, len(s.Cluster))
+	for id := range s.Cluster {
+		ids = append(ids, id)
+	}
+	sortProcessIDs(ids)
+	s.order.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	if s.replayRounds != nil {
+		if s.Rounds >= len(s.replayRounds) {
+			panic(&ReplayDivergedError{"more rounds than recorded"})
+		}
+		ids = s.replayRounds[s.Rounds]
+	}
+	if s.recording {
+		s.recordedRounds = append(s.recordedRounds, append([]ProcessID{}, ids...))
+	}
+	for _, id := range ids {
+		p, ok := s.Cluster[id]
+		if !ok || p.isRemoved() {
+			continue
+		}
+		p.Step()
+		s.Steps++
+		for _, invariant := range s.Invariants {
+			if err := invariant.Check(s.Cluster); err != nil {
+				return &InvariantError{
+					Invariant: invariant.Name,
+					Step: s.Steps,
+					Process: id,
+					Elapsed: s.Elapsed(),
+					Err: err,
+				}
+			}
+		}
+	}
+	s.Rounds++
+	s.clock.now = s.clock.now.Add(s.Tick)
+	trace(TraceEvent{Kind: "round", Round: s.Rounds, Elapsed: s.Elapsed()})
+	if s.afterRound != nil {
+		s.afterRound()
+	}
+	return nil
+}
+
+// Runs rounds until duration of virtual time has passed
+// or an invariant fails.
+func (s *Simulation) RunFor(duration time.Duration) error {
+	for s.Elapsed() < duration {
+		if err := s.Round(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs scenario in a Simulation for duration of virtual time, with its
+// script, checking its invariants after every step and its Check at the end.
+func SimulateScenario(scenario Scenario, duration time.Duration, seed int64) (Cluster, error) {
+	s := newScenarioSimulation(scenario, seed)
+	return s.Cluster, s.runScenario(scenario, duration)
+}
+
+func newScenarioSimulation(scenario Scenario, seed int64) *Simulation {
+	s := NewSimulation(scenario.Network(), seed)
+	if withInvariants, ok := scenario.(InvariantScenario); ok {
+		s.Invariants = withInvariants.Invariants()
+	}
+	return s
+}
+
+func (s *Simulation) runScenario(scenario Scenario, duration time.Duration) error {
+	// Even if a replay panics.
+	defer s.Stop()
+	if scripted, ok := scenario.(ScriptedScenario); ok {
+		s.StartScript(scripted.Script)
+	}
+	err := s.RunFor(duration)
+	s.Stop()
+	if err != nil {
+		return err
+	}
+	if checked, ok := scenario.(CheckedScenario); ok {
+		return checked.Check(s.Cluster)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// One wrapper Process in a protocol stack, such as TCP or routing.
+// Sends and Accepts describe the messages the layer exchanges with the same
+// layer on its peers, so a stack can be checked before anything runs instead
+// of panicking on an unexpected message type.
+type Layer struct {
+	Name string
+	// Wraps the layers above this one, ending with the application.
+	Wrap func(above Process, node StackNode) Process
+	// Example values of each message type the layer hands to the layer below.
+	Sends []Message
+	// Example values of each message type the layer can receive.
+	// Nil accepts anything.
+	Accepts []Message
+	// Whether the layer forwards messages beyond its neighbors,
+	// so that the layers above it talk end to end.
+	Routes bool
+}
+
+// What a layer knows about the node it runs on.
+type StackNode struct {
+	ID ProcessID
+	Neighbors map[ProcessID]struct{}
+	Links map[ProcessID]Link
+	AS ASNumber
+}
+
+type Stack struct {
+	// From the wire up: Layers[0] talks to the links
+	// and the last layer wraps the application.
+	Layers []Layer
+	// The messages the node's application sends and accepts,
+	// with the same meaning as in Layer.
+	AppSends []Message
+	AppAccepts []Message
+	// The nodes the application talks to. Nil means all of its peers.
+	AppPeers []ProcessID
+}
+
+func (s Stack) String() string {
+	names := make([]string, 0, len(s.Layers)+1)
+	for i := len(s.Layers) - 1; i >= 0; i-- {
+		names = append(names, s.Layers[i].Name)
+	}
+	return strings.Join(append([]string{"app"}, names...), " / ")
+}
+
+// The layers of the stack with the application as the last one.
+func (s Stack) levels() []Layer {
+	return append(append([]Layer{}, s.Layers...), Layer{
+		Name: "app",
+		Sends: s.AppSends,
+		Accepts: s.AppAccepts,
+	})
+}
+
+func accepts(layer Layer, m Message) bool {
+	if layer.Accepts == nil {
+		return true
+	}
+	for _, accepted := range layer.Accepts {
+		if reflect.TypeOf(accepted) == reflect.TypeOf(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps each node's Subprocess, its application, in the layers of its stack.
+// Fails if a layer would receive messages it doesn't understand from its peers:
+// below the first routing layer its peers are the node's neighbors,
+// and above it every other node.
+func BuildStacks(topo Topology, stackFor func(node StackNode) Stack) (Topology, error) {
+	stacks := make(map[ProcessID]Stack, len(topo))
+	for id, node := range topo {
+		stacks[id] = stackFor(stackNode(id, node))
+	}
+	for _, id := range sortedIDs(topo) {
+		if err := checkStack(id, topo, stacks); err != nil {
+			return nil, err
+		}
+	}
+	built := make(Topology, len(topo))
+	for id, node := range topo {
+		layers := stacks[id].Layers
+		p := node.Subprocess
+		for i := len(layers) - 1; i >= 0; i-- {
+			p = layers[i].Wrap(p, stackNode(id, node))
+		}
+		node.Subprocess = p
+		built[id] = node
+	}
+	return built, nil
+}
+
+// Like BuildStacks, but the same stack on every node.
+func BuildStack(topo Topology, stack Stack) (Topology, error) {
+	return BuildStacks(topo, func(StackNode) Stack { return stack })
+}
+
+func stackNode(id ProcessID, node TopologyNode) StackNode {
+	return StackNode{
+		ID: id,
+		Neighbors: node.Neighbors,
+		Links: node.Links,
+		AS: node.AS,
+	}
+}
+
+func sortedIDs(topo Topology) []ProcessID {
+	ids := make([]ProcessID, 0, len(topo))
+	for id := range topo {
+		ids = append(ids, id)
+	}
+	sortProcessIDs(ids)
+	return ids
+}
+
+func sortProcessIDs(ids []ProcessID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// For loops over a set whose order shows, such as the order messages
+// are sent in, so that simulated runs repeat.
+func sortedSet(ids map[ProcessID]struct{}) []ProcessID {
+	sorted := make([]ProcessID, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sortProcessIDs(sorted)
+	return sorted
+}
+
+func checkStack(id ProcessID, topo Topology, stacks map[ProcessID]Stack) error {
+	levels := stacks[id].levels()
+	routed := false
+	for i, layer := range levels {
+		if layer.Name != "app" && layer.Wrap == nil {
+			return fmt.Errorf("%s: layer %s has no Wrap", id, layer.Name)
+		}
+		var peers []ProcessID
+		if routed {
+			for _, peer := range sortedIDs(topo) {
+				if peer != id {
+					peers = append(peers, peer)
+				}
+			}
+		} else {
+			for _, peer := range sortedIDs(topo) {
+				if _, ok := topo[id].Neighbors[peer]; ok && peer != id {
+					peers = append(peers, peer)
+				}
+			}
+		}
+		if layer.Name == "app" && stacks[id].AppPeers != nil {
+			peers = stacks[id].AppPeers
+		}
+		for _, peer := range peers {
+			peerStack, ok := stacks[peer]
+			if !ok {
+				return fmt.Errorf("%s: peer %s is not in the topology", id, peer)
+			}
+			peerLevels := peerStack.levels()
+			if len(peerLevels) <= i || peerLevels[i].Name != layer.Name {
+				return fmt.Errorf(
+					"%s runs %s but its peer %s runs %s", id, stacks[id], peer, peerStack,
+				)
+			}
+			for _, m := range layer.Sends {
+				if !accepts(peerLevels[i], m) {
+					return fmt.Errorf(
+						"%s's %s layer sends %T, which %s's %s layer does not accept",
+						id, layer.Name, m, peer, peerLevels[i].Name,
+					)
+				}
+			}
+		}
+		routed = routed || layer.Routes
+	}
+	return nil
+}
+
+func linkWeights(links map[ProcessID]Link) map[ProcessID]int {
+	weights := make(map[ProcessID]int, len(links))
+	for pid, link := range links {
+		if link.Weight > 0 {
+			weights[pid] = link.Weight
+		}
+	}
+	return weights
+}
+
+func LamportLayer() Layer {
+	return Layer{
+		Name: "lamport",
+		Wrap: func(above Process, node StackNode) Process {
+			return &LamportProcess{Process: above}
+		},
+		Sends: []Message{LamportMessage{}},
+		Accepts: []Message{LamportMessage{}},
+	}
+}
+
+func TCPLayer() Layer {
+	return Layer{
+		Name: "tcp",
+		Wrap: func(above Process, node StackNode) Process {
+			return &MultiTCPProcess{Process: above}
+		},
+		Sends: []Message{TCPDataMessage{}, TCPAckMessage{}},
+		Accepts: []Message{TCPDataMessage{}, TCPAckMessage{}},
+	}
+}
+
+func DatagramLayer() Layer {
+	return Layer{
+		Name: "datagram",
+		Wrap: func(above Process, node StackNode) Process {
+			return &DatagramProcess{Process: above}
+		},
+		Sends: []Message{DatagramFragment{}},
+		Accepts: []Message{DatagramFragment{}},
+	}
+}
+
+// Link weights come from each node's Links.
+func BellmanFordLayer(options BellmanFordOptions) Layer {
+	return Layer{
+		Name: "bellman-ford",
+		Wrap: func(above Process, node StackNode) Process {
+			return newBellmanFordProcess(
+				above, node.Neighbors, linkWeights(node.Links), options,
+			)
+		},
+		Sends: []Message{BellmanFordUpdateMessage{}, RoutedMessage{}},
+		Accepts: []Message{BellmanFordUpdateMessage{}, RoutedMessage{}},
+		Routes: true,
+	}
+}
+
+func LinkStateLayer() Layer {
+	return Layer{
+		Name: "link-state",
+		Wrap: func(above Process, node StackNode) Process {
+			return newLinkStateProcess(above, node.Neighbors, linkWeights(node.Links))
+		},
+		Sends: []Message{LinkStateUpdateMessage{}, RoutedMessage{}},
+		Accepts: []Message{LinkStateUpdateMessage{}, RoutedMessage{}},
+		Routes: true,
+	}
+}
+
+func PathVectorLayer(policy RoutingPolicy) Layer {
+	return Layer{
+		Name: "path-vector",
+		Wrap: func(above Process, node StackNode) Process {
+			return newPathVectorProcess(above, node.Neighbors, policy)
+		},
+		Sends: []Message{PathVectorUpdateMessage{}, RoutedMessage{}},
+		Accepts: []Message{PathVectorUpdateMessage{}, RoutedMessage{}},
+		Routes: true,
+	}
+}
+
+// The conversation of BellmanFordScenario, built from a declared stack:
+// Lamport clocks over TCP over Bellman-Ford routing.
+type StackedConversationScenario struct{}
+
+func (s StackedConversationScenario) Network() Topology {
+	topo := bellmanFordScenarioNetwork(func(
+		subprocess Process,
+		neighbors map[ProcessID]struct{},
+	) TopologyNode {
+		return TopologyNode{Subprocess: subprocess, Neighbors: neighbors}
+	})
+	stacked, err := BuildStacks(topo, func(node StackNode) Stack {
+		stack := Stack{
+			Layers: []Layer{
+				BellmanFordLayer(DefaultBellmanFordOptions()),
+				TCPLayer(),
+				LamportLayer(),
+			},
+		}
+		switch node.ID {
+		case 1:
+			stack.AppSends = []Message{ConversationMessage{}}
+			stack.AppAccepts = []Message{ConversationMessage{}}
+			stack.AppPeers = []ProcessID{8}
+		case 8:
+			stack.AppSends = []Message{ConversationMessage{}}
+			stack.AppAccepts = []Message{ConversationMessage{}}
+			stack.AppPeers = []ProcessID{1}
+		default:
+			// SimpleProcess neither sends nor receives.
+			stack.AppAccepts = []Message{}
+		}
+		return stack
+	})
+	if err != nil {
+		panic(fmt.Sprintf("invalid stack: %s", err))
+	}
+	return stacked
+}
+
+func (s StackedConversationScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s StackedConversationScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func init() {
+	RegisterScenario("stacked", "a conversation over a declared protocol stack", func(ScenarioParams) Scenario {
+		return StackedConversationScenario{}
+	})
+}
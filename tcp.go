@@ -6,15 +6,28 @@ import (
 
 type SequenceNumber int
 
+// TCP is end to end: when it runs inside a routing process like
+// BellmanFordProcess, segments and ACKs are routed between the endpoints,
+// so anything lost at an intermediate hop is retransmitted by the source.
 type TCPOutboundProcess struct {
 	SenderBufferProcess
 	DestID ProcessID
 	// Unlimited size
 	ToSend []TCPDataMessage
 	NextSeq SequenceNumber
+	// How many segments at the front of ToSend have been sent at least once.
+	sent int
+	steps int
+	lastTransmit int
 }
 
-const InboundProcessBufferSize = 5
+const (
+	InboundProcessBufferSize = 5
+	// At most this many segments are sent and awaiting an ACK.
+	TCPWindowSize = InboundProcessBufferSize
+	// Steps to wait for an ACK before sending the whole window again.
+	TCPRetransmitTimeout = 30
+)
 
 type TCPInboundProcess struct {
 	ReceiverBufferProcess
@@ -22,29 +35,90 @@ type TCPInboundProcess struct {
 	// Limited to size InboundProcessBufferSize
 	Received []TCPDataMessage
 	NextSeq SequenceNumber
+	// Segments that arrived ahead of NextSeq, within the window.
+	outOfOrder map[SequenceNumber]TCPDataMessage
 }
 
 func (n SequenceNumber) String() string {
 	return fmt.Sprintf("seq:%d", n)
 }
 
+// Segments carry a checksum over their encoding,
+// so segments corrupted on the link can be discarded.
 type TCPAckMessage struct {
 	seq SequenceNumber
+	checksum uint32
 }
 
 func (m TCPAckMessage) String() string {
 	return fmt.Sprintf("ACK(%s)", m.seq)
 }
 
+func (m TCPAckMessage) MessageTag() string {
+	return "tcp-ack"
+}
+
+func (m TCPAckMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.seq))
+	e.WriteUint32(m.checksum)
+}
+
+func (m TCPAckMessage) withChecksum() TCPAckMessage {
+	m.checksum = 0
+	m.checksum = Checksum(m)
+	return m
+}
+
+func (m TCPAckMessage) valid() bool {
+	return m.withChecksum().checksum == m.checksum
+}
+
 type TCPDataMessage struct {
 	Message
 	seq SequenceNumber
+	checksum uint32
 }
 
 func (m TCPDataMessage) String() string {
 	return fmt.Sprintf("DATA(%s, %s)", m.seq, m.Message)
 }
 
+func (m TCPDataMessage) MessageTag() string {
+	return "tcp-data"
+}
+
+func (m TCPDataMessage) Encode(e *Encoder) {
+	e.WriteInt(int(m.seq))
+	e.WriteUint32(m.checksum)
+	e.WriteMessage(m.Message)
+}
+
+func (m TCPDataMessage) withChecksum() TCPDataMessage {
+	m.checksum = 0
+	m.checksum = Checksum(m)
+	return m
+}
+
+func (m TCPDataMessage) valid() bool {
+	return m.withChecksum().checksum == m.checksum
+}
+
+func init() {
+	RegisterMessageType("tcp-ack", func(d *Decoder) Message {
+		m := TCPAckMessage{}
+		m.seq = SequenceNumber(d.ReadInt())
+		m.checksum = d.ReadUint32()
+		return m
+	})
+	RegisterMessageType("tcp-data", func(d *Decoder) Message {
+		m := TCPDataMessage{}
+		m.seq = SequenceNumber(d.ReadInt())
+		m.checksum = d.ReadUint32()
+		m.Message = d.ReadMessage()
+		return m
+	})
+}
+
 func (p *TCPOutboundProcess) innerStep(send func(RoutedMessage)) {
 	p.SenderBufferProcess.Step(
 		func(m RoutedMessage) {
@@ -54,7 +128,7 @@ func (p *TCPOutboundProcess) innerStep(send func(RoutedMessage)) {
 			p.ToSend = append(p.ToSend, TCPDataMessage{
 				Message: m.Message,
 				seq: p.NextSeq,
-			})
+			}.withChecksum())
 			p.NextSeq += 1
 		},
 		func() *RoutedMessage {
@@ -68,14 +142,30 @@ func (p *TCPOutboundProcess) Step(
 	receive func() *RoutedMessage,
 ) {
 	defer p.innerStep(send)
-	for _, messageToSend := range p.ToSend {
-		// TODO: keep window size and only send if in window.
-		// Also track TTL.
-		send(RoutedMessage{
-			Message: messageToSend,
-			From: p.Id(),
-			To: p.DestID,
-		})
+	p.steps += 1
+	window := len(p.ToSend)
+	if window > TCPWindowSize {
+		window = TCPWindowSize
+	}
+	first := p.sent
+	if p.sent > 0 && p.steps-p.lastTransmit >= TCPRetransmitTimeout {
+		// Timed out waiting for an ACK, so go back and resend the window.
+		first = 0
+	}
+	if first < window {
+		for i, messageToSend := range p.ToSend[first:window] {
+			m := RoutedMessage{
+				Message: messageToSend,
+				From: p.Id(),
+				To: p.DestID,
+			}
+			if first+i < p.sent {
+				traceMessage("retransmit", m)
+			}
+			send(m)
+		}
+		p.sent = window
+		p.lastTransmit = p.steps
 	}
 	received := receive()
 	if received == nil {
@@ -85,9 +175,19 @@ func (p *TCPOutboundProcess) Step(
 		panic(fmt.Sprintf("TCP incorrect To or From fields %s", received))
 	}
 	if ack, ok := received.Message.(TCPAckMessage); ok {
+		if !ack.valid() {
+			// corrupted; the retransmitted segment will be ACKed again
+			return
+		}
+		// ACKs are cumulative.
 		for i := 0; i < len(p.ToSend); i++ {
 			if ack.seq == p.ToSend[i].seq {
 				p.ToSend = p.ToSend[i+1:]
+				p.sent -= i + 1
+				if p.sent < 0 {
+					p.sent = 0
+				}
+				p.lastTransmit = p.steps
 				break
 			}
 		}
@@ -118,6 +218,17 @@ func (p *TCPInboundProcess) innerStep(send func(RoutedMessage)) {
 	)
 }
 
+// Acknowledge everything received in order so far.
+func (p *TCPInboundProcess) ack(send func(RoutedMessage)) {
+	send(RoutedMessage{
+		Message: TCPAckMessage{
+			seq: p.NextSeq - 1,
+		}.withChecksum(),
+		From: p.Id(),
+		To: p.SourceID,
+	})
+}
+
 func (p *TCPInboundProcess) Step(
 	send func(RoutedMessage),
 	receive func() *RoutedMessage,
@@ -131,8 +242,22 @@ func (p *TCPInboundProcess) Step(
 		panic(fmt.Sprintf("TCP incorrect To or From fields %s", received))
 	}
 	if data, ok := received.Message.(TCPDataMessage); ok {
+		if !data.valid() {
+			// corrupted; wait for the sender to retransmit
+			return
+		}
+		if data.seq < p.NextSeq {
+			// Already received, so our ACK must have been lost on the way.
+			p.ack(send)
+			return
+		}
 		if data.seq != p.NextSeq {
-			// TODO: keep a cache of data received out of order
+			if data.seq < p.NextSeq+InboundProcessBufferSize {
+				if p.outOfOrder == nil {
+					p.outOfOrder = make(map[SequenceNumber]TCPDataMessage)
+				}
+				p.outOfOrder[data.seq] = data
+			}
 			return
 		}
 		if len(p.Received) >= InboundProcessBufferSize {
@@ -140,13 +265,17 @@ func (p *TCPInboundProcess) Step(
 		}
 		p.Received = append(p.Received, data)
 		p.NextSeq += 1
-		send(RoutedMessage{
-			Message: TCPAckMessage{
-				seq: data.seq,
-			},
-			From: p.Id(),
-			To: p.SourceID,
-		})
+		// Fill in anything that was waiting on this segment.
+		for len(p.Received) < InboundProcessBufferSize {
+			next, ok := p.outOfOrder[p.NextSeq]
+			if !ok {
+				break
+			}
+			delete(p.outOfOrder, p.NextSeq)
+			p.Received = append(p.Received, next)
+			p.NextSeq += 1
+		}
+		p.ack(send)
 	} else {
 		panic(fmt.Sprintf(
 			"TCP unexpected message type %T %s", received.Message, received.Message,
@@ -223,6 +352,16 @@ type MultiTCPProcess struct {
 	inboundProcs map[ProcessID]*TCPInboundProcess
 }
 
+// Peers in id order, so runs don't depend on map iteration.
+func (p *MultiTCPProcess) peers() []ProcessID {
+	peers := make([]ProcessID, 0, len(p.outboundProcs))
+	for pid := range p.outboundProcs {
+		peers = append(peers, pid)
+	}
+	sortProcessIDs(peers)
+	return peers
+}
+
 func (p *MultiTCPProcess) connect(pid ProcessID) {
 	if p.outboundProcs == nil {
 		p.outboundProcs = make(map[ProcessID]*TCPOutboundProcess)
@@ -254,7 +393,8 @@ func (p *MultiTCPProcess) Step(
 		case TCPAckMessage:
 			outboundProc, ok := p.outboundProcs[received.From]
 			if !ok {
-				panic(fmt.Sprintf("Received ACK from %s before sending any messages", received.From))
+				Log(p, fmt.Sprintf("dropping ACK from %s, which was sent nothing", received.From))
+				continue
 			}
 			outboundProc.SenderBufferProcess.pushInput(*received)
 		case TCPDataMessage:
@@ -266,11 +406,11 @@ func (p *MultiTCPProcess) Step(
 			panic(fmt.Sprintf("Received unexpected message type %T %v", received.Message, received.Message))
 		}
 	}
-	for _, outboundProc := range p.outboundProcs {
-		innerTCPStep(outboundProc, send)
+	for _, pid := range p.peers() {
+		innerTCPStep(p.outboundProcs[pid], send)
 	}
-	for _, inboundProc := range p.inboundProcs {
-		innerTCPStep(inboundProc, send)
+	for _, pid := range p.peers() {
+		innerTCPStep(p.inboundProcs[pid], send)
 	}
 	if p.Process == nil {
 		return
@@ -284,8 +424,8 @@ func (p *MultiTCPProcess) Step(
 			sender.toSend = append(sender.toSend, m)
 		},
 		func() *RoutedMessage {
-			for _, inboundProc := range p.inboundProcs {
-				buffer := &inboundProc.ReceiverBufferProcess
+			for _, pid := range p.peers() {
+				buffer := &p.inboundProcs[pid].ReceiverBufferProcess
 				if len(buffer.received) > 0 {
 					m := buffer.received[0]
 					buffer.received = buffer.received[1:]
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Loaders for topologies kept in data files.
+// Nodes named by integers get those ProcessIDs, and nodes with other
+// names are numbered after the largest of them, in the order they first
+// appear. Every loaded node has a Links entry for each of its neighbors,
+// holding the edge's weight and latency if given. Weights are rounded to
+// whole numbers, and positive weights to at least 1.
+// A directed edge from a to b only lets a send to b, and is marked OneWay
+// unless there is also an edge back.
+
+// Nodes are kept by name until the end, when every name is known
+// and ids can be given out.
+type topologyBuilder struct {
+	names []string
+	nodes map[string]struct{}
+	links map[string]map[string]Link
+}
+
+func newTopologyBuilder() *topologyBuilder {
+	return &topologyBuilder{
+		nodes: make(map[string]struct{}),
+		links: make(map[string]map[string]Link),
+	}
+}
+
+// So that "07" and "7" are the same node.
+func canonicalName(name string) string {
+	if id, err := strconv.Atoi(name); err == nil {
+		return strconv.Itoa(id)
+	}
+	return name
+}
+
+func (b *topologyBuilder) addNode(name string) {
+	name = canonicalName(name)
+	if _, ok := b.nodes[name]; !ok {
+		b.nodes[name] = struct{}{}
+		b.names = append(b.names, name)
+	}
+}
+
+func (b *topologyBuilder) addEdge(from, to string, link Link, directed bool) {
+	from, to = canonicalName(from), canonicalName(to)
+	b.addNode(from)
+	b.addNode(to)
+	if b.links[from] == nil {
+		b.links[from] = make(map[string]Link)
+	}
+	b.links[from][to] = link
+	if !directed {
+		b.addEdge(to, from, link, true)
+	}
+}
+
+func (b *topologyBuilder) ids() map[string]ProcessID {
+	ids := make(map[string]ProcessID, len(b.names))
+	last := ProcessID(-1)
+	for _, name := range b.names {
+		if id, err := strconv.Atoi(name); err == nil {
+			ids[name] = ProcessID(id)
+			if ProcessID(id) > last {
+				last = ProcessID(id)
+			}
+		}
+	}
+	for _, name := range b.names {
+		if _, ok := ids[name]; !ok {
+			last++
+			ids[name] = last
+		}
+	}
+	return ids
+}
+
+func (b *topologyBuilder) topology(newProcess func(ProcessID) Process) Topology {
+	ids := b.ids()
+	topo := make(Topology, len(b.nodes))
+	for name := range b.nodes {
+		id := ids[name]
+		neighbors := make(map[ProcessID]struct{}, len(b.links[name]))
+		links := make(map[ProcessID]Link, len(b.links[name]))
+		for neighborName, link := range b.links[name] {
+			neighbor := ids[neighborName]
+			_, back := b.links[neighborName][name]
+			link.OneWay = !back && neighbor != id
+			neighbors[neighbor] = struct{}{}
+			links[neighbor] = link
+		}
+		topo[id] = TopologyNode{
+			Subprocess: newProcess(id),
+			Neighbors: neighbors,
+			Links: links,
+		}
+	}
+	return topo
+}
+
+func roundWeight(weight float64) (int, error) {
+	if math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0, fmt.Errorf("bad weight %g", weight)
+	}
+	rounded := int(math.Round(weight))
+	if weight > 0 && rounded < 1 {
+		rounded = 1
+	}
+	return rounded, nil
+}
+
+// Reads weight and latency attributes, ignoring any others.
+func parseLinkAttributes(attributes map[string]string) (Link, error) {
+	link := Link{}
+	if weight, ok := attributes["weight"]; ok {
+		w, err := strconv.ParseFloat(weight, 64)
+		if err == nil {
+			link.Weight, err = roundWeight(w)
+		}
+		if err != nil {
+			return link, fmt.Errorf("bad weight %q", weight)
+		}
+	}
+	if latency, ok := attributes["latency"]; ok {
+		l, err := time.ParseDuration(latency)
+		if err != nil {
+			return link, fmt.Errorf("bad latency %q", latency)
+		}
+		link.Latency = l
+	}
+	return link, nil
+}
+
+// Picks a loader by extension: .dot or .gv for DOT, .json for JSON,
+// and an edge list for anything else.
+func LoadTopologyFile(path string, newProcess func(ProcessID) Process) (Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var topo Topology
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		topo, err = LoadDOT(f, newProcess)
+	case ".json":
+		topo, err = LoadJSON(f, newProcess)
+	default:
+		topo, err = LoadEdgeList(f, newProcess)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return topo, nil
+}
+
+// One edge per line: "a b" or "a -- b" for an undirected edge,
+// "a -> b" for a directed one, optionally followed by a weight
+// and then a latency such as 10ms. A line with a single id adds a node
+// with no edges. Everything after a # is a comment.
+func LoadEdgeList(r io.Reader, newProcess func(ProcessID) Process) (Topology, error) {
+	b := newTopologyBuilder()
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := parseEdgeListLine(b, fields); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.topology(newProcess), nil
+}
+
+func parseEdgeListLine(b *topologyBuilder, fields []string) error {
+	from := fields[0]
+	if len(fields) == 1 {
+		b.addNode(from)
+		return nil
+	}
+	directed := false
+	rest := fields[1:]
+	switch rest[0] {
+	case "->":
+		directed = true
+		rest = rest[1:]
+	case "--":
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || len(rest) > 3 {
+		return fmt.Errorf("expected an edge, got %q", strings.Join(fields, " "))
+	}
+	to := rest[0]
+	attributes := map[string]string{}
+	if len(rest) > 1 {
+		attributes["weight"] = rest[1]
+	}
+	if len(rest) > 2 {
+		attributes["latency"] = rest[2]
+	}
+	link, err := parseLinkAttributes(attributes)
+	if err != nil {
+		return err
+	}
+	b.addEdge(from, to, link, directed)
+	return nil
+}
+
+// A node named by a JSON number or string.
+type nodeNameJSON string
+
+func (n *nodeNameJSON) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*n = nodeNameJSON(name)
+		return nil
+	}
+	var id int
+	if err := json.Unmarshal(data, &id); err != nil {
+		return fmt.Errorf("node %s is neither a string nor an integer", data)
+	}
+	*n = nodeNameJSON(strconv.Itoa(id))
+	return nil
+}
+
+type topologyJSON struct {
+	Directed bool `json:"directed"`
+	Nodes []nodeNameJSON `json:"nodes"`
+	Edges []struct {
+		From nodeNameJSON `json:"from"`
+		To nodeNameJSON `json:"to"`
+		Weight float64 `json:"weight"`
+		// A duration such as "10ms".
+		Latency string `json:"latency"`
+		// Overrides the graph's Directed for this edge.
+		Directed *bool `json:"directed"`
+	} `json:"edges"`
+}
+
+// Reads {"directed": false, "nodes": [1, 2], "edges": [{"from": 1, "to": 2,
+// "weight": 3, "latency": "10ms"}]}. Nodes only need listing if they have
+// no edges, and each edge may set its own "directed".
+func LoadJSON(r io.Reader, newProcess func(ProcessID) Process) (Topology, error) {
+	var doc topologyJSON
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	b := newTopologyBuilder()
+	for _, name := range doc.Nodes {
+		b.addNode(string(name))
+	}
+	for i, edge := range doc.Edges {
+		attributes := map[string]string{}
+		if edge.Latency != "" {
+			attributes["latency"] = edge.Latency
+		}
+		link, err := parseLinkAttributes(attributes)
+		if err != nil {
+			return nil, fmt.Errorf("edge %d: %s", i, err)
+		}
+		if link.Weight, err = roundWeight(edge.Weight); err != nil {
+			return nil, fmt.Errorf("edge %d: %s", i, err)
+		}
+		directed := doc.Directed
+		if edge.Directed != nil {
+			directed = *edge.Directed
+		}
+		b.addEdge(string(edge.From), string(edge.To), link, directed)
+	}
+	return b.topology(newProcess), nil
+}
+
+// Reads the common subset of Graphviz DOT: a graph or digraph of node
+// and edge statements, with chains like "1 -- 2 -- 3" and attribute lists.
+// Edge weight and latency attributes become the link's; other attributes,
+// and graph, node and edge defaults, are ignored. Subgraphs are not supported.
+func LoadDOT(r io.Reader, newProcess func(ProcessID) Process) (Topology, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := dotTokens(string(source))
+	if err != nil {
+		return nil, err
+	}
+	p := &dotParser{tokens: tokens, builder: newTopologyBuilder()}
+	if err := p.parseGraph(); err != nil {
+		return nil, err
+	}
+	return p.builder.topology(newProcess), nil
+}
+
+type dotToken struct {
+	text string
+	// Quoted strings are never keywords or punctuation.
+	quoted bool
+	line int
+}
+
+func dotTokens(source string) ([]dotToken, error) {
+	var tokens []dotToken
+	line := 1
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(c):
+			i++
+		case c == '#' || (c == '/' && i+1 < len(runes) && runes[i+1] == '/'):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			start := line
+			for i += 2; i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/'); i++ {
+				if runes[i] == '\n' {
+					line++
+				}
+			}
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("line %d: unterminated comment", start)
+			}
+			i += 2
+		case c == '"':
+			var text strings.Builder
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				if runes[i] == '\n' {
+					line++
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			i++
+			tokens = append(tokens, dotToken{text: text.String(), quoted: true, line: line})
+		case c == '-' && i+1 < len(runes) && (runes[i+1] == '-' || runes[i+1] == '>'):
+			tokens = append(tokens, dotToken{text: string(runes[i : i+2]), line: line})
+			i += 2
+		case strings.ContainsRune("{}[]=;,:", c):
+			tokens = append(tokens, dotToken{text: string(c), line: line})
+			i++
+		case c == '_' || c == '.' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '.' ||
+				unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				(i == start && runes[i] == '-')) {
+				i++
+			}
+			tokens = append(tokens, dotToken{text: string(runes[start:i]), line: line})
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+		}
+	}
+	return tokens, nil
+}
+
+type dotParser struct {
+	tokens []dotToken
+	pos int
+	directed bool
+	builder *topologyBuilder
+}
+
+func (p *dotParser) peek() (dotToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return dotToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// Whether the next token is the unquoted text, consuming it if so.
+func (p *dotParser) accept(text string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *dotParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if t, ok := p.peek(); ok {
+		line = t.line
+	} else if len(p.tokens) > 0 {
+		line = p.tokens[len(p.tokens)-1].line
+	}
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *dotParser) id() (string, error) {
+	t, ok := p.peek()
+	if !ok || (!t.quoted && strings.ContainsAny(t.text, "{}[]=;,:") && len(t.text) == 1) ||
+		(!t.quoted && (t.text == "--" || t.text == "->")) {
+		return "", p.errorf("expected an id")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *dotParser) parseGraph() error {
+	p.accept("strict")
+	if p.accept("digraph") {
+		p.directed = true
+	} else if err := p.expect("graph"); err != nil {
+		return err
+	}
+	if t, ok := p.peek(); ok && (t.quoted || t.text != "{") {
+		p.pos++
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.accept("}") {
+		if _, ok := p.peek(); !ok {
+			return p.errorf("expected \"}\"")
+		}
+		if err := p.parseStatement(); err != nil {
+			return err
+		}
+		p.accept(";")
+	}
+	if _, ok := p.peek(); ok {
+		return p.errorf("unexpected text after graph")
+	}
+	return nil
+}
+
+func (p *dotParser) parseStatement() error {
+	if p.accept("subgraph") {
+		return p.errorf("subgraphs are not supported")
+	}
+	if t, ok := p.peek(); ok && !t.quoted &&
+		(strings.EqualFold(t.text, "graph") || strings.EqualFold(t.text, "node") ||
+			strings.EqualFold(t.text, "edge")) {
+		p.pos++
+		_, err := p.parseAttributes()
+		return err
+	}
+	first, err := p.id()
+	if err != nil {
+		return err
+	}
+	if p.accept("=") {
+		// A graph attribute.
+		_, err := p.id()
+		return err
+	}
+	ids := []string{first}
+	for {
+		if p.accept("->") {
+			if !p.directed {
+				return p.errorf("-> in an undirected graph")
+			}
+		} else if p.accept("--") {
+			if p.directed {
+				return p.errorf("-- in a digraph")
+			}
+		} else {
+			break
+		}
+		next, err := p.id()
+		if err != nil {
+			return err
+		}
+		ids = append(ids, next)
+	}
+	attributes, err := p.parseAttributes()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 1 {
+		p.builder.addNode(ids[0])
+		return nil
+	}
+	link, err := parseLinkAttributes(attributes)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	for i := 1; i < len(ids); i++ {
+		p.builder.addEdge(ids[i-1], ids[i], link, p.directed)
+	}
+	return nil
+}
+
+// Any number of [a=b, c=d; e=f] lists.
+func (p *dotParser) parseAttributes() (map[string]string, error) {
+	attributes := map[string]string{}
+	for p.accept("[") {
+		for !p.accept("]") {
+			key, err := p.id()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := p.id()
+			if err != nil {
+				return nil, err
+			}
+			attributes[strings.ToLower(key)] = value
+			if !p.accept(",") {
+				p.accept(";")
+			}
+		}
+	}
+	return attributes, nil
+}
+
+// Writes topo as DOT that LoadDOT reads back. It is an undirected graph
+// when every link goes both ways with the same properties, else a digraph.
+func WriteDOT(w io.Writer, topo Topology) error {
+	ids := sortedIDs(topo)
+	directed := false
+	for _, id := range ids {
+		for neighbor := range topo[id].Neighbors {
+			if neighbor == id {
+				continue
+			}
+			if _, ok := topo[neighbor].Neighbors[id]; !ok || topo[id].Links[neighbor] != topo[neighbor].Links[id] {
+				directed = true
+			}
+		}
+	}
+	kind, op := "graph", "--"
+	if directed {
+		kind, op = "digraph", "->"
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "%s {\n", kind)
+	for _, id := range ids {
+		isolated := true
+		neighbors := make([]int, 0, len(topo[id].Neighbors))
+		for neighbor := range topo[id].Neighbors {
+			if neighbor == id {
+				continue
+			}
+			isolated = false
+			if directed || neighbor > id {
+				neighbors = append(neighbors, int(neighbor))
+			}
+		}
+		sort.Ints(neighbors)
+		if isolated {
+			fmt.Fprintf(out, "\t%d;\n", int(id))
+		}
+		for _, neighbor := range neighbors {
+			fmt.Fprintf(out, "\t%d %s %d", int(id), op, neighbor)
+			var attributes []string
+			link := topo[id].Links[ProcessID(neighbor)]
+			if link.Weight > 0 {
+				attributes = append(attributes, fmt.Sprintf("weight=%d", link.Weight))
+			}
+			if link.Latency > 0 {
+				attributes = append(attributes, fmt.Sprintf("latency=%q", link.Latency))
+			}
+			if len(attributes) > 0 {
+				fmt.Fprintf(out, " [%s]", strings.Join(attributes, ", "))
+			}
+			fmt.Fprintf(out, ";\n")
+		}
+	}
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
+
+// A conversation between the lowest and highest ids of a topology file,
+// routed by Bellman-Ford over the file's link weights.
+type TopologyFileScenario struct {
+	Path string
+}
+
+func (s TopologyFileScenario) Network() Topology {
+	loaded, err := LoadTopologyFile(s.Path, func(id ProcessID) Process {
+		return SimpleProcess{ID: id}
+	})
+	if err != nil {
+		panic(fmt.Sprintf("cannot load topology: %s", err))
+	}
+	ids := sortedIDs(loaded)
+	if len(ids) == 0 {
+		panic(fmt.Sprintf("topology file %s has no nodes", s.Path))
+	}
+	newProcess := conversationEnds(ids[0], ids[len(ids)-1])
+	topo := make(Topology, len(loaded))
+	for id, node := range loaded {
+		topo[id] = NewWeightedBellmanFordTopologyNode(newProcess(id), node.Links)
+	}
+	return topo
+}
+
+func (s TopologyFileScenario) Check(c Cluster) error {
+	if err := conversationsComplete(c); err != nil {
+		return err
+	}
+	return routesConverged(c, s.Network())
+}
+
+func (s TopologyFileScenario) Invariants() []Invariant {
+	return []Invariant{routesNeverShorter(s.Network())}
+}
+
+func init() {
+	RegisterScenario("topology-file", "Bellman-Ford over the graph in TopologyFile", func(params ScenarioParams) Scenario {
+		return TopologyFileScenario{Path: params.TopologyFile}
+	})
+}
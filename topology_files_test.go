@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadNamedNodesAndFloatWeights(t *testing.T) {
+	dot := `graph routers {
+		"berlin" -- 3 [weight=2.6];
+		3 -- paris [weight=0.2];
+		paris -- berlin;
+	}`
+	topo, err := LoadDOT(strings.NewReader(dot), placeholderProcess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// berlin and paris come after the largest integer id, in order.
+	if len(topo) != 3 || topo[3].Links[4].Weight != 3 || topo[3].Links[5].Weight != 1 {
+		t.Errorf("got %v", topo)
+	}
+	if _, ok := topo[5].Neighbors[4]; !ok {
+		t.Errorf("paris and berlin are not linked: %v", topo)
+	}
+
+	json := `{"nodes": ["a"], "edges": [{"from": "a", "to": 7, "weight": 1.5}]}`
+	topo, err = LoadJSON(strings.NewReader(json), placeholderProcess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topo) != 2 || topo[8].Links[7].Weight != 2 {
+		t.Errorf("got %v", topo)
+	}
+
+	if _, err := LoadEdgeList(strings.NewReader("a b NaN\n"), placeholderProcess); err == nil {
+		t.Error("loaded a weight of NaN")
+	}
+}
+
+func TestEmptyTopologyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "empty.txt")
+	if err := ioutil.WriteFile(path, []byte("# nothing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "has no nodes") {
+			t.Errorf("got %v", r)
+		}
+	}()
+	TopologyFileScenario{Path: path}.Network()
+}
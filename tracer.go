@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// What a running cluster does, as a stream of events, for watching it
+// from outside. Every message sent, received and lost goes to each
+// Tracer added, from whichever goroutine the process runs in.
+type TraceEvent struct {
+	// "send", "receive" and "drop" of a message from From to To,
+	// "retransmit" when a transport sends From's message to To again,
+	// "link" when the link from From to To is cut or restored,
+	// "topology" when links or nodes are added or removed,
+	// "state" when From's state, as the dashboard shows it, changes,
+	// and "round" and "done" from a Simulation.
+	Kind string `json:"kind"`
+	From ProcessID `json:"from"`
+	To ProcessID `json:"to"`
+	Message string `json:"message,omitempty"`
+	// The message's type, and the size of its encoding, if it has one.
+	Type string `json:"type,omitempty"`
+	Bytes int `json:"bytes,omitempty"`
+	Up bool `json:"up,omitempty"`
+	State string `json:"state,omitempty"`
+	Round int `json:"round,omitempty"`
+	Elapsed time.Duration `json:"elapsed,omitempty"`
+}
+
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+var tracersMutex sync.Mutex
+var tracers []Tracer
+
+// How many tracers there are, read without the lock so that sending
+// costs nothing extra when nobody is watching.
+var tracing int32
+
+func AddTracer(t Tracer) {
+	tracersMutex.Lock()
+	defer tracersMutex.Unlock()
+	tracers = append(tracers, t)
+	atomic.StoreInt32(&tracing, int32(len(tracers)))
+}
+
+func RemoveTracer(t Tracer) {
+	tracersMutex.Lock()
+	defer tracersMutex.Unlock()
+	for i, tracer := range tracers {
+		if tracer == t {
+			tracers = append(tracers[:i:i], tracers[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&tracing, int32(len(tracers)))
+}
+
+func trace(event TraceEvent) {
+	if atomic.LoadInt32(&tracing) == 0 {
+		return
+	}
+	tracersMutex.Lock()
+	current := tracers
+	tracersMutex.Unlock()
+	for _, t := range current {
+		t.Trace(event)
+	}
+}
+
+// Traces what happened to m, describing it only if somebody is watching.
+func traceMessage(kind string, m RoutedMessage) {
+	if atomic.LoadInt32(&tracing) == 0 {
+		return
+	}
+	event := TraceEvent{Kind: kind, From: m.From, To: m.To, Type: messageType(m.Message)}
+	if m.Message != nil {
+		event.Message = m.Message.String()
+	}
+	if encoded, err := EncodeMessage(m); err == nil {
+		event.Bytes = len(encoded)
+	}
+	trace(event)
+}
+
+// The tag of an encodable message, or else its Go type.
+func messageType(m Message) string {
+	if m == nil {
+		return "none"
+	}
+	if encodable, ok := m.(EncodableMessage); ok {
+		return encodable.MessageTag()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", m), "main.")
+}
+
+// Writes each event as a line of JSON.
+type JSONTracer struct {
+	mutex sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{encoder: json.NewEncoder(w)}
+}
+
+func (t *JSONTracer) Trace(event TraceEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// A tracer has nowhere to report its own errors.
+	_ = t.encoder.Encode(event)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Every problem found with a topology, so they can all be fixed at once.
+type TopologyError struct {
+	Problems []string
+}
+
+func (e *TopologyError) Error() string {
+	return fmt.Sprintf("invalid topology: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *TopologyError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *TopologyError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Checks what CreateCluster needs: every node has a subprocess with
+// the node's id, every neighbor is a node of the topology, and links
+// only go one way if they are marked OneWay.
+func CheckTopology(topo Topology) error {
+	e := &TopologyError{}
+	checkNodes(topo, e)
+	return e.orNil()
+}
+
+// CheckTopology, and also that all nodes are connected,
+// ignoring the direction of links.
+func ValidateTopology(topo Topology) error {
+	e := &TopologyError{}
+	checkNodes(topo, e)
+	if components := Components(topo); len(components) > 1 {
+		descriptions := make([]string, len(components))
+		for i, component := range components {
+			descriptions[i] = fmt.Sprintf("%v", component)
+		}
+		e.add("%d disconnected components: %s", len(components), strings.Join(descriptions, ", "))
+	}
+	return e.orNil()
+}
+
+func checkNodes(topo Topology, e *TopologyError) {
+	for _, id := range sortedIDs(topo) {
+		node := topo[id]
+		if node.Subprocess == nil {
+			e.add("%s has no subprocess", id)
+		} else if node.Subprocess.Id() != id {
+			e.add("%s has subprocess %s", id, node.Subprocess.Id())
+		}
+		for _, neighbor := range sortedNeighbors(node) {
+			other, ok := topo[neighbor]
+			if !ok {
+				e.add("%s has neighbor %s, which is not in the topology", id, neighbor)
+				continue
+			}
+			if neighbor == id {
+				continue
+			}
+			_, linkedBack := other.Neighbors[id]
+			if oneWay := node.Links[neighbor].OneWay; linkedBack && oneWay {
+				e.add("%s's link to %s is OneWay, but %s links back", id, neighbor, neighbor)
+			} else if !linkedBack && !oneWay {
+				e.add("%s links to %s, but not the other way; mark the link OneWay if intended", id, neighbor)
+			}
+		}
+		for _, neighbor := range sortedNeighbors(TopologyNode{Neighbors: linkIDs(node.Links)}) {
+			if _, ok := node.Neighbors[neighbor]; !ok {
+				e.add("%s has a link to %s, which is not its neighbor", id, neighbor)
+			}
+		}
+	}
+}
+
+func sortedNeighbors(node TopologyNode) []ProcessID {
+	neighbors := make([]ProcessID, 0, len(node.Neighbors))
+	for neighbor := range node.Neighbors {
+		neighbors = append(neighbors, neighbor)
+	}
+	sortProcessIDs(neighbors)
+	return neighbors
+}
+
+func linkIDs(links map[ProcessID]Link) map[ProcessID]struct{} {
+	ids := make(map[ProcessID]struct{}, len(links))
+	for id := range links {
+		ids[id] = struct{}{}
+	}
+	return ids
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A parser for the subset of YAML that scenario configs need:
+// block mappings and sequences nested by indentation, flow sequences
+// and mappings on one line like [1, 2] and {a: 1}, quoted and plain
+// scalars, and # comments. Anchors, tags, multi-line strings and
+// multiple documents are not supported.
+// Mappings become map[string]interface{} and sequences []interface{},
+// as encoding/json would decode them.
+
+type yamlLine struct {
+	number int
+	indent int
+	text string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos int
+}
+
+func ParseYAML(source string) (interface{}, error) {
+	lines, err := yamlLines(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	value, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return value, nil
+}
+
+func yamlLines(source string) ([]yamlLine, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(source, "\n") {
+		raw = strings.TrimRight(stripYAMLComment(raw), " \r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(raw) - len(text), text: text})
+	}
+	return lines, nil
+}
+
+// Drops a # comment, unless the # is inside quotes or part of a word.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	number := 0
+	if p.pos < len(p.lines) {
+		number = p.lines[p.pos].number
+	} else if len(p.lines) > 0 {
+		number = p.lines[len(p.lines)-1].number
+	}
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isSequenceItem(line.text) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		if _, _, ok := splitYAMLKey(rest); ok {
+			// A mapping that starts on the item's line: carry on
+			// as if it started on a line of its own.
+			itemIndent := indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine{number: line.number, indent: itemIndent, text: rest}
+			item, err := p.mapping(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		p.pos++
+		item, err := parseYAMLValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line.number, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isSequenceItem(line.text) {
+			break
+		}
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, p.errorf("expected \"key: value\", got %q", line.text)
+		}
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+		if rest != "" {
+			value, err := parseYAMLValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line.number, err)
+			}
+			m[key] = value
+			continue
+		}
+		// A sequence may sit at the same indentation as its key.
+		if p.pos < len(p.lines) && p.lines[p.pos].indent == indent &&
+			isSequenceItem(p.lines[p.pos].text) {
+			value, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+			continue
+		}
+		value, err := p.nested(indent)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// The block indented under the line before, or null if there is none.
+func (p *yamlParser) nested(parentIndent int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= parentIndent {
+		return nil, nil
+	}
+	return p.block(p.lines[p.pos].indent)
+}
+
+// Splits "key: value" or "key:" outside of quotes and brackets.
+func splitYAMLKey(text string) (key string, rest string, ok bool) {
+	var quote rune
+	depth := 0
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ':' && depth == 0 && (i+1 == len(text) || text[i+1] == ' '):
+			key = strings.TrimSpace(text[:i])
+			if unquoted, err := parseYAMLScalar(key); err == nil {
+				if s, isString := unquoted.(string); isString {
+					key = s
+				}
+			}
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// A scalar, or a flow sequence or mapping.
+func parseYAMLValue(text string) (interface{}, error) {
+	f := &yamlFlow{text: text}
+	value, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	f.skipSpaces()
+	if f.pos < len(f.text) {
+		return nil, fmt.Errorf("unexpected %q", f.text[f.pos:])
+	}
+	return value, nil
+}
+
+type yamlFlow struct {
+	text string
+	pos int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpaces()
+	if f.pos >= len(f.text) {
+		return nil, nil
+	}
+	switch f.text[f.pos] {
+	case '[':
+		return f.collection(']')
+	case '{':
+		return f.collection('}')
+	}
+	return f.scalar(",]}")
+}
+
+// Reads up to one of the terminators, or the end of the text
+// when not inside a flow collection.
+func (f *yamlFlow) scalar(terminators string) (interface{}, error) {
+	start := f.pos
+	if c := f.text[f.pos]; c == '"' || c == '\'' {
+		f.pos++
+		for f.pos < len(f.text) {
+			if f.text[f.pos] == c {
+				// '' is an escaped quote inside single quotes.
+				if c == '\'' && f.pos+1 < len(f.text) && f.text[f.pos+1] == '\'' {
+					f.pos += 2
+					continue
+				}
+				break
+			}
+			if c == '"' && f.text[f.pos] == '\\' {
+				f.pos++
+			}
+			f.pos++
+		}
+		if f.pos >= len(f.text) {
+			return nil, fmt.Errorf("unterminated string %s", f.text[start:])
+		}
+		f.pos++
+	} else {
+		for f.pos < len(f.text) && !strings.ContainsRune(terminators, rune(f.text[f.pos])) {
+			f.pos++
+		}
+	}
+	return parseYAMLScalar(strings.TrimSpace(f.text[start:f.pos]))
+}
+
+func (f *yamlFlow) collection(end byte) (interface{}, error) {
+	f.pos++
+	items := []interface{}{}
+	m := map[string]interface{}{}
+	for {
+		f.skipSpaces()
+		if f.pos >= len(f.text) {
+			return nil, fmt.Errorf("missing %q", end)
+		}
+		if f.text[f.pos] == end {
+			f.pos++
+			break
+		}
+		start := f.pos
+		if end == '}' {
+			key, err := f.scalar(":,}")
+			if err != nil {
+				return nil, err
+			}
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, fmt.Errorf("expected \":\" after %v", key)
+			}
+			f.pos++
+			value, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+		} else {
+			item, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		f.skipSpaces()
+		if f.pos < len(f.text) && f.text[f.pos] == ',' {
+			f.pos++
+		} else if f.pos == start {
+			// Such as a "}" closing a sequence.
+			return nil, fmt.Errorf("unexpected %q", f.text[f.pos])
+		}
+	}
+	if end == '}' {
+		return m, nil
+	}
+	return items, nil
+}
+
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case text == "" || text == "~" || text == "null":
+		return nil, nil
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case strings.HasPrefix(text, "\""):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		source string
+		want interface{}
+	}{
+		{"a: 1\nb: two # comment\n", map[string]interface{}{"a": int64(1), "b": "two"}},
+		{"- x\n- y: 1\n  z: [1, 'it''s', {q: \"a#b\"}]\n", []interface{}{
+			"x",
+			map[string]interface{}{
+				"y": int64(1),
+				"z": []interface{}{int64(1), "it's", map[string]interface{}{"q": "a#b"}},
+			},
+		}},
+		{"a:\n- 1.5\n- true\n- ~\nb:\n  c: d\n", map[string]interface{}{
+			"a": []interface{}{1.5, true, nil},
+			"b": map[string]interface{}{"c": "d"},
+		}},
+	}
+	for _, test := range tests {
+		got, err := ParseYAML(test.source)
+		if err != nil {
+			t.Errorf("%q: %s", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, source := range []string{
+		"a: [1, 2\n",
+		"a: 1\na: 2\n",
+		"a: 1\n  b: 2\n",
+		"just text\n",
+		"a: [}]\n",
+		"a: [1, }\n",
+	} {
+		if _, err := ParseYAML(source); err == nil {
+			t.Errorf("%q: no error", source)
+		}
+	}
+}